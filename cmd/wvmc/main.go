@@ -1,27 +1,37 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/anaxita/wvmc/internal/wvmc/cache"
+	"github.com/anaxita/wvmc/internal/wvmc/jobs"
 	"github.com/anaxita/wvmc/internal/wvmc/notice"
+	"github.com/anaxita/wvmc/internal/wvmc/prom"
+	"github.com/anaxita/wvmc/internal/wvmc/schedule"
 	"github.com/joho/godotenv"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/anaxita/logit"
 	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
 	"github.com/anaxita/wvmc/internal/wvmc/server"
 	"github.com/anaxita/wvmc/internal/wvmc/store"
 )
 
 var envPath string
+var commanderType string
 
 func main() {
 	flag.StringVar(&envPath, "e", ".env", "path to .env")
+	flag.StringVar(&commanderType, "commander", "",
+		"commands executor (overrides COMMANDER from .env, default pwsh): pwsh - new powershell process per command, pool - pool of long-lived "+
+			"powershell processes, simulator - in-memory Hyper-V simulator")
 	flag.Parse()
 
 	err := godotenv.Load(envPath)
@@ -51,22 +61,124 @@ func main() {
 	}
 
 	repository := store.New(db)
-	cacheService := cache.NewCacheService()
 
-	serviceServer := control.NewServerService(new(control.Command), cacheService)
+	// гипервизоры из HV_LIST добавляются в таблицу hypervs, дальше список гипервизоров берется из нее
+	if err = repository.Hyperv(context.Background()).AddMissing(control.Hosts()); err != nil {
+		logit.Fatal("Ошибка добавления гипервизоров", err)
+	}
+
+	cacheService := cache.NewCacheService(cache.TTLFromEnv())
+
+	// ВМ, сохраненные до перезапуска, отдаются как устаревшие, пока гипервизоры не ответят
+	restoreCache(repository, cacheService)
+
+	// изменения ВМ в кеше сохраняются в журнал событий
+	cacheService.Subscribe(func(events []model.Event) {
+		if err := repository.Event(context.Background()).Create(events); err != nil {
+			logit.Log("Не удалось сохранить события", err)
+		}
+	})
+
+	cacheService.SubscribeRefresh(func(hv string, servers []model.Server, at time.Time) {
+		if err := repository.Cache(context.Background()).Save(hv, servers, at); err != nil {
+			logit.Log("Не удалось сохранить кеш гипервизора", hv, err)
+		}
+	})
+
+	// показатели ВМ сохраняются при каждом обновлении данных гипервизора
+	cacheService.SubscribeRefresh(func(hv string, servers []model.Server, at time.Time) {
+		if err := repository.Metric(context.Background()).Create(servers, at); err != nil {
+			logit.Log("Не удалось сохранить показатели ВМ", hv, err)
+		}
+	})
+
+	go func() {
+		for {
+			if err := repository.Metric(context.Background()).Compact(time.Now()); err != nil {
+				logit.Log("Не удалось усреднить показатели ВМ", err)
+			}
+
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	if commanderType == "" {
+		commanderType = os.Getenv("COMMANDER")
+	}
+
+	if commanderType == "" {
+		commanderType = "pwsh"
+	}
+
+	var commander control.Commander
+
+	switch commanderType {
+	case "pwsh":
+		commander = new(control.Command)
+	case "pool":
+		pool := control.NewPool(control.PoolConfigFromEnv())
+		defer pool.Close()
+
+		commander = pool
+	case "simulator":
+		logit.Info("Используется симулятор Hyper-V")
+		simulator := control.NewSimulator(control.ParseList(os.Getenv("HV_LIST"))...)
+		if latency, err := time.ParseDuration(os.Getenv("SIMULATOR_LATENCY")); err == nil {
+			simulator.SetLatency(latency)
+		}
+
+		for _, hv := range control.ParseList(os.Getenv("SIMULATOR_DOWN_HOSTS")) {
+			simulator.SetHostDown(hv, true)
+		}
+
+		commander = simulator
+	default:
+		logit.Fatal("Неизвестный тип commander:", commanderType)
+	}
+
+	commander = control.NewHostGuard(commander, control.HostGuardConfigFromEnv())
+
+	scripts, err := control.LoadScripts(os.Getenv("PWSH_SCRIPTS_DIR"))
+	if err != nil {
+		logit.Fatal("Ошибка загрузки скриптов powershell", err)
+	}
+	defer scripts.Close()
+
+	scripts.LogInfo()
+
+	serviceServer := control.NewServerService(commander, cacheService, scripts,
+		control.TimeoutsFromEnv(), func(ctx context.Context) ([]model.Hypervisor, error) {
+			return repository.Hyperv(ctx).Enabled()
+		})
+
+	// метрики кеша и ВМ собираются при каждом запросе /metrics
+	prom.Default.Collect(serviceServer.Metrics)
+
 	noticeService := notice.NewNoticeService()
-	s := server.New(repository, serviceServer, noticeService)
+	jobService := jobs.NewJobService(repository, jobs.WorkersFromEnv())
+	s := server.New(repository, serviceServer, noticeService, jobService, schedule.NewScheduler(repository))
 
 	go func() {
 		s.UpdateAllServersInfo()(httptest.NewRecorder(), &http.Request{})
 
 		for {
-			time.Sleep(time.Minute * 1)
+			time.Sleep(cacheService.TTL())
 
-			_, err := serviceServer.GetServersDataForAdmins()
+			_, err := serviceServer.RefreshServers(context.Background(), nil)
 			if err != nil {
 				logit.Log("update cache servers: ", err)
 			}
+
+			hvs, err := serviceServer.HostNames(context.Background())
+			if err != nil {
+				logit.Log("update cache hypervisors: ", err)
+				continue
+			}
+
+			_, err = serviceServer.RefreshHostsInventory(context.Background(), hvs)
+			if err != nil {
+				logit.Log("update cache hypervisors: ", err)
+			}
 		}
 	}()
 
@@ -74,3 +186,27 @@ func main() {
 		logit.Fatal("Ошибка запуска сервер", err)
 	}
 }
+
+// restoreCache загружает в кеш ВМ включенных гипервизоров, сохраненные при последнем обновлении
+func restoreCache(repository *store.Store, cacheService *cache.CacheService) {
+	hvs, err := repository.Hyperv(context.Background()).Enabled()
+	if err != nil {
+		logit.Log("Не удалось восстановить кеш", err)
+		return
+	}
+
+	snapshots, err := repository.Cache(context.Background()).All()
+	if err != nil {
+		logit.Log("Не удалось восстановить кеш", err)
+		return
+	}
+
+	for _, snapshot := range snapshots {
+		for _, hv := range hvs {
+			if strings.EqualFold(hv.Name, snapshot.HV) {
+				logit.Info("Восстанавливаем кеш гипервизора", snapshot.HV, "от", snapshot.UpdatedAt)
+				cacheService.Restore(hv.Name, snapshot.Servers, snapshot.UpdatedAt)
+			}
+		}
+	}
+}
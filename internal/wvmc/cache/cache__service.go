@@ -1,79 +1,514 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"github.com/anaxita/logit"
 	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/anaxita/wvmc/internal/wvmc/prom"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// DefaultTTL время, после которого данные гипервизора в кеше считаются устаревшими
+const DefaultTTL = time.Minute
+
+var refreshDuration = prom.NewHistogramVec("wvmc_cache_refresh_duration_seconds",
+	"Duration of hypervisor cache refreshes.", prom.SlowBuckets, "hv")
+
+// HostInfo состояние данных гипервизора в кеше, Age - возраст данных в секундах
+type HostInfo struct {
+	HV         string    `json:"hv"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Age        int64     `json:"age"`
+	Stale      bool      `json:"stale"`
+	Refreshing bool      `json:"refreshing"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// hostEntry ВМ гипервизора по их VMID и время их получения.
+// restored - данные восстановлены из БД при запуске и еще не обновлялись с гипервизора.
+type hostEntry struct {
+	hv         string
+	servers    map[string]model.Server
+	updatedAt  time.Time
+	refreshing bool
+	restored   bool
+	err        string
+
+	// refreshStarted начало текущего обновления, refreshDone закрывается по его окончании
+	refreshStarted time.Time
+	refreshDone    chan struct{}
+}
+
 type CacheService struct {
 	mu      sync.RWMutex
-	servers []model.Server
+	ttl     time.Duration
+	entries map[string]*hostEntry
+	hosts   []model.HostInventory
+	// removed гипервизоры, удаленные из кеша. Обновления, закончившиеся после удаления,
+	// не возвращают их данные в кеш.
+	removed     map[string]bool
+	subscribers []func(events []model.Event)
+	refreshed   []func(hv string, servers []model.Server, at time.Time)
+}
+
+// NewCacheService создает кеш, данные гипервизоров в котором устаревают через ttl
+func NewCacheService(ttl time.Duration) *CacheService {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &CacheService{ttl: ttl, entries: make(map[string]*hostEntry), removed: make(map[string]bool)}
+}
+
+// TTLFromEnv возвращает время жизни данных кеша из переменной окружения CACHE_TTL (например 90s),
+// если она не задана или неверна - DefaultTTL
+func TTLFromEnv() time.Duration {
+	v := os.Getenv("CACHE_TTL")
+	if v == "" {
+		return DefaultTTL
+	}
+
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		logit.Log("Неверное значение CACHE_TTL", v, "используем", DefaultTTL)
+		return DefaultTTL
+	}
+
+	return ttl
 }
 
-func NewCacheService() *CacheService {
-	return &CacheService{}
+// TTL возвращает время, после которого данные гипервизора устаревают
+func (c *CacheService) TTL() time.Duration {
+	return c.ttl
 }
 
+// Servers возвращает ВМ всех гипервизоров в кеше, либо nil, если кеш пуст
 func (c *CacheService) Servers() []model.Server {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	if len(c.servers) == 0 {
+	servers := make([]model.Server, 0)
+	for _, e := range c.entries {
+		servers = append(servers, e.list()...)
+	}
+
+	if len(servers) == 0 {
 		return nil
 	}
 
-	return c.servers
+	sortServers(servers)
+
+	return servers
+}
+
+// HostServers возвращает ВМ гипервизоров hvs, которые есть в кеше.
+// ВМ гипервизоров, которые не ответили при последнем обновлении,
+// возвращаются со статусом model.ServerStatusHVUnreachable.
+func (c *CacheService) HostServers(hvs []string) []model.Server {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	servers := make([]model.Server, 0)
+	for _, hv := range hvs {
+		if e, ok := c.entries[key(hv)]; ok {
+			servers = append(servers, e.list()...)
+		}
+	}
+
+	sortServers(servers)
+
+	return servers
 }
 
-func (c *CacheService) SetServers(s []model.Server) {
+// SetHostServers заменяет ВМ гипервизора hv и отмечает данные как только что полученные.
+// Если данные гипервизора уже были в кеше, подписчики получают события изменения ВМ.
+// Подписчики SubscribeRefresh получают ВМ гипервизора при каждом обновлении.
+func (c *CacheService) SetHostServers(hv string, servers []model.Server) {
 	c.mu.Lock()
-	c.servers = s
+
+	if c.removed[key(hv)] {
+		c.mu.Unlock()
+		return
+	}
+
+	e := c.entry(hv)
+	before := e.servers
+	loaded := !e.updatedAt.IsZero()
+
+	e.servers = make(map[string]model.Server, len(servers))
+	e.updatedAt = time.Now()
+	e.restored = false
+	e.err = ""
+
+	for _, s := range servers {
+		e.servers[s.VMID] = s
+	}
+
+	var events []model.Event
+	if loaded {
+		events = diffHost(hv, before, e.servers, e.updatedAt)
+	}
+
+	refreshed := c.refreshed
+	at := e.updatedAt
+
 	c.mu.Unlock()
+
+	c.publish(events)
+
+	for _, fn := range refreshed {
+		fn(hv, servers, at)
+	}
 }
 
-func (c *CacheService) SetServerState(s model.Server, state model.ServerState) {
-	logit.Info(fmt.Printf("Меняем статус сервера ID %d NAME %s HV %s на %s", s.ID, s.Name, s.HV,
-		state))
+// Restore добавляет ВМ гипервизора hv, сохраненные в момент updatedAt, если его данных еще нет в кеше.
+// Восстановленные данные считаются устаревшими до первого обновления с гипервизора,
+// при котором находятся изменения ВМ за время, пока wvmc не работал.
+func (c *CacheService) Restore(hv string, servers []model.Server, updatedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.removed[key(hv)] {
+		return
+	}
+
+	e := c.entry(hv)
+	if !e.updatedAt.IsZero() {
+		return
+	}
+
+	e.servers = make(map[string]model.Server, len(servers))
+	e.updatedAt = updatedAt
+	e.restored = true
+
+	for _, s := range servers {
+		e.servers[s.VMID] = s
+	}
+}
+
+// SetHostError отмечает, что гипервизор hv не ответил, его ВМ остаются в кеше
+func (c *CacheService) SetHostError(hv string, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.servers == nil {
+	if c.removed[key(hv)] {
 		return
 	}
 
-	for i, v := range c.servers {
-		if v.Name == s.Name &&
-			v.HV == s.HV {
-			logit.Info("Успешно сменили статус")
+	c.entry(hv).err = err.Error()
+}
+
+// Loaded проверяет, есть ли в кеше данные гипервизора hv
+func (c *CacheService) Loaded(hv string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[key(hv)]
+
+	return ok && !e.updatedAt.IsZero()
+}
+
+// Expired проверяет, устарели ли данные гипервизора hv. Восстановленные данные всегда устаревшие.
+func (c *CacheService) Expired(hv string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[key(hv)]
+
+	return !ok || e.restored || time.Since(e.updatedAt) > c.ttl
+}
+
+// StartRefresh отмечает начало обновления данных гипервизора hv.
+// Возвращает false, если гипервизор уже обновляется или удален из кеша.
+func (c *CacheService) StartRefresh(hv string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.removed[key(hv)] {
+		return false
+	}
+
+	e := c.entry(hv)
+	if e.refreshing {
+		return false
+	}
+
+	e.refreshing = true
+	e.refreshStarted = time.Now()
+	e.refreshDone = make(chan struct{})
+
+	return true
+}
+
+// FinishRefresh отмечает окончание обновления данных гипервизора hv
+func (c *CacheService) FinishRefresh(hv string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key(hv)]; ok && e.refreshing {
+		e.refreshing = false
+		close(e.refreshDone)
+		refreshDuration.Observe(time.Since(e.refreshStarted).Seconds(), e.hv)
+	}
+}
+
+// WaitRefresh ждет окончания текущего обновления данных гипервизора hv, если оно идет
+func (c *CacheService) WaitRefresh(ctx context.Context, hv string) error {
+	c.mu.RLock()
+
+	var done chan struct{}
+	if e, ok := c.entries[key(hv)]; ok && e.refreshing {
+		done = e.refreshDone
+	}
+
+	c.mu.RUnlock()
 
-			c.servers[i].State = string(state)
+	if done == nil {
+		return nil
+	}
 
-			break
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Info возвращает состояние данных гипервизоров hvs в кеше
+func (c *CacheService) Info(hvs []string) []HostInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make([]HostInfo, 0, len(hvs))
+
+	for _, hv := range hvs {
+		info := HostInfo{HV: hv, Stale: true}
+
+		if e, ok := c.entries[key(hv)]; ok {
+			info.UpdatedAt = e.updatedAt
+			info.Refreshing = e.refreshing
+			info.Error = e.err
+
+			if !e.updatedAt.IsZero() {
+				age := time.Since(e.updatedAt)
+				info.Age = int64(age.Seconds())
+				info.Stale = age > c.ttl || e.err != "" || e.restored
+			}
+		}
+
+		result = append(result, info)
+	}
+
+	return result
+}
+
+func (c *CacheService) Hosts() []model.HostInventory {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.hosts
+}
+
+func (c *CacheService) SetHosts(h []model.HostInventory) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hosts := make([]model.HostInventory, 0, len(h))
+	for _, host := range h {
+		if !c.removed[key(host.Name)] {
+			hosts = append(hosts, host)
 		}
 	}
+
+	c.hosts = hosts
 }
 
-func (c *CacheService) SetServerNetwork(s model.Server, state model.ServerState) {
-	logit.Info(fmt.Printf("Меняем сеть сервера ID %d NAME %s HV %s на %s", s.ID, s.Name, s.HV,
+func (c *CacheService) SetServerState(s model.Server, state model.ServerState) {
+	logit.Info(fmt.Sprintf("Меняем статус сервера ID %d NAME %s HV %s на %s", s.ID, s.Name, s.HV,
 		state))
 
+	c.update(s, func(v *model.Server) {
+		v.State = string(state)
+	})
+}
+
+func (c *CacheService) SetServerNetwork(s model.Server, network string) {
+	logit.Info(fmt.Sprintf("Меняем сеть сервера ID %d NAME %s HV %s на %s", s.ID, s.Name, s.HV,
+		network))
+
+	c.update(s, func(v *model.Server) {
+		v.Network = network
+	})
+}
+
+func (c *CacheService) SetServerResources(s model.Server, cpuCores int, memory float64) {
+	logit.Info("Меняем процессор и память сервера", s.Name, s.HV, cpuCores, memory)
+
+	c.update(s, func(v *model.Server) {
+		v.CpuCores = cpuCores
+		v.Memory = memory
+	})
+}
+
+// MoveServer переносит сервер в данные гипервизора hv, если они есть в кеше
+func (c *CacheService) MoveServer(s model.Server, hv string) {
+	logit.Info("Меняем гипервизор сервера", s.Name, s.HV, "на", hv)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.servers == nil {
+	e, id, ok := c.find(s)
+	if !ok {
+		return
+	}
+
+	v := e.servers[id]
+	delete(e.servers, id)
+
+	if dst, ok := c.entries[key(hv)]; ok {
+		v.HV = hv
+		dst.servers[id] = v
+	}
+}
+
+// AddServer добавляет новый сервер в кеш. Пока данных гипервизора сервера нет в кеше,
+// сервер не добавляется, чтобы они не считались полученными.
+func (c *CacheService) AddServer(s model.Server) {
+	logit.Info("Добавляем сервер в кеш", s.Name, s.HV)
+
+	c.mu.Lock()
+
+	e, ok := c.entries[key(s.HV)]
+	if !ok || e.updatedAt.IsZero() {
+		c.mu.Unlock()
 		return
 	}
 
-	for i, v := range c.servers {
-		if v.Name == s.Name &&
-			v.HV == s.HV {
-			logit.Info("Успешно сменили сеть")
+	e.servers[s.VMID] = s
+
+	c.mu.Unlock()
+
+	c.publish([]model.Event{newEvent(model.EventVMAppeared, model.EventSourceCommand, s, "", s.State,
+		time.Now())})
+}
 
-			c.servers[i].Network = string(state)
+// RemoveHost удаляет из кеша ВМ и ресурсы гипервизора hv. Текущее обновление его данных
+// считается законченным, данные гипервизора не кешируются до вызова AddHost.
+func (c *CacheService) RemoveHost(hv string) {
+	logit.Info("Удаляем гипервизор из кеша", hv)
 
-			break
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key(hv)]; ok && e.refreshing {
+		e.refreshing = false
+		close(e.refreshDone)
+	}
+
+	delete(c.entries, key(hv))
+	c.removed[key(hv)] = true
+
+	hosts := make([]model.HostInventory, 0, len(c.hosts))
+	for _, h := range c.hosts {
+		if !strings.EqualFold(h.Name, hv) {
+			hosts = append(hosts, h)
 		}
 	}
+
+	c.hosts = hosts
+}
+
+// AddHost снова разрешает кешировать данные гипервизора hv после RemoveHost
+func (c *CacheService) AddHost(hv string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.removed, key(hv))
+}
+
+// update изменяет сервер s в кеше функцией fn, если он там есть,
+// подписчики получают события изменения как выполненного через wvmc
+func (c *CacheService) update(s model.Server, fn func(v *model.Server)) {
+	c.mu.Lock()
+
+	e, id, ok := c.find(s)
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+
+	before := e.servers[id]
+	after := before
+	fn(&after)
+	e.servers[id] = after
+
+	c.mu.Unlock()
+
+	c.publish(diffServer(before, after, model.EventSourceCommand, time.Now()))
+}
+
+// find ищет сервер s по VMID, а если его нет - по имени, в данных его гипервизора
+func (c *CacheService) find(s model.Server) (*hostEntry, string, bool) {
+	e, ok := c.entries[key(s.HV)]
+	if !ok {
+		return nil, "", false
+	}
+
+	if _, ok = e.servers[s.VMID]; ok && s.VMID != "" {
+		return e, s.VMID, true
+	}
+
+	for id, v := range e.servers {
+		if v.Name == s.Name {
+			return e, id, true
+		}
+	}
+
+	return nil, "", false
+}
+
+// entry возвращает данные гипервизора hv, создавая их при необходимости
+func (c *CacheService) entry(hv string) *hostEntry {
+	e, ok := c.entries[key(hv)]
+	if !ok {
+		e = &hostEntry{hv: hv, servers: make(map[string]model.Server)}
+		c.entries[key(hv)] = e
+	}
+
+	return e
+}
+
+// list возвращает ВМ гипервизора, при ошибке последнего обновления - со статусом недоступного гипервизора
+func (e *hostEntry) list() []model.Server {
+	servers := make([]model.Server, 0, len(e.servers))
+
+	for _, s := range e.servers {
+		if e.err != "" {
+			s.Status = model.ServerStatusHVUnreachable
+		}
+
+		servers = append(servers, s)
+	}
+
+	return servers
+}
+
+func key(hv string) string {
+	return strings.ToLower(hv)
+}
+
+func sortServers(servers []model.Server) {
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].HV != servers[j].HV {
+			return servers[i].HV < servers[j].HV
+		}
+
+		return servers[i].Name < servers[j].Name
+	})
 }
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wvmc-cache")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = logit.New(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	logit.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestWaitRefresh(t *testing.T) {
+	c := NewCacheService(DefaultTTL)
+
+	if err := c.WaitRefresh(context.Background(), "hv1"); err != nil {
+		t.Fatalf("wait without refresh: %v", err)
+	}
+
+	if !c.StartRefresh("hv1") || c.StartRefresh("HV1") {
+		t.Fatal("second refresh of hv1 started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := c.WaitRefresh(ctx, "hv1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait of running refresh: got %v, want DeadlineExceeded", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WaitRefresh(context.Background(), "hv1")
+	}()
+
+	c.FinishRefresh("hv1")
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveHostDuringRefresh(t *testing.T) {
+	c := NewCacheService(DefaultTTL)
+	vm := model.Server{VMID: "1", Name: "vm1", HV: "hv1", State: running}
+
+	c.SetHostServers("hv1", []model.Server{vm})
+	c.SetHosts([]model.HostInventory{{Name: "hv1"}, {Name: "hv2"}})
+
+	if !c.StartRefresh("hv1") {
+		t.Fatal("refresh of hv1 did not start")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WaitRefresh(context.Background(), "hv1")
+	}()
+
+	c.RemoveHost("hv1")
+
+	// ожидающие обновления удаленного гипервизора не зависают
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait of removed host did not return")
+	}
+
+	// обновление, закончившееся после удаления, не возвращает гипервизор в кеш
+	c.SetHostServers("hv1", []model.Server{vm})
+	c.SetHostError("hv1", errors.New("timeout"))
+	c.FinishRefresh("hv1")
+	c.SetHosts([]model.HostInventory{{Name: "hv1"}, {Name: "hv2"}})
+
+	if c.StartRefresh("hv1") {
+		t.Error("refresh of removed host started")
+	}
+
+	if c.Loaded("hv1") || c.Servers() != nil {
+		t.Errorf("removed host is back in the cache: %+v", c.Servers())
+	}
+
+	if hosts := c.Hosts(); len(hosts) != 1 || hosts[0].Name != "hv2" {
+		t.Errorf("hosts = %+v, want only hv2", hosts)
+	}
+
+	c.AddHost("HV1")
+
+	if !c.StartRefresh("hv1") {
+		t.Fatal("refresh of added host did not start")
+	}
+
+	c.SetHostServers("hv1", []model.Server{vm})
+	c.FinishRefresh("hv1")
+
+	if !c.Loaded("hv1") {
+		t.Error("added host is not cached")
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Subscribe добавляет функцию fn, которая вызывается с событиями каждого изменения ВМ в кеше.
+// fn вызывается в горутине, изменившей кеш, и не должна надолго ее блокировать.
+func (c *CacheService) Subscribe(fn func(events []model.Event)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subscribers = append(c.subscribers, fn)
+}
+
+// SubscribeRefresh добавляет функцию fn, которая вызывается с ВМ гипервизора hv
+// при каждом получении его данных. fn вызывается так же, как подписчики Subscribe.
+func (c *CacheService) SubscribeRefresh(fn func(hv string, servers []model.Server, at time.Time)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refreshed = append(c.refreshed, fn)
+}
+
+// publish передает события подписчикам, вызывается без блокировки кеша
+func (c *CacheService) publish(events []model.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	c.mu.RLock()
+	subscribers := c.subscribers
+	c.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(events)
+	}
+}
+
+// diffHost сравнивает ВМ гипервизора hv до и после обновления с гипервизора
+func diffHost(hv string, before, after map[string]model.Server, at time.Time) []model.Event {
+	events := make([]model.Event, 0)
+
+	for id, v := range after {
+		old, ok := before[id]
+		if !ok {
+			events = append(events, newEvent(model.EventVMAppeared, model.EventSourceRefresh, v, "",
+				v.State, at))
+			continue
+		}
+
+		events = append(events, diffServer(old, v, model.EventSourceRefresh, at)...)
+	}
+
+	for id, v := range before {
+		if _, ok := after[id]; !ok {
+			v.HV = hv
+			events = append(events, newEvent(model.EventVMDisappeared, model.EventSourceRefresh, v,
+				v.State, "", at))
+		}
+	}
+
+	return events
+}
+
+// diffServer сравнивает ВМ до и после изменения.
+// Выключение работающей ВМ, найденное при обновлении с гипервизора, а не выполненное через wvmc,
+// считается неожиданным. Смена IP на пустой и с пустого, например при выключении ВМ, не учитывается.
+func diffServer(before, after model.Server, source string, at time.Time) []model.Event {
+	events := make([]model.Event, 0)
+
+	if before.State != after.State {
+		kind := model.EventStateChanged
+		if source == model.EventSourceRefresh && before.State == string(model.ServerStateRunning) &&
+			after.State == string(model.ServerStateStopped) {
+			kind = model.EventUnexpectedPowerOff
+		}
+
+		events = append(events, newEvent(kind, source, after, before.State, after.State, at))
+	}
+
+	if before.Network != after.Network {
+		kind := model.EventNetworkChanged
+
+		switch {
+		case before.Network == "":
+			kind = model.EventNetworkConnected
+		case after.Network == "":
+			kind = model.EventNetworkDisconnected
+		}
+
+		events = append(events, newEvent(kind, source, after, before.Network, after.Network, at))
+	}
+
+	if before.IP != after.IP && before.IP != "" && after.IP != "" {
+		events = append(events, newEvent(model.EventIPChanged, source, after, before.IP, after.IP, at))
+	}
+
+	if before.Status != after.Status {
+		events = append(events, newEvent(model.EventStatusChanged, source, after, before.Status,
+			after.Status, at))
+	}
+
+	return events
+}
+
+func newEvent(kind, source string, v model.Server, oldValue, newValue string, at time.Time) model.Event {
+	return model.Event{
+		CreatedAt: at,
+		Type:      kind,
+		Source:    source,
+		HV:        v.HV,
+		VMID:      v.VMID,
+		Server:    v.Name,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+	}
+}
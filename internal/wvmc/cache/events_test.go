@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+const (
+	running = string(model.ServerStateRunning)
+	stopped = string(model.ServerStateStopped)
+	saved   = string(model.ServerStateSaved)
+)
+
+// change тип события и значения до и после изменения
+type change struct {
+	kind     string
+	oldValue string
+	newValue string
+}
+
+func changes(events []model.Event) []change {
+	result := make([]change, 0, len(events))
+	for _, e := range events {
+		result = append(result, change{e.Type, e.OldValue, e.NewValue})
+	}
+
+	return result
+}
+
+func TestDiffServer(t *testing.T) {
+	vm := model.Server{VMID: "1", Name: "vm1", HV: "hv1", State: running, Network: "DMZ", IP: "10.0.0.1",
+		Status: "Operating normally"}
+
+	with := func(fn func(v *model.Server)) model.Server {
+		v := vm
+		fn(&v)
+		return v
+	}
+
+	tests := []struct {
+		name   string
+		after  model.Server
+		source string
+		want   []change
+	}{
+		{"nothing changes", vm, model.EventSourceRefresh, []change{}},
+		{"unexpected power off", with(func(v *model.Server) { v.State = stopped }), model.EventSourceRefresh,
+			[]change{{model.EventUnexpectedPowerOff, running, stopped}}},
+		{"power off by command", with(func(v *model.Server) { v.State = stopped }), model.EventSourceCommand,
+			[]change{{model.EventStateChanged, running, stopped}}},
+		{"save is not power off", with(func(v *model.Server) { v.State = saved }), model.EventSourceRefresh,
+			[]change{{model.EventStateChanged, running, saved}}},
+		{"network disconnected", with(func(v *model.Server) { v.Network = "" }), model.EventSourceCommand,
+			[]change{{model.EventNetworkDisconnected, "DMZ", ""}}},
+		{"network changed", with(func(v *model.Server) { v.Network = "LAN" }), model.EventSourceRefresh,
+			[]change{{model.EventNetworkChanged, "DMZ", "LAN"}}},
+		{"ip changed", with(func(v *model.Server) { v.IP = "10.0.0.2" }), model.EventSourceRefresh,
+			[]change{{model.EventIPChanged, "10.0.0.1", "10.0.0.2"}}},
+		// IP пропадает при выключении ВМ, это не смена адреса
+		{"ip lost", with(func(v *model.Server) { v.IP = "" }), model.EventSourceRefresh, []change{}},
+		{"status changed", with(func(v *model.Server) { v.Status = "" }), model.EventSourceRefresh,
+			[]change{{model.EventStatusChanged, "Operating normally", ""}}},
+		{"several changes", with(func(v *model.Server) {
+			v.State = stopped
+			v.Network = ""
+			v.IP = ""
+		}), model.EventSourceRefresh, []change{
+			{model.EventUnexpectedPowerOff, running, stopped},
+			{model.EventNetworkDisconnected, "DMZ", ""},
+		}},
+	}
+
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := diffServer(vm, tt.after, tt.source, at)
+
+			if got := changes(events); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("changes = %+v, want %+v", got, tt.want)
+			}
+
+			for _, e := range events {
+				if e.Source != tt.source || e.VMID != "1" || e.Server != "vm1" || e.HV != "hv1" ||
+					!e.CreatedAt.Equal(at) {
+					t.Errorf("event = %+v", e)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffHost(t *testing.T) {
+	before := map[string]model.Server{
+		"1": {VMID: "1", Name: "vm1", HV: "hv1", State: running},
+		"2": {VMID: "2", Name: "vm2", HV: "hv1", State: stopped},
+		"3": {VMID: "3", Name: "vm3", State: running},
+	}
+
+	after := map[string]model.Server{
+		"1": {VMID: "1", Name: "vm1", HV: "hv1", State: stopped},
+		"2": {VMID: "2", Name: "vm2", HV: "hv1", State: stopped},
+		"4": {VMID: "4", Name: "vm4", HV: "hv1", State: running},
+	}
+
+	events := diffHost("hv1", before, after, time.Now())
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].VMID < events[j].VMID
+	})
+
+	want := []model.Event{
+		{Type: model.EventUnexpectedPowerOff, VMID: "1", Server: "vm1", OldValue: running, NewValue: stopped},
+		{Type: model.EventVMDisappeared, VMID: "3", Server: "vm3", OldValue: running},
+		{Type: model.EventVMAppeared, VMID: "4", Server: "vm4", NewValue: running},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %d events", events, len(want))
+	}
+
+	for i, e := range events {
+		w := want[i]
+		if e.Type != w.Type || e.VMID != w.VMID || e.Server != w.Server || e.OldValue != w.OldValue ||
+			e.NewValue != w.NewValue || e.Source != model.EventSourceRefresh || e.HV != "hv1" {
+			t.Errorf("event %d = %+v, want %+v", i, e, w)
+		}
+	}
+
+	if events := diffHost("hv1", after, after, time.Now()); len(events) != 0 {
+		t.Errorf("events without changes = %+v", events)
+	}
+}
+
+func TestSetHostServersEvents(t *testing.T) {
+	c := NewCacheService(DefaultTTL)
+
+	var events []model.Event
+	c.Subscribe(func(e []model.Event) {
+		events = append(events, e...)
+	})
+
+	vm := model.Server{VMID: "1", Name: "vm1", HV: "hv1", State: running}
+
+	// первое получение данных гипервизора не считается изменением его ВМ
+	c.SetHostServers("hv1", []model.Server{vm})
+	if len(events) != 0 {
+		t.Fatalf("events after first refresh = %+v", events)
+	}
+
+	vm.State = stopped
+	c.SetHostServers("hv1", []model.Server{vm})
+
+	if got := changes(events); !reflect.DeepEqual(got, []change{{model.EventUnexpectedPowerOff, running,
+		stopped}}) {
+		t.Fatalf("changes = %+v", got)
+	}
+
+	// изменения за время, пока wvmc не работал, находятся при первом обновлении после восстановления
+	events = nil
+	c.Restore("hv2", []model.Server{{VMID: "2", Name: "vm2", HV: "hv2", State: running}},
+		time.Now().Add(-time.Hour))
+	c.SetHostServers("hv2", nil)
+
+	if got := changes(events); !reflect.DeepEqual(got, []change{{model.EventVMDisappeared, running, ""}}) {
+		t.Errorf("changes after restore = %+v", got)
+	}
+}
@@ -0,0 +1,137 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Checkpoint контрольная точка (снимок) ВМ
+type Checkpoint struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	ParentID  string       `json:"parent_id"`
+	CreatedAt time.Time    `json:"created_at"`
+	Current   bool         `json:"current"`
+	Children  []Checkpoint `json:"children"`
+}
+
+// checkpointList вывод скрипта GetVmCheckpoints.ps1
+type checkpointList struct {
+	Current     string       `json:"current"`
+	Checkpoints []Checkpoint `json:"checkpoints"`
+}
+
+// GetCheckpoints получает дерево контрольных точек ВМ server.
+// Возвращаются корневые точки, дочерние точки находятся в Children.
+// Current отмечает точку, от которой ВМ работает сейчас.
+func (s *ServerService) GetCheckpoints(ctx context.Context, server model.Server) ([]Checkpoint,
+	error) {
+	scriptPath := s.scripts.Path(scriptCheckpoints)
+
+	out, err := s.exec(ctx, opList, Script(scriptPath).Arg("hv", server.HV).
+		Arg("name", server.Name).OnHost(server.HV))
+	if err != nil {
+		return nil, err
+	}
+
+	var list checkpointList
+	if err = json.Unmarshal(out, &list); err != nil {
+		return nil, err
+	}
+
+	return checkpointTree(list.Checkpoints, list.Current), nil
+}
+
+// CreateCheckpoint создает контрольную точку ВМ server с именем name
+func (s *ServerService) CreateCheckpoint(ctx context.Context, server model.Server,
+	name string) (Checkpoint, error) {
+	var checkpoint Checkpoint
+
+	scriptPath := s.scripts.Path(scriptCreateCheckpoint)
+
+	out, err := s.exec(ctx, opPower, Script(scriptPath).Arg("hv", server.HV).
+		Arg("name", server.Name).Arg("checkpoint", name).OnHost(server.HV))
+	if err != nil {
+		return checkpoint, err
+	}
+
+	if err = json.Unmarshal(out, &checkpoint); err != nil {
+		return checkpoint, err
+	}
+
+	checkpoint.Current = true
+	checkpoint.Children = make([]Checkpoint, 0)
+
+	return checkpoint, nil
+}
+
+// ApplyCheckpoint возвращает ВМ server к контрольной точке id. После этого ВМ обычно сохранена
+// или выключена, поэтому ее состояние и сеть в кеше обновляются с гипервизора.
+func (s *ServerService) ApplyCheckpoint(ctx context.Context, server model.Server,
+	id string) ([]byte, error) {
+	scriptPath := s.scripts.Path(scriptRestoreCheckpoint)
+
+	out, err := s.exec(ctx, opPower, Script(scriptPath).Arg("hv", server.HV).
+		Arg("name", server.Name).Arg("id", id).OnHost(server.HV))
+	if err != nil {
+		return nil, err
+	}
+
+	s.updateCachedServer(ctx, server)
+	return out, nil
+}
+
+// DeleteCheckpoint удаляет контрольную точку id ВМ server, дочерние точки сохраняются
+func (s *ServerService) DeleteCheckpoint(ctx context.Context, server model.Server,
+	id string) ([]byte, error) {
+	scriptPath := s.scripts.Path(scriptRemoveCheckpoint)
+
+	return s.exec(ctx, opPower, Script(scriptPath).Arg("hv", server.HV).
+		Arg("name", server.Name).Arg("id", id).OnHost(server.HV))
+}
+
+// checkpointTree строит дерево из списка контрольных точек, точки одного уровня
+// отсортированы по времени создания
+func checkpointTree(list []Checkpoint, current string) []Checkpoint {
+	byParent := make(map[string][]Checkpoint)
+	ids := make(map[string]bool, len(list))
+
+	for _, c := range list {
+		ids[c.ID] = true
+	}
+
+	for _, c := range list {
+		c.Current = c.ID == current
+
+		// точка, родитель которой не найден, считается корневой
+		parent := c.ParentID
+		if !ids[parent] {
+			parent = ""
+		}
+
+		byParent[parent] = append(byParent[parent], c)
+	}
+
+	var build func(parent string) []Checkpoint
+	build = func(parent string) []Checkpoint {
+		children := byParent[parent]
+		result := make([]Checkpoint, 0, len(children))
+
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].CreatedAt.Before(children[j].CreatedAt)
+		})
+
+		for _, c := range children {
+			c.Children = build(c.ID)
+			result = append(result, c)
+		}
+
+		return result
+	}
+
+	return build("")
+}
@@ -1,12 +1,16 @@
 package control
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/anaxita/wvmc/internal/wvmc/cache"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/anaxita/logit"
 	"github.com/anaxita/wvmc/internal/wvmc/model"
@@ -50,110 +54,412 @@ type WinRDPSesion struct {
 
 // Commander описывает метод который запускает команду powershell,возвращает вывод и ошибку
 type Commander interface {
-	run(args ...string) ([]byte, error)
+	run(ctx context.Context, cmd *Cmd) ([]byte, error)
 }
 
 // Command содержит методы Run для запуска powershell команд
 type Command struct{}
 
-// run запускает команду powershell,возвращает вывод и ошибку
-func (c *Command) run(args ...string) ([]byte, error) {
-	command := strings.Join(args, " ")
+// run запускает команду powershell,возвращает вывод и ошибку.
+// Команда передается через -EncodedCommand, поэтому не зависит от экранирования командной строки.
+// При отмене ctx процесс pwsh завершается вместе с дочерними процессами.
+func (c *Command) run(ctx context.Context, cmd *Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
 
-	e := exec.Command("pwsh", "-NoLogo", "-Mta", "-NoProfile", "-NonInteractive", "-Command",
-		command)
+	e := exec.Command("pwsh", "-NoLogo", "-Mta", "-NoProfile", "-NonInteractive", "-EncodedCommand",
+		cmd.Encoded())
+	e.Stdout = &stdout
+	e.Stderr = &stderr
+	setProcessGroup(e)
 
-	out, err := e.Output()
-	if err != nil {
-		logit.Log("COMMAND", e.Args)
+	if err := e.Start(); err != nil {
 		return nil, err
 	}
 
-	return out, nil
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				cmdErr := newCommandError(cmd.Name(), exitErr.ExitCode(), stderr.String())
+				logit.Log("COMMAND", cmdErr)
+
+				return nil, cmdErr
+			}
+
+			logit.Log("COMMAND", cmd.Name(), err)
+			return nil, err
+		}
+
+		return stdout.Bytes(), nil
+	case <-ctx.Done():
+		if err := killProcessTree(e.Process); err != nil {
+			logit.Log("Не удалось завершить процесс pwsh", e.Process.Pid, err)
+		}
+
+		<-done
+		logit.Log("COMMAND CANCELED", cmd.Name(), ctx.Err())
+
+		return nil, ctx.Err()
+	}
 }
 
 // ServerService содержит структуру, которая реализует интерфейс Commander
 type ServerService struct {
 	commander Commander
 	cache     *cache.CacheService
+	scripts   *ScriptSet
+	timeouts  Timeouts
+	hosts     *hostList
+
+	migrations *migrationList
 }
 
-func NewServerService(commander Commander, cache *cache.CacheService) *ServerService {
-	return &ServerService{commander: commander, cache: cache}
+// NewServerService создает сервис управления ВМ. Гипервизоры берутся из hosts,
+// если hosts равен nil - из переменной окружения HV_LIST.
+func NewServerService(commander Commander, cache *cache.CacheService, scripts *ScriptSet,
+	timeouts Timeouts, hosts HostSource) *ServerService {
+	s := &ServerService{commander: commander, cache: cache, scripts: scripts, timeouts: timeouts,
+		migrations: newMigrationList()}
+
+	if hosts != nil {
+		s.hosts = &hostList{source: hosts}
+	}
+
+	return s
 }
 
-// NewServerService ...
+// exec выполняет команду операции op с ограничением времени этой операции, превышение возвращается
+// как ErrTimeout. Команда на гипервизоре выполняется, только если он включен, с его учетной записью,
+// если она задана.
+func (s *ServerService) exec(ctx context.Context, op operation, cmd *Cmd) ([]byte, error) {
+	cmd.op = op
+
+	if cmd.Host() != "" {
+		host, err := s.Hypervisor(ctx, cmd.Host())
+		if err != nil {
+			return nil, err
+		}
 
-// GetServersDataForUsers получает статус работы и сети ВМ servers по их Name
-func (s *ServerService) GetServersDataForUsers(servers []model.Server) ([]model.Server, error) {
-	var uniqHVs = make(map[string]bool)
-	var ids string
-	var hvs string
-	var vms = make([]model.Server, 0)
-	var scriptPath = "./powershell/GetVmForUsers.ps1"
+		if host.User != "" {
+			cmd.Credential(host.User, host.Password)
+		}
+	}
 
-	for _, v := range servers {
-		uniqHVs[v.HV] = false
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.timeout(op))
+	defer cancel()
 
-		if ids == "" {
-			ids = fmt.Sprintf("'%s'", v.VMID)
-		} else {
-			ids = fmt.Sprintf("%s, '%s'", ids, v.VMID)
+	start := time.Now()
+	out, err := s.commander.run(ctx, cmd)
+	observeCommand(cmd, time.Since(start), err)
+
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %s", ErrTimeout, cmd.Name())
 		}
+
+		return nil, err
 	}
 
-	for k := range uniqHVs {
-		if hvs == "" {
-			hvs = fmt.Sprintf("'%v'", k)
-		} else {
-			hvs = fmt.Sprintf("%s, '%v'", hvs, k)
+	return out, nil
+}
+
+// GetServersDataForUsers возвращает состояние ВМ servers из общего с администраторами кеша.
+// Пользователь видит только состояние ВМ и включена ли ее сеть: Running или Off.
+// ВМ недоступных, выключенных и удаленных гипервизоров возвращаются
+// со статусом model.ServerStatusHVUnreachable.
+func (s *ServerService) GetServersDataForUsers(ctx context.Context,
+	servers []model.Server) ([]model.Server, error) {
+	if _, err := s.GetServersDataForAdmins(ctx); err != nil {
+		return nil, err
+	}
+
+	hvs := make([]string, 0)
+	for _, v := range servers {
+		if !contains(hvs, v.HV) {
+			hvs = append(hvs, v.HV)
 		}
 	}
 
-	out, err := s.commander.run(scriptPath, "-hvList", hvs, "-idList", ids)
-	if err != nil {
-		logit.Log("Ошибка powershell ", err)
-		return vms, err
+	available := make(map[string]bool, len(hvs))
+	for _, info := range s.cache.Info(hvs) {
+		available[strings.ToLower(info.HV)] = !info.UpdatedAt.IsZero() && info.Error == ""
 	}
 
-	if err = json.Unmarshal(out, &vms); err != nil {
-		logit.Log("Ошибка json unmarshal ", err)
-		return vms, err
+	cached := s.cache.HostServers(hvs)
+	vms := make([]model.Server, 0, len(servers))
+
+	for _, v := range servers {
+		found := false
+
+		for _, c := range cached {
+			if c.VMID == v.VMID && strings.EqualFold(c.HV, v.HV) {
+				v = c
+				found = true
+
+				break
+			}
+		}
+
+		vm := userServer(v)
+
+		if !available[strings.ToLower(v.HV)] {
+			vm.Status = model.ServerStatusHVUnreachable
+		} else if !found {
+			// ВМ удалена с гипервизора
+			continue
+		}
+
+		vms = append(vms, vm)
 	}
+
 	return vms, nil
 }
 
-// GetServersDataForAdmins получает статус работы всех ВМ servers
-func (s *ServerService) GetServersDataForAdmins() ([]model.Server, error) {
-	if s.cache.Servers() != nil {
-		return s.cache.Servers(), nil
+// userServer возвращает данные ВМ v, которые видит пользователь
+func userServer(v model.Server) model.Server {
+	network := string(model.ServerStateStopped)
+	if v.Network != "" {
+		network = string(model.ServerStateRunning)
 	}
 
-	hvs := os.Getenv("HV_LIST")
+	return model.Server{
+		VMID:    v.VMID,
+		Name:    v.Name,
+		HV:      v.HV,
+		State:   v.State,
+		Network: network,
+	}
+}
 
-	scriptPath := "./powershell/GetVmForAdmins.ps1"
+// Hosts возвращает список гипервизоров из переменной окружения HV_LIST.
+// При запуске эти гипервизоры добавляются в БД, дальше список гипервизоров берется из нее.
+func Hosts() []string {
+	return ParseList(os.Getenv("HV_LIST"))
+}
 
-	out, err := s.commander.run(scriptPath, "-hvList", hvs)
+// GetServersDataForAdmins возвращает ВМ включенных гипервизоров из кеша.
+// Гипервизоры, данных которых нет в кеше, опрашиваются сразу. Устаревшие данные возвращаются
+// как есть и обновляются в фоне, ВМ недоступных гипервизоров возвращаются
+// со статусом model.ServerStatusHVUnreachable.
+func (s *ServerService) GetServersDataForAdmins(ctx context.Context) ([]model.Server, error) {
+	hvs, err := s.HostNames(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	var servers []model.Server
+	missing := make([]string, 0)
+
+	for _, hv := range hvs {
+		if !s.cache.Loaded(hv) {
+			missing = append(missing, hv)
+			continue
+		}
+
+		if s.cache.Expired(hv) {
+			go s.refreshInBackground(hv)
+		}
+	}
+
+	if len(missing) > 0 {
+		if _, err = s.RefreshServers(ctx, missing); err != nil && len(missing) == len(hvs) {
+			return nil, err
+		}
+	}
+
+	return s.cache.HostServers(hvs), nil
+}
+
+// ServersCacheInfo возвращает состояние данных включенных гипервизоров в кеше
+func (s *ServerService) ServersCacheInfo(ctx context.Context) ([]cache.HostInfo, error) {
+	hvs, err := s.HostNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.cache.Info(hvs), nil
+}
+
+// Subscribe добавляет функцию fn, которая получает события изменения ВМ в кеше
+func (s *ServerService) Subscribe(fn func(events []model.Event)) {
+	s.cache.Subscribe(fn)
+}
+
+// SubscribeRefresh добавляет функцию fn, которая получает ВМ гипервизора при каждом обновлении кеша
+func (s *ServerService) SubscribeRefresh(fn func(hv string, servers []model.Server, at time.Time)) {
+	s.cache.SubscribeRefresh(fn)
+}
+
+// RefreshServers получает ВМ с включенных гипервизоров hvs, если hvs пуст - со всех включенных
+// гипервизоров, и обновляет их данные в кеше. ВМ недоступных гипервизоров остаются в кеше
+// со статусом model.ServerStatusHVUnreachable. Ошибка возвращается, только если не ответил
+// ни один гипервизор.
+func (s *ServerService) RefreshServers(ctx context.Context, hvs []string) ([]model.Server, error) {
+	enabled, err := s.HostNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hvs) == 0 {
+		hvs = enabled
+	}
+
+	names := make([]string, 0, len(hvs))
+
+	for _, hv := range hvs {
+		if !contains(enabled, hv) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownHost, hv)
+		}
+
+		for _, name := range enabled {
+			if strings.EqualFold(name, hv) && !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	hvs = names
+
+	// гипервизоры, которые уже обновляются (например, в фоне), повторно не запрашиваются:
+	// дожидаемся окончания их обновления и возвращаем его результат
+	started := make([]string, 0, len(hvs))
+	waiting := make([]string, 0)
+
+	for _, hv := range hvs {
+		if s.cache.StartRefresh(hv) {
+			started = append(started, hv)
+		} else {
+			waiting = append(waiting, hv)
+		}
+	}
+
+	scriptPath := s.scripts.Path(scriptVMsForAdmins)
+
+	byHost, failed, err := s.fetchByHosts(ctx, started, func(hv string) *Cmd {
+		return Script(scriptPath).List("hvList", []string{hv}).OnHost(hv)
+	})
+
+	for hv, servers := range byHost {
+		s.cache.SetHostServers(hv, servers)
+	}
 
-	if err = json.Unmarshal(out, &servers); err != nil {
-		logit.Log("OUTPUT:", string(out))
+	for hv, hvErr := range failed {
+		s.cache.SetHostError(hv, hvErr)
+	}
+
+	for _, hv := range started {
+		s.cache.FinishRefresh(hv)
+	}
+
+	for _, hv := range waiting {
+		if waitErr := s.cache.WaitRefresh(ctx, hv); waitErr != nil {
+			return nil, waitErr
+		}
+	}
+
+	// гипервизоры, обновленные другим вызовом, тоже считаются ответившими
+	if err != nil && !s.anyRefreshed(waiting) {
 		return nil, err
 	}
 
-	s.cache.SetServers(servers)
+	return s.cache.HostServers(hvs), nil
+}
+
+// anyRefreshed сообщает, обновлены ли без ошибки данные хотя бы одного гипервизора из hvs
+func (s *ServerService) anyRefreshed(hvs []string) bool {
+	for _, info := range s.cache.Info(hvs) {
+		if info.Error == "" && !info.UpdatedAt.IsZero() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refreshInBackground обновляет устаревшие данные гипервизора hv, если они еще не обновляются
+func (s *ServerService) refreshInBackground(hv string) {
+	if !s.cache.StartRefresh(hv) {
+		return
+	}
+
+	defer s.cache.FinishRefresh(hv)
+
+	logit.Info("Обновляем устаревшие данные гипервизора", hv)
+
+	servers, _, err := s.fetchByHosts(context.Background(), []string{hv}, func(hv string) *Cmd {
+		return Script(s.scripts.Path(scriptVMsForAdmins)).List("hvList", []string{hv}).OnHost(hv)
+	})
+	if err != nil {
+		s.cache.SetHostError(hv, err)
+		return
+	}
 
-	return servers, nil
+	s.cache.SetHostServers(hv, servers[hv])
+}
+
+// fetchByHosts параллельно выполняет команду newCmd на каждом гипервизоре из hvs
+// и возвращает полученные списки ВМ по гипервизорам. Ошибки отдельных гипервизоров возвращаются
+// в failed, ошибка возвращается, только если не ответил ни один гипервизор.
+func (s *ServerService) fetchByHosts(ctx context.Context, hvs []string,
+	newCmd func(hv string) *Cmd) (map[string][]model.Server, map[string]error, error) {
+	type result struct {
+		hv      string
+		servers []model.Server
+		err     error
+	}
+
+	results := make(chan result, len(hvs))
+
+	for _, hv := range hvs {
+		go func(hv string) {
+			var servers []model.Server
+
+			out, err := s.exec(ctx, opList, newCmd(hv))
+			if err == nil {
+				if err = json.Unmarshal(out, &servers); err != nil {
+					logit.Log("OUTPUT:", string(out))
+				}
+			}
+
+			results <- result{hv, servers, err}
+		}(hv)
+	}
+
+	byHost := make(map[string][]model.Server)
+	failed := make(map[string]error)
+
+	var lastErr error
+
+	for range hvs {
+		r := <-results
+		if r.err != nil {
+			logit.Log("Не удалось получить список ВМ с гипервизора", r.hv, r.err)
+			failed[r.hv] = r.err
+			lastErr = r.err
+
+			continue
+		}
+
+		byHost[r.hv] = r.servers
+	}
+
+	if len(hvs) > 0 && len(failed) == len(hvs) {
+		return byHost, failed, lastErr
+	}
+
+	return byHost, failed, nil
 }
 
 // StopServer выключает сервер
-func (s *ServerService) StopServer(server model.Server) ([]byte, error) {
-	command := fmt.Sprintf("Stop-VM -Name %s -ComputerName '%s'", server.Name, server.HV)
-	out, err := s.commander.run(command)
+func (s *ServerService) StopServer(ctx context.Context, server model.Server) ([]byte, error) {
+	command := Cmdlet("Stop-VM").Arg("Name", server.Name).Arg("ComputerName", server.HV).
+		OnHost(server.HV)
+	out, err := s.exec(ctx, opPower, command)
 	if err != nil {
 		return nil, err
 	}
@@ -163,9 +469,11 @@ func (s *ServerService) StopServer(server model.Server) ([]byte, error) {
 }
 
 // StopServerForce принудительно выключает сервер
-func (s *ServerService) StopServerForce(server model.Server) ([]byte, error) {
-	command := fmt.Sprintf("Stop-VM -Name %s -Force -ComputerName '%s'", server.Name, server.HV)
-	out, err := s.commander.run(command)
+func (s *ServerService) StopServerForce(ctx context.Context, server model.Server) ([]byte,
+	error) {
+	command := Cmdlet("Stop-VM").Arg("Name", server.Name).Switch("Force").
+		Arg("ComputerName", server.HV).OnHost(server.HV)
+	out, err := s.exec(ctx, opPower, command)
 	if err != nil {
 		return nil, err
 	}
@@ -175,9 +483,10 @@ func (s *ServerService) StopServerForce(server model.Server) ([]byte, error) {
 }
 
 // StartServer включает сервер
-func (s *ServerService) StartServer(server model.Server) ([]byte, error) {
-	command := fmt.Sprintf("Start-VM -Name %s -ComputerName '%s'", server.Name, server.HV)
-	out, err := s.commander.run(command)
+func (s *ServerService) StartServer(ctx context.Context, server model.Server) ([]byte, error) {
+	command := Cmdlet("Start-VM").Arg("Name", server.Name).Arg("ComputerName", server.HV).
+		OnHost(server.HV)
+	out, err := s.exec(ctx, opPower, command)
 	if err != nil {
 		return nil, err
 	}
@@ -186,37 +495,77 @@ func (s *ServerService) StartServer(server model.Server) ([]byte, error) {
 	return out, nil
 }
 
-// StartServerNetwork включает сеть на сервере
-func (s *ServerService) StartServerNetwork(server model.Server) ([]byte, error) {
-	command := fmt.Sprintf("Connect-VMNetworkAdapter -VMName %s -SwitchName \"DMZ - Virtual Switch\" -ComputerName '%s'",
-		server.Name, server.HV)
-	out, err := s.commander.run(command)
+// RestartServer перезагружает гостевую ОС сервера
+func (s *ServerService) RestartServer(ctx context.Context, server model.Server) ([]byte, error) {
+	command := Cmdlet("Restart-VM").Arg("Name", server.Name).Arg("Type", "Reboot").Switch("Force").
+		Arg("ComputerName", server.HV).OnHost(server.HV)
+	out, err := s.exec(ctx, opPower, command)
 	if err != nil {
 		return nil, err
 	}
 
-	s.cache.SetServerNetwork(server, model.ServerNetworkRunning)
+	s.cache.SetServerState(server, model.ServerStateRunning)
 	return out, nil
 }
 
-// StopServerNetwork выключает сеть на сервере
-func (s *ServerService) StopServerNetwork(server model.Server) ([]byte, error) {
-	command := fmt.Sprintf("Disconnect-VMNetworkAdapter -VMName %s -ComputerName '%s'", server.Name,
-		server.HV)
-	out, err := s.commander.run(command)
+// ResetServer перезагружает сервер по питанию
+func (s *ServerService) ResetServer(ctx context.Context, server model.Server) ([]byte, error) {
+	command := Cmdlet("Restart-VM").Arg("Name", server.Name).Arg("Type", "Reset").Switch("Force").
+		Arg("ComputerName", server.HV).OnHost(server.HV)
+	out, err := s.exec(ctx, opPower, command)
 	if err != nil {
 		return nil, err
 	}
 
-	s.cache.SetServerNetwork(server, model.ServerNetworkStopped)
+	s.cache.SetServerState(server, model.ServerStateRunning)
 	return out, nil
 }
 
-func (s *ServerService) GetServerData(server model.Server, hv string, name string) (model.Server,
-	error) {
-	scriptPath := "./powershell/GetVmByHvAndName.ps1"
+// SaveServer сохраняет состояние сервера и выключает его
+func (s *ServerService) SaveServer(ctx context.Context, server model.Server) ([]byte, error) {
+	command := Cmdlet("Save-VM").Arg("Name", server.Name).Arg("ComputerName", server.HV).
+		OnHost(server.HV)
+	out, err := s.exec(ctx, opPower, command)
+	if err != nil {
+		return nil, err
+	}
 
-	out, err := s.commander.run(scriptPath, "-hv", hv, "-name", name)
+	s.cache.SetServerState(server, model.ServerStateSaved)
+	return out, nil
+}
+
+// PauseServer приостанавливает сервер
+func (s *ServerService) PauseServer(ctx context.Context, server model.Server) ([]byte, error) {
+	command := Cmdlet("Suspend-VM").Arg("Name", server.Name).Arg("ComputerName", server.HV).
+		OnHost(server.HV)
+	out, err := s.exec(ctx, opPower, command)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.SetServerState(server, model.ServerStatePaused)
+	return out, nil
+}
+
+// ResumeServer возобновляет работу приостановленного сервера
+func (s *ServerService) ResumeServer(ctx context.Context, server model.Server) ([]byte, error) {
+	command := Cmdlet("Resume-VM").Arg("Name", server.Name).Arg("ComputerName", server.HV).
+		OnHost(server.HV)
+	out, err := s.exec(ctx, opPower, command)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.SetServerState(server, model.ServerStateRunning)
+	return out, nil
+}
+
+func (s *ServerService) GetServerData(ctx context.Context, server model.Server, hv string,
+	name string) (model.Server, error) {
+	scriptPath := s.scripts.Path(scriptVMByHvAndName)
+
+	out, err := s.exec(ctx, opList, Script(scriptPath).Arg("hv", hv).Arg("name", name).
+		OnHost(hv))
 	if err != nil {
 		return server, err
 	}
@@ -228,13 +577,25 @@ func (s *ServerService) GetServerData(server model.Server, hv string, name strin
 	return server, nil
 }
 
+// updateCachedServer записывает в кеш состояние и сеть сервера, прочитанные с гипервизора.
+// Если прочитать их не удалось, кеш обновится при следующем обновлении списка ВМ.
+func (s *ServerService) updateCachedServer(ctx context.Context, server model.Server) {
+	current, err := s.GetServerData(ctx, model.Server{}, server.HV, server.Name)
+	if err != nil {
+		logit.Log("Не удалось получить состояние сервера", server.Name, err)
+		return
+	}
+
+	s.cache.SetServerState(server, model.ServerState(current.State))
+	s.cache.SetServerNetwork(server, current.Network)
+}
+
 // GetServerServices получает список служб сервера
-func (s *ServerService) GetServerServices(ip, user, password string) ([]WinServices, error) {
+func (s *ServerService) GetServerServices(ctx context.Context, ip, user, password string) ([]WinServices,
+	error) {
 	var services []WinServices
-	scriptPath := "./powershell/GetServerServices.ps1"
-	args := fmt.Sprintf("%s -ip %s -u '%s' -p '%s'", scriptPath, ip, user, password)
-
-	out, err := s.commander.run(args)
+	scriptPath := s.scripts.Path(scriptServerServices)
+	out, err := s.exec(ctx, opGuest, guestScript(scriptPath, ip, user, password))
 	if err != nil {
 		return services, err
 	}
@@ -247,35 +608,35 @@ func (s *ServerService) GetServerServices(ip, user, password string) ([]WinServi
 }
 
 // StartWinService включает службу сервера
-func (s *ServerService) StartWinService(ip, user, password, serviceName string) ([]byte, error) {
-	scriptPath := "./powershell/StartService.ps1"
-	args := fmt.Sprintf("%s -ip %s -u '%s' -p '%s' -name '%s'", scriptPath, ip, user, password,
-		serviceName)
-	return s.commander.run(args)
+func (s *ServerService) StartWinService(ctx context.Context, ip, user, password,
+	serviceName string) ([]byte, error) {
+	scriptPath := s.scripts.Path(scriptStartService)
+	return s.exec(ctx, opGuest,
+		guestScript(scriptPath, ip, user, password).Arg("name", serviceName))
 }
 
 // StopWinService выключает службу сервера
-func (s *ServerService) StopWinService(ip, user, password, serviceName string) ([]byte, error) {
-	scriptPath := "./powershell/StopService.ps1"
-	args := fmt.Sprintf("%s -ip %s -u '%s' -p '%s' -name '%s'", scriptPath, ip, user, password,
-		serviceName)
-	return s.commander.run(args)
+func (s *ServerService) StopWinService(ctx context.Context, ip, user, password,
+	serviceName string) ([]byte, error) {
+	scriptPath := s.scripts.Path(scriptStopService)
+	return s.exec(ctx, opGuest,
+		guestScript(scriptPath, ip, user, password).Arg("name", serviceName))
 }
 
 // RestartWinService переззагружает службу сервера
-func (s *ServerService) RestartWinService(ip, user, password, serviceName string) ([]byte, error) {
-	scriptPath := "./powershell/RestartService.ps1"
-	args := fmt.Sprintf("%s -ip %s -u '%s' -p '%s' -name '%s'", scriptPath, ip, user, password,
-		serviceName)
-	return s.commander.run(args)
+func (s *ServerService) RestartWinService(ctx context.Context, ip, user, password,
+	serviceName string) ([]byte, error) {
+	scriptPath := s.scripts.Path(scriptRestartService)
+	return s.exec(ctx, opGuest,
+		guestScript(scriptPath, ip, user, password).Arg("name", serviceName))
 }
 
 // GetServerServices получает информацию о свободном мсесте на дисках
-func (s *ServerService) GetDiskFreeSpace(ip, user, password string) ([]WinVolume, error) {
+func (s *ServerService) GetDiskFreeSpace(ctx context.Context, ip, user, password string) ([]WinVolume,
+	error) {
 	var disks []WinVolume
-	scriptPath := "./powershell/GetDiskFreeSpace.ps1"
-	args := fmt.Sprintf("%s -ip %s -u '%s' -p '%s'", scriptPath, ip, user, password)
-	out, err := s.commander.run(args)
+	scriptPath := s.scripts.Path(scriptDiskFreeSpace)
+	out, err := s.exec(ctx, opGuest, guestScript(scriptPath, ip, user, password))
 	if err != nil {
 		return disks, err
 	}
@@ -288,12 +649,11 @@ func (s *ServerService) GetDiskFreeSpace(ip, user, password string) ([]WinVolume
 }
 
 // GetProcesses получает информацию о процессах (диспетчер задач)
-func (s *ServerService) GetProcesses(ip, user, password string) ([]WinRDPSesion, error) {
+func (s *ServerService) GetProcesses(ctx context.Context, ip, user, password string) ([]WinRDPSesion,
+	error) {
 	processes := []WinRDPSesion{}
-	scriptPath := "./powershell/getProcesses.ps1"
-	args := fmt.Sprintf("%s -ip %s -u '%s' -p '%s'", scriptPath, ip, user, password)
-
-	out, err := s.commander.run(args)
+	scriptPath := s.scripts.Path(scriptProcesses)
+	out, err := s.exec(ctx, opGuest, guestScript(scriptPath, ip, user, password))
 	if err != nil {
 		return processes, err
 	}
@@ -314,17 +674,22 @@ func (s *ServerService) GetProcesses(ip, user, password string) ([]WinRDPSesion,
 }
 
 // StoptWinProcess force stop process by id
-func (s *ServerService) StoptWinProcess(ip, user, password string, id int) ([]byte, error) {
-	scriptPath := "./powershell/StopProcess.ps1"
-	args := fmt.Sprintf("%s -ip %s -u '%s' -p '%s' -id '%d'", scriptPath, ip, user, password, id)
-	return s.commander.run(args)
+func (s *ServerService) StoptWinProcess(ctx context.Context, ip, user, password string,
+	id int) ([]byte, error) {
+	scriptPath := s.scripts.Path(scriptStopProcess)
+	return s.exec(ctx, opGuest,
+		guestScript(scriptPath, ip, user, password).Int("id", id))
 }
 
 // DisconnectRDPUser close RDP user session
-func (s *ServerService) DisconnectRDPUser(ip, user, password string, sessionID int) ([]byte,
-	error) {
-	scriptPath := "./powershell/DisconnectRDPUser.ps1"
-	args := fmt.Sprintf("%s -ip %s -u '%s' -p '%s' -id %d", scriptPath, ip, user, password,
-		sessionID)
-	return s.commander.run(args)
+func (s *ServerService) DisconnectRDPUser(ctx context.Context, ip, user, password string,
+	sessionID int) ([]byte, error) {
+	scriptPath := s.scripts.Path(scriptDisconnectRDP)
+	return s.exec(ctx, opGuest,
+		guestScript(scriptPath, ip, user, password).Int("id", sessionID))
+}
+
+// guestScript создает команду запуска скрипта на гостевой ОС по адресу ip с учетными данными
+func guestScript(path, ip, user, password string) *Cmd {
+	return Script(path).Arg("ip", ip).Arg("u", user).Arg("p", password)
 }
@@ -0,0 +1,418 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/cache"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// testLog файл журнала тестов
+var testLog string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wvmc-control")
+	if err != nil {
+		panic(err)
+	}
+
+	testLog = filepath.Join(dir, "test.log")
+
+	if err = logit.New(testLog); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	logit.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestService создает сервис управления ВМ над симулятором с гипервизорами hvs
+func newTestService(t *testing.T, hvs ...string) (*ServerService, *Simulator, *cache.CacheService) {
+	t.Helper()
+
+	scripts, err := LoadScripts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { scripts.Close() })
+
+	sim := NewSimulator(hvs...)
+	c := cache.NewCacheService(cache.DefaultTTL)
+
+	svc := NewServerService(sim, c, scripts, DefaultTimeouts(), func(ctx context.Context) ([]model.Hypervisor,
+		error) {
+		result := make([]model.Hypervisor, 0, len(hvs))
+		for _, hv := range hvs {
+			result = append(result, model.Hypervisor{Name: hv, Enabled: true})
+		}
+
+		return result, nil
+	})
+
+	return svc, sim, c
+}
+
+// cachedServer возвращает ВМ name из кеша
+func cachedServer(t *testing.T, c *cache.CacheService, name string) model.Server {
+	t.Helper()
+
+	for _, v := range c.Servers() {
+		if v.Name == name {
+			return v
+		}
+	}
+
+	t.Fatalf("server %s is not in the cache", name)
+
+	return model.Server{}
+}
+
+func TestRefreshServers(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1", "hv2")
+
+	servers, err := svc.RefreshServers(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(servers) != 6 {
+		t.Fatalf("got %d servers, want 6", len(servers))
+	}
+
+	for _, info := range c.Info([]string{"hv1", "hv2"}) {
+		if info.Stale || info.Refreshing || info.UpdatedAt.IsZero() {
+			t.Errorf("cache info of %s = %+v, want fresh data", info.HV, info)
+		}
+	}
+
+	if _, err = svc.RefreshServers(context.Background(), []string{"hv3"}); !errors.Is(err, ErrUnknownHost) {
+		t.Errorf("refresh of unknown host: got %v, want ErrUnknownHost", err)
+	}
+}
+
+func TestRefreshServersHostDown(t *testing.T) {
+	svc, sim, c := newTestService(t, "hv1", "hv2")
+
+	if _, err := svc.RefreshServers(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sim.SetHostDown("hv2", true)
+
+	// ответил хотя бы один гипервизор - ошибки нет, ВМ недоступного остаются в кеше
+	servers, err := svc.RefreshServers(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(servers) != 6 {
+		t.Fatalf("got %d servers, want 6", len(servers))
+	}
+
+	for _, v := range servers {
+		unreachable := v.Status == model.ServerStatusHVUnreachable
+		if unreachable != (v.HV == "hv2") {
+			t.Errorf("%s status = %q", v.Name, v.Status)
+		}
+	}
+
+	sim.SetHostDown("hv1", true)
+
+	if _, err = svc.RefreshServers(context.Background(), nil); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("refresh with all hosts down: got %v, want ErrUnreachable", err)
+	}
+
+	if v := cachedServer(t, c, "hv1-VM1"); v.Status != model.ServerStatusHVUnreachable {
+		t.Errorf("hv1-VM1 status = %q, want %q", v.Status, model.ServerStatusHVUnreachable)
+	}
+}
+
+func TestRefreshServersWaitsForRunningRefresh(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1", "hv2")
+
+	if _, err := svc.RefreshServers(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// hv1 уже обновляется другим вызовом, например в фоне
+	if !c.StartRefresh("hv1") {
+		t.Fatal("hv1 is already refreshing")
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		servers, err := svc.RefreshServers(context.Background(), nil)
+		if err == nil && len(servers) != 6 {
+			err = fmt.Errorf("got %d servers, want 6", len(servers))
+		}
+
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("refresh returned before hv1 was refreshed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// чужое обновление не завершается этим вызовом
+	if info := c.Info([]string{"hv1"})[0]; !info.Refreshing {
+		t.Fatalf("hv1 refresh was finished by another call")
+	}
+
+	c.FinishRefresh("hv1")
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	c.StartRefresh("hv1")
+	defer c.FinishRefresh("hv1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := svc.RefreshServers(ctx, []string{"hv1"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting refresh: got %v, want DeadlineExceeded", err)
+	}
+
+	if info := c.Info([]string{"hv1"})[0]; !info.Refreshing {
+		t.Errorf("hv1 refresh was finished by a canceled call")
+	}
+}
+
+func TestServerPower(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1")
+	ctx := context.Background()
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := cachedServer(t, c, "hv1-VM1")
+
+	steps := []struct {
+		name  string
+		run   func(ctx context.Context, server model.Server) ([]byte, error)
+		state model.ServerState
+	}{
+		{"stop", svc.StopServer, model.ServerStateStopped},
+		{"start", svc.StartServer, model.ServerStateRunning},
+		{"save", svc.SaveServer, model.ServerStateSaved},
+		{"start after save", svc.StartServer, model.ServerStateRunning},
+		{"pause", svc.PauseServer, model.ServerStatePaused},
+		{"resume", svc.ResumeServer, model.ServerStateRunning},
+		{"force stop", svc.StopServerForce, model.ServerStateStopped},
+	}
+
+	for _, step := range steps {
+		if _, err := step.run(ctx, server); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if got := cachedServer(t, c, server.Name).State; got != string(step.state) {
+			t.Fatalf("%s: cached state = %q, want %q", step.name, got, step.state)
+		}
+	}
+
+	// кеш совпадает с состоянием на гипервизоре
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cachedServer(t, c, server.Name).State; got != string(model.ServerStateStopped) {
+		t.Errorf("state after refresh = %q, want Off", got)
+	}
+
+	if _, err := svc.StopServer(ctx, server); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("stop of stopped server: got %v, want ErrInvalidState", err)
+	}
+
+	if _, err := svc.StartServer(ctx, model.Server{Name: "missing", HV: "hv1"}); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("start of missing server: got %v, want ErrVMNotFound", err)
+	}
+}
+
+func TestServerNetwork(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1")
+	ctx := context.Background()
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := cachedServer(t, c, "hv1-VM1")
+	server.Switch = simSwitchName
+
+	if _, err := svc.StopServerNetwork(ctx, server, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cachedServer(t, c, server.Name).Network; got != "" {
+		t.Errorf("network after stop = %q, want empty", got)
+	}
+
+	if _, err := svc.StartServerNetwork(ctx, server, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cachedServer(t, c, server.Name).Network; got != simSwitchName {
+		t.Errorf("network after start = %q, want %q", got, simSwitchName)
+	}
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cachedServer(t, c, server.Name).Network; got != simSwitchName {
+		t.Errorf("network after refresh = %q, want %q", got, simSwitchName)
+	}
+}
+
+func TestServerStateEvents(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1")
+	ctx := context.Background()
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make([]model.Event, 0)
+	svc.Subscribe(func(e []model.Event) {
+		events = append(events, e...)
+	})
+
+	if _, err := svc.StopServer(ctx, cachedServer(t, c, "hv1-VM2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].Server != "hv1-VM2" {
+		t.Fatalf("events = %+v, want one event of hv1-VM2", events)
+	}
+}
+
+func TestApplyCheckpointUpdatesCache(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1")
+	ctx := context.Background()
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := cachedServer(t, c, "hv1-VM1")
+
+	running, err := svc.CreateCheckpoint(ctx, server, "running")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// точка работающей ВМ восстанавливается в сохраненное состояние
+	if _, err = svc.ApplyCheckpoint(ctx, server, running.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := cachedServer(t, c, server.Name); v.State != string(model.ServerStateSaved) {
+		t.Errorf("state after apply = %q, want Saved", v.State)
+	}
+
+	if _, err = svc.StopServer(ctx, server); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped, err := svc.CreateCheckpoint(ctx, server, "stopped")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = svc.StartServer(ctx, server); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = svc.ApplyCheckpoint(ctx, server, stopped.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := cachedServer(t, c, server.Name); v.State != string(model.ServerStateStopped) {
+		t.Errorf("state after apply = %q, want Off", v.State)
+	}
+}
+
+func TestSimulatorLogHidesSecrets(t *testing.T) {
+	sim := NewSimulator("hv1")
+
+	cmd := Cmdlet("Stop-VM").Arg("Name", "hv1-VM1").Arg("ComputerName", "hv1").Arg("p", "guest-s3cret").
+		OnHost("hv1").Credential(`DOMAIN\admin`, "hv-s3cret")
+	if _, err := sim.run(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"guest-s3cret", "hv-s3cret", "pscredential"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("log contains %q:\n%s", secret, data)
+		}
+	}
+}
+
+func TestHostsAreLoadedOnce(t *testing.T) {
+	scripts, err := LoadScripts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scripts.Close()
+
+	loads := 0
+	hosts := []model.Hypervisor{{Name: "hv1", Enabled: true}}
+
+	svc := NewServerService(NewSimulator("hv1", "hv2"), cache.NewCacheService(cache.DefaultTTL), scripts,
+		DefaultTimeouts(), func(ctx context.Context) ([]model.Hypervisor, error) {
+			loads++
+			return hosts, nil
+		})
+
+	ctx := context.Background()
+	server := model.Server{Name: "hv1-VM1", HV: "hv1"}
+
+	for i := 0; i < 3; i++ {
+		if _, err = svc.RefreshServers(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = svc.StopServer(ctx, server); err != nil {
+		t.Fatal(err)
+	}
+
+	if loads != 1 {
+		t.Fatalf("hosts loaded %d times, want once", loads)
+	}
+
+	// после изменения списка гипервизоров он загружается заново
+	hosts = append(hosts, model.Hypervisor{Name: "hv2", Enabled: true})
+	svc.ReloadHosts()
+
+	servers, err := svc.RefreshServers(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loads != 2 || len(servers) != 6 {
+		t.Errorf("after reload: %d loads, %d servers, want 2 loads and 6 servers", loads, len(servers))
+	}
+}
@@ -0,0 +1,166 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Типы ошибок выполнения команд powershell
+var (
+	ErrVMNotFound      = errors.New("virtual machine not found")
+	ErrAccessDenied    = errors.New("access denied")
+	ErrUnreachable     = errors.New("remote host is unreachable")
+	ErrInvalidState    = errors.New("operation is not allowed in the current virtual machine state")
+	ErrServiceNotFound = errors.New("service not found")
+	ErrProcessNotFound = errors.New("process not found")
+
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+	ErrSwitchNotFound     = errors.New("virtual switch not found")
+	ErrAdapterNotFound    = errors.New("network adapter not found")
+	ErrVMExists           = errors.New("virtual machine already exists")
+	ErrDiskExists         = errors.New("virtual hard disk already exists")
+)
+
+// errorPatterns фрагменты сообщений powershell (английская и русская локали) по типам ошибок.
+// Порядок важен: WinRM сообщает об отказе в доступе текстом "Connecting to remote server ... failed
+// ... Access is denied", поэтому отказ в доступе проверяется раньше недоступности.
+var errorPatterns = []struct {
+	kind     error
+	patterns []string
+}{
+	{ErrVMNotFound, []string{
+		"unable to find a virtual machine",
+		"не удалось найти виртуальную машину",
+	}},
+	{ErrCheckpointNotFound, []string{
+		"unable to find a checkpoint",
+		"unable to find a snapshot",
+		"не удалось найти контрольную точку",
+	}},
+	{ErrSwitchNotFound, []string{
+		"unable to find a virtual switch",
+		"не удалось найти виртуальный коммутатор",
+	}},
+	{ErrAdapterNotFound, []string{
+		"unable to find a network adapter",
+		"no network adapter is found",
+		"не удалось найти сетевой адаптер",
+	}},
+	{ErrVMExists, []string{
+		"a virtual machine with the same name already exists",
+		"виртуальная машина с таким именем уже существует",
+	}},
+	{ErrDiskExists, []string{
+		"the virtual hard disk already exists",
+	}},
+	{ErrServiceNotFound, []string{
+		"cannot find any service with service name",
+		"не удается найти службу",
+	}},
+	{ErrProcessNotFound, []string{
+		"cannot find a process with the process identifier",
+		"не удается найти процесс",
+	}},
+	{ErrInvalidState, []string{
+		"in its current state",
+		"already in the specified state",
+		"not in a valid state",
+		"в текущем состоянии",
+	}},
+	{ErrAccessDenied, []string{
+		"access is denied",
+		"you do not have the required permission",
+		"the user name or password is incorrect",
+		"отказано в доступе",
+		"неверное имя пользователя или пароль",
+	}},
+	{ErrUnreachable, []string{
+		"connecting to remote server",
+		"winrm cannot complete the operation",
+		"the winrm client cannot process",
+		"the rpc server is unavailable",
+		"unable to connect to the remote server",
+		"hyper-v was unable to connect",
+		"сбой подключения к удаленному серверу",
+		"клиенту winrm не удается",
+		"сервер rpc недоступен",
+	}},
+}
+
+// ansiRe escape-последовательности, которыми pwsh раскрашивает вывод ошибок
+var ansiRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// maxErrorMessage максимальная длина сообщения ошибки, сохраняемого из stderr
+const maxErrorMessage = 1000
+
+// CommandError описывает ошибку выполнения команды powershell
+type CommandError struct {
+	Command  string // путь скрипта или имя командлета
+	ExitCode int    // код завершения pwsh
+	Message  string // текст ошибки из stderr
+	Kind     error  // тип ошибки, например ErrVMNotFound, либо nil если тип не определен
+}
+
+// Error возвращает текст ошибки вместе с командой
+func (e *CommandError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s: exit status %d", e.Command, e.ExitCode)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Command, e.Message)
+}
+
+// Unwrap позволяет проверять тип ошибки через errors.Is
+func (e *CommandError) Unwrap() error {
+	return e.Kind
+}
+
+// newCommandError создает ошибку команды command по выводу stderr и определяет ее тип
+func newCommandError(command string, exitCode int, stderr string) *CommandError {
+	message := cleanErrorMessage(stderr)
+
+	return &CommandError{
+		Command:  command,
+		ExitCode: exitCode,
+		Message:  message,
+		Kind:     classifyError(message),
+	}
+}
+
+// classifyError определяет тип ошибки по тексту сообщения
+func classifyError(message string) error {
+	message = strings.ToLower(message)
+
+	for _, p := range errorPatterns {
+		for _, pattern := range p.patterns {
+			if strings.Contains(message, pattern) {
+				return p.kind
+			}
+		}
+	}
+
+	return nil
+}
+
+// cleanErrorMessage убирает из stderr раскраску и лишние пробелы и ограничивает длину
+func cleanErrorMessage(stderr string) string {
+	stderr = ansiRe.ReplaceAllString(stderr, "")
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	message := strings.Join(lines, " ")
+
+	if runes := []rune(message); len(runes) > maxErrorMessage {
+		message = string(runes[:maxErrorMessage]) + "..."
+	}
+
+	return message
+}
@@ -0,0 +1,76 @@
+package control
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		message string
+		want    error
+	}{
+		{`Hyper-V was unable to find a virtual machine with name "vm1".`, ErrVMNotFound},
+		{`Get-VM : Не удалось найти виртуальную машину с именем "vm1".`, ErrVMNotFound},
+		{`Hyper-V was unable to find a checkpoint with name "before update".`, ErrCheckpointNotFound},
+		{`Unable to find a snapshot matching the given criteria.`, ErrCheckpointNotFound},
+		{`Hyper-V was unable to find a virtual switch with name "DMZ".`, ErrSwitchNotFound},
+		{`No network adapter is found with the given input.`, ErrAdapterNotFound},
+		{`A virtual machine with the same name already exists.`, ErrVMExists},
+		{`The virtual hard disk already exists: 'D:\VMs\vm1\vm1.vhdx' on 'hv1'.`, ErrDiskExists},
+		{`Cannot find any service with service name 'Spooler2'.`, ErrServiceNotFound},
+		{`Не удается найти службу с именем службы "Spooler2".`, ErrServiceNotFound},
+		{`Cannot find a process with the process identifier 42.`, ErrProcessNotFound},
+		{`'vm1' failed to change state. The operation cannot be performed while the object is in its ` +
+			`current state.`, ErrInvalidState},
+		{`The virtual machine is already in the specified state.`, ErrInvalidState},
+		{`Операция не может быть выполнена в текущем состоянии объекта.`, ErrInvalidState},
+		{`You do not have the required permission to complete this task.`, ErrAccessDenied},
+		{`Отказано в доступе.`, ErrAccessDenied},
+		{`Connecting to remote server hv1 failed with the following error message : The WinRM client ` +
+			`cannot process the request.`, ErrUnreachable},
+		{`The RPC server is unavailable. (0x800706BA)`, ErrUnreachable},
+		{`Сбой подключения к удаленному серверу hv1.`, ErrUnreachable},
+		{`Сервер RPC недоступен.`, ErrUnreachable},
+		{`Hyper-V was unable to connect to the remote server.`, ErrUnreachable},
+		// WinRM сообщает об отказе в доступе как о сбое подключения
+		{`Connecting to remote server hv1 failed with the following error message : Access is denied.`,
+			ErrAccessDenied},
+		{`The term 'Get-Foo' is not recognized as a name of a cmdlet.`, nil},
+		{``, nil},
+	}
+
+	for _, tt := range tests {
+		if got := classifyError(tt.message); got != tt.want {
+			t.Errorf("classifyError(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestNewCommandError(t *testing.T) {
+	stderr := "\x1b[31;1mStop-VM: \x1b[0m\r\n   Hyper-V was unable to find a virtual machine\r\n\r\n  with name \"vm1\".\n"
+
+	err := newCommandError("Stop-VM", 1, stderr)
+
+	if err.Message != `Stop-VM: Hyper-V was unable to find a virtual machine with name "vm1".` {
+		t.Errorf("Message = %q", err.Message)
+	}
+
+	if !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("errors.Is(%v, ErrVMNotFound) = false", err)
+	}
+
+	if err.Error() != "Stop-VM: "+err.Message {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	if got := newCommandError("a.ps1", 3, "").Error(); got != "a.ps1: exit status 3" {
+		t.Errorf("Error() without stderr = %q", got)
+	}
+
+	long := newCommandError("a.ps1", 1, strings.Repeat("я", maxErrorMessage+10))
+	if n := len([]rune(long.Message)); n != maxErrorMessage+3 {
+		t.Errorf("long message has %d runes, want %d", n, maxErrorMessage+3)
+	}
+}
@@ -0,0 +1,247 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anaxita/logit"
+)
+
+// ErrHostUnavailable возвращается без выполнения команды, пока гипервизор считается недоступным
+var ErrHostUnavailable = errors.New("hypervisor is unavailable")
+
+// Состояния гипервизора для HostGuard
+const (
+	HostStateHealthy     = "healthy"     // команды выполняются
+	HostStateUnavailable = "unavailable" // команды отклоняются до истечения RetryAfter
+	HostStateProbing     = "probing"     // выполняется пробная команда после RetryAfter
+)
+
+// HostGuardConfig содержит настройки ограничений для гипервизоров
+type HostGuardConfig struct {
+	MaxInFlight int           // максимальное количество одновременных команд на гипервизор
+	MaxFailures int           // после скольких ошибок подряд гипервизор считается недоступным
+	RetryAfter  time.Duration // через сколько после этого выполняется пробная команда
+}
+
+// HostGuardConfigFromEnv возвращает настройки из переменных окружения
+// HV_MAX_INFLIGHT, HV_MAX_FAILURES и HV_RETRY_AFTER
+func HostGuardConfigFromEnv() HostGuardConfig {
+	return HostGuardConfig{
+		MaxInFlight: intFromEnv("HV_MAX_INFLIGHT", 4),
+		MaxFailures: intFromEnv("HV_MAX_FAILURES", 3),
+		RetryAfter:  durationFromEnv("HV_RETRY_AFTER", time.Minute),
+	}
+}
+
+// HostHealth описывает состояние гипервизора
+type HostHealth struct {
+	HV        string     `json:"hv"`
+	State     string     `json:"state"`
+	InFlight  int        `json:"in_flight"`
+	Failures  int        `json:"failures"`
+	LastError string     `json:"last_error,omitempty"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+}
+
+// hostState состояние одного гипервизора
+type hostState struct {
+	name      string
+	slots     chan struct{}
+	state     string
+	failures  int
+	lastError string
+	openedAt  time.Time
+
+	// long количество выполняемых долгих операций, они не занимают slots
+	long int
+}
+
+// HostGuard реализует интерфейс Commander поверх другого Commander:
+// ограничивает количество одновременных команд на каждый гипервизор
+// и перестает отправлять команды на гипервизор после MaxFailures ошибок подключения подряд.
+// Перенос и создание ВМ не занимают места одновременных команд.
+// Команды без гипервизора (см. Cmd.OnHost) выполняются без ограничений.
+type HostGuard struct {
+	next   Commander
+	config HostGuardConfig
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// NewHostGuard создает HostGuard, выполняющий команды через next
+func NewHostGuard(next Commander, config HostGuardConfig) *HostGuard {
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = 1
+	}
+
+	return &HostGuard{
+		next:   next,
+		config: config,
+		hosts:  make(map[string]*hostState),
+	}
+}
+
+// run выполняет команду с учетом ограничений гипервизора
+func (g *HostGuard) run(ctx context.Context, cmd *Cmd) ([]byte, error) {
+	hv := cmd.Host()
+	if hv == "" {
+		return g.next.run(ctx, cmd)
+	}
+
+	h, err := g.allow(hv, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// перенос и создание ВМ идут часами и не должны занимать места команд, которые обновляют список ВМ
+	if cmd.op.long() {
+		g.mu.Lock()
+		h.long++
+		g.mu.Unlock()
+
+		out, err := g.next.run(ctx, cmd)
+
+		g.mu.Lock()
+		h.long--
+		g.mu.Unlock()
+
+		g.report(hv, cmd, err)
+
+		return out, err
+	}
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		// команда не была отправлена, поэтому не учитывается как ошибка гипервизора
+		g.report(hv, cmd, context.Canceled)
+		return nil, ctx.Err()
+	}
+
+	out, err := g.next.run(ctx, cmd)
+	<-h.slots
+
+	g.report(hv, cmd, err)
+
+	return out, err
+}
+
+// Health возвращает состояние всех гипервизоров, на которые отправлялись команды
+func (g *HostGuard) Health() []HostHealth {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result := make([]HostHealth, 0, len(g.hosts))
+
+	for _, h := range g.hosts {
+		health := HostHealth{
+			HV:        h.name,
+			State:     h.state,
+			InFlight:  len(h.slots) + h.long,
+			Failures:  h.failures,
+			LastError: h.lastError,
+		}
+
+		if !h.openedAt.IsZero() {
+			openedAt := h.openedAt
+			health.OpenedAt = &openedAt
+		}
+
+		result = append(result, health)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].HV < result[j].HV
+	})
+
+	return result
+}
+
+// allow проверяет, можно ли отправить команду cmd на гипервизор hv
+func (g *HostGuard) allow(hv string, cmd *Cmd) (*hostState, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := strings.ToLower(hv)
+
+	h, ok := g.hosts[key]
+	if !ok {
+		h = &hostState{
+			name:  hv,
+			slots: make(chan struct{}, g.config.MaxInFlight),
+			state: HostStateHealthy,
+		}
+		g.hosts[key] = h
+	}
+
+	switch h.state {
+	case HostStateUnavailable:
+		// долгая операция не подходит для проверки доступности: она заняла бы проверку на часы
+		if time.Since(h.openedAt) < g.config.RetryAfter || cmd.op.long() {
+			return nil, fmt.Errorf("%w: %s: %s", ErrHostUnavailable, hv, h.lastError)
+		}
+
+		logit.Info("Проверяем доступность гипервизора", hv)
+		h.state = HostStateProbing
+	case HostStateProbing:
+		return nil, fmt.Errorf("%w: %s: %s", ErrHostUnavailable, hv, h.lastError)
+	}
+
+	return h, nil
+}
+
+// report учитывает результат команды cmd на гипервизоре hv
+func (g *HostGuard) report(hv string, cmd *Cmd, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	h := g.hosts[strings.ToLower(hv)]
+
+	// отмененная команда ничего не говорит о доступности гипервизора
+	if errors.Is(err, context.Canceled) {
+		if h.state == HostStateProbing {
+			h.state = HostStateUnavailable
+		}
+
+		return
+	}
+
+	if !isHostFailure(cmd, err) {
+		if h.state != HostStateHealthy {
+			logit.Info("Гипервизор снова доступен", hv)
+		}
+
+		h.state = HostStateHealthy
+		h.failures = 0
+		h.lastError = ""
+		h.openedAt = time.Time{}
+
+		return
+	}
+
+	h.failures++
+	h.lastError = err.Error()
+
+	if h.state == HostStateProbing || h.failures >= g.config.MaxFailures {
+		if h.state == HostStateHealthy {
+			logit.Log("Гипервизор недоступен, команды приостановлены", hv, err)
+		}
+
+		h.state = HostStateUnavailable
+		h.openedAt = time.Now()
+	}
+}
+
+// isHostFailure проверяет, говорит ли ошибка команды cmd о недоступности гипервизора, а не о проблеме с ВМ.
+// Превышение времени учитывается только у запросов списка и данных ВМ: управление ВМ, например
+// корректное выключение, может долго выполняться и на доступном гипервизоре.
+func isHostFailure(cmd *Cmd, err error) bool {
+	return errors.Is(err, ErrUnreachable) || cmd.op == opList && errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,264 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// commanderFunc выполняет команды функцией
+type commanderFunc func(ctx context.Context, cmd *Cmd) ([]byte, error)
+
+func (f commanderFunc) run(ctx context.Context, cmd *Cmd) ([]byte, error) {
+	return f(ctx, cmd)
+}
+
+// testCmd команда операции op на гипервизоре hv
+func testCmd(op operation, hv string) *Cmd {
+	cmd := Cmdlet("Get-VM").OnHost(hv)
+	cmd.op = op
+
+	return cmd
+}
+
+func healthOf(t *testing.T, g *HostGuard, hv string) HostHealth {
+	t.Helper()
+
+	for _, h := range g.Health() {
+		if h.HV == hv {
+			return h
+		}
+	}
+
+	t.Fatalf("no health of %s", hv)
+
+	return HostHealth{}
+}
+
+func TestHostGuardCircuit(t *testing.T) {
+	var (
+		calls int
+		fail  = true
+	)
+
+	g := NewHostGuard(commanderFunc(func(ctx context.Context, cmd *Cmd) ([]byte, error) {
+		calls++
+		if fail {
+			return nil, newCommandError(cmd.Name(), 1, "Connecting to remote server hv1 failed")
+		}
+
+		return []byte("ok"), nil
+	}), HostGuardConfig{MaxInFlight: 2, MaxFailures: 3, RetryAfter: 50 * time.Millisecond})
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.run(ctx, testCmd(opList, "hv1")); !errors.Is(err, ErrUnreachable) {
+			t.Fatalf("call %d: got %v, want ErrUnreachable", i, err)
+		}
+	}
+
+	if h := healthOf(t, g, "hv1"); h.State != HostStateUnavailable || h.Failures != 3 || h.OpenedAt == nil {
+		t.Fatalf("health = %+v, want unavailable after 3 failures", h)
+	}
+
+	// пока не прошло RetryAfter, команды не отправляются
+	if _, err := g.run(ctx, testCmd(opPower, "hv1")); !errors.Is(err, ErrHostUnavailable) {
+		t.Fatalf("got %v, want ErrHostUnavailable", err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("next called %d times, want 3", calls)
+	}
+
+	// другой гипервизор и команды без гипервизора не затронуты
+	fail = false
+
+	if _, err := g.run(ctx, testCmd(opList, "hv2")); err != nil {
+		t.Fatalf("hv2: %v", err)
+	}
+
+	if _, err := g.run(ctx, testCmd(opList, "")); err != nil {
+		t.Fatalf("local command: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	// неудачная проверка снова закрывает гипервизор без ожидания MaxFailures ошибок
+	fail = true
+
+	if _, err := g.run(ctx, testCmd(opList, "HV1")); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("probe: got %v, want ErrUnreachable", err)
+	}
+
+	if h := healthOf(t, g, "hv1"); h.State != HostStateUnavailable {
+		t.Fatalf("state after failed probe = %s", h.State)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	fail = false
+
+	if out, err := g.run(ctx, testCmd(opList, "hv1")); err != nil || string(out) != "ok" {
+		t.Fatalf("probe: got %q, %v", out, err)
+	}
+
+	if h := healthOf(t, g, "hv1"); h.State != HostStateHealthy || h.Failures != 0 || h.LastError != "" ||
+		h.OpenedAt != nil {
+		t.Fatalf("health after successful probe = %+v", h)
+	}
+}
+
+func TestHostGuardProbing(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	fail := true
+
+	g := NewHostGuard(commanderFunc(func(ctx context.Context, cmd *Cmd) ([]byte, error) {
+		if fail {
+			return nil, newCommandError(cmd.Name(), 1, "The RPC server is unavailable.")
+		}
+
+		started <- struct{}{}
+		<-release
+
+		return nil, nil
+	}), HostGuardConfig{MaxInFlight: 4, MaxFailures: 1, RetryAfter: time.Millisecond})
+
+	ctx := context.Background()
+
+	if _, err := g.run(ctx, testCmd(opList, "hv1")); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("got %v, want ErrUnreachable", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// долгая операция не становится проверкой доступности
+	if _, err := g.run(ctx, testCmd(opMigrate, "hv1")); !errors.Is(err, ErrHostUnavailable) {
+		t.Fatalf("migrate: got %v, want ErrHostUnavailable", err)
+	}
+
+	fail = false
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := g.run(ctx, testCmd(opList, "hv1"))
+		done <- err
+	}()
+
+	<-started
+
+	// пока выполняется проверка, остальные команды отклоняются
+	if _, err := g.run(ctx, testCmd(opPower, "hv1")); !errors.Is(err, ErrHostUnavailable) {
+		t.Fatalf("during probe: got %v, want ErrHostUnavailable", err)
+	}
+
+	if h := healthOf(t, g, "hv1"); h.State != HostStateProbing {
+		t.Fatalf("state during probe = %s", h.State)
+	}
+
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if h := healthOf(t, g, "hv1"); h.State != HostStateHealthy {
+		t.Fatalf("state after probe = %s", h.State)
+	}
+}
+
+func TestHostGuardFailureKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      operation
+		err     error
+		failure bool
+	}{
+		{"unreachable", opPower, newCommandError("Stop-VM", 1, "WinRM cannot complete the operation"), true},
+		{"list timeout", opList, context.DeadlineExceeded, true},
+		{"power timeout", opPower, context.DeadlineExceeded, false},
+		{"guest timeout", opGuest, context.DeadlineExceeded, false},
+		{"migrate timeout", opMigrate, context.DeadlineExceeded, false},
+		{"provision timeout", opProvision, context.DeadlineExceeded, false},
+		{"vm not found", opList, newCommandError("Get-VM", 1, "Unable to find a virtual machine"), false},
+		{"access denied", opPower, newCommandError("Stop-VM", 1, "Access is denied."), false},
+		{"invalid state", opPower, newCommandError("Stop-VM", 1, "in its current state"), false},
+		{"canceled", opList, context.Canceled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewHostGuard(commanderFunc(func(ctx context.Context, cmd *Cmd) ([]byte, error) {
+				return nil, tt.err
+			}), HostGuardConfig{MaxInFlight: 1, MaxFailures: 3, RetryAfter: time.Hour})
+
+			for i := 0; i < 3; i++ {
+				_, _ = g.run(context.Background(), testCmd(tt.op, "hv1"))
+			}
+
+			h := healthOf(t, g, "hv1")
+			if got := h.State == HostStateUnavailable; got != tt.failure {
+				t.Errorf("health = %+v, want unavailable %v", h, tt.failure)
+			}
+		})
+	}
+}
+
+func TestHostGuardInFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan *Cmd, 10)
+
+	g := NewHostGuard(commanderFunc(func(ctx context.Context, cmd *Cmd) ([]byte, error) {
+		started <- cmd
+		<-release
+
+		return nil, nil
+	}), HostGuardConfig{MaxInFlight: 1, MaxFailures: 1, RetryAfter: time.Hour})
+
+	done := make(chan error, 10)
+	run := func(op operation) {
+		go func() {
+			_, err := g.run(context.Background(), testCmd(op, "hv1"))
+			done <- err
+		}()
+	}
+
+	// долгие операции не занимают место обычных команд
+	run(opMigrate)
+	run(opProvision)
+	run(opList)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of 3 commands started", i)
+		}
+	}
+
+	if h := healthOf(t, g, "hv1"); h.InFlight != 3 {
+		t.Errorf("in flight = %d, want 3", h.InFlight)
+	}
+
+	// единственное место занято, команда ждет его до отмены и не считается ошибкой гипервизора
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := g.run(ctx, testCmd(opPower, "hv1")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting command: got %v, want DeadlineExceeded", err)
+	}
+
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if h := healthOf(t, g, "hv1"); h.State != HostStateHealthy || h.InFlight != 0 {
+		t.Errorf("health = %+v, want healthy and idle", h)
+	}
+}
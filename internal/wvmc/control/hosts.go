@@ -0,0 +1,256 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Ошибки списка гипервизоров
+var (
+	ErrUnknownHost       = errors.New("hypervisor is not in the list of known hypervisors")
+	ErrInvalidHypervisor = errors.New("invalid hypervisor")
+)
+
+// hostNameRe допустимое имя гипервизора: имя компьютера или DNS имя
+var hostNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]{0,252}$`)
+
+// HostSource возвращает включенные гипервизоры, обычно из таблицы hypervs
+type HostSource func(ctx context.Context) ([]model.Hypervisor, error)
+
+// hostList включенные гипервизоры из HostSource. Список загружается при первом обращении
+// и после reset, чтобы команды не читали таблицу hypervs при каждом выполнении.
+type hostList struct {
+	mu     sync.Mutex
+	source HostSource
+	hosts  []model.Hypervisor
+	loaded bool
+}
+
+// get возвращает копию списка, загружая его при необходимости
+func (l *hostList) get(ctx context.Context) ([]model.Hypervisor, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.loaded {
+		hosts, err := l.source(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		l.hosts, l.loaded = hosts, true
+	}
+
+	return append([]model.Hypervisor{}, l.hosts...), nil
+}
+
+// reset отмечает, что список нужно загрузить заново
+func (l *hostList) reset() {
+	l.mu.Lock()
+	l.hosts, l.loaded = nil, false
+	l.mu.Unlock()
+}
+
+// Hypervisors возвращает включенные гипервизоры
+func (s *ServerService) Hypervisors(ctx context.Context) ([]model.Hypervisor, error) {
+	if s.hosts == nil {
+		hvs := make([]model.Hypervisor, 0)
+		for _, name := range Hosts() {
+			hvs = append(hvs, model.Hypervisor{Name: name, Enabled: true})
+		}
+
+		return hvs, nil
+	}
+
+	return s.hosts.get(ctx)
+}
+
+// ReloadHosts загружает список гипервизоров и их учетные записи заново при следующем обращении,
+// вызывается после изменения таблицы hypervs
+func (s *ServerService) ReloadHosts() {
+	if s.hosts != nil {
+		s.hosts.reset()
+	}
+}
+
+// HostNames возвращает имена включенных гипервизоров
+func (s *ServerService) HostNames(ctx context.Context) ([]string, error) {
+	hvs, err := s.Hypervisors(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(hvs))
+	for _, hv := range hvs {
+		names = append(names, hv.Name)
+	}
+
+	return names, nil
+}
+
+// Hypervisor ищет включенный гипервизор по имени без учета регистра,
+// если его нет, возвращает ErrUnknownHost
+func (s *ServerService) Hypervisor(ctx context.Context, name string) (model.Hypervisor, error) {
+	hvs, err := s.Hypervisors(ctx)
+	if err != nil {
+		return model.Hypervisor{}, err
+	}
+
+	for _, hv := range hvs {
+		if strings.EqualFold(hv.Name, name) {
+			return hv, nil
+		}
+	}
+
+	return model.Hypervisor{}, fmt.Errorf("%w: %s", ErrUnknownHost, name)
+}
+
+// ForgetHost удаляет из кеша ВМ и ресурсы гипервизора hv
+func (s *ServerService) ForgetHost(hv string) {
+	s.cache.RemoveHost(hv)
+}
+
+// RememberHost снова кеширует данные включенного гипервизора hv после ForgetHost
+func (s *ServerService) RememberHost(hv string) {
+	s.cache.AddHost(hv)
+}
+
+// ValidateHypervisor проверяет гипервизор hv и приводит его поля к значениям по умолчанию
+func ValidateHypervisor(hv model.Hypervisor) (model.Hypervisor, error) {
+	hv.Name = strings.TrimSpace(hv.Name)
+	hv.IP = strings.TrimSpace(hv.IP)
+	hv.User = strings.TrimSpace(hv.User)
+
+	if hv.IP == "" {
+		hv.IP = "0.0.0.0"
+	}
+
+	switch {
+	case !hostNameRe.MatchString(hv.Name):
+		return hv, fmt.Errorf("%w: name must be a computer or DNS name", ErrInvalidHypervisor)
+	case net.ParseIP(hv.IP) == nil || net.ParseIP(hv.IP).To4() == nil:
+		return hv, fmt.Errorf("%w: ip4 must be an IPv4 address", ErrInvalidHypervisor)
+	case hv.User == "" && hv.Password != "":
+		return hv, fmt.Errorf("%w: user cannot be empty when password is set", ErrInvalidHypervisor)
+	}
+
+	return hv, nil
+}
+
+// GetHostsInventory возвращает ресурсы гипервизоров hvs из кеша.
+// Если в кеше нет какого-либо гипервизора из hvs, данные запрашиваются заново.
+func (s *ServerService) GetHostsInventory(ctx context.Context, hvs []string) ([]model.HostInventory,
+	error) {
+	cached := s.cache.Hosts()
+	result := make([]model.HostInventory, 0, len(hvs))
+
+	for _, hv := range hvs {
+		found := false
+
+		for _, h := range cached {
+			if strings.EqualFold(h.Name, hv) {
+				result = append(result, h)
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return s.RefreshHostsInventory(ctx, hvs)
+		}
+	}
+
+	return result, nil
+}
+
+// RefreshHostsInventory запрашивает ресурсы гипервизоров hvs параллельно и обновляет кеш.
+// Для недоступного гипервизора возвращаются последние известные данные
+// со статусом model.HostStatusUnreachable и текстом ошибки.
+func (s *ServerService) RefreshHostsInventory(ctx context.Context,
+	hvs []string) ([]model.HostInventory, error) {
+	results := make(chan model.HostInventory, len(hvs))
+	scriptPath := s.scripts.Path(scriptHostInventory)
+
+	for _, hv := range hvs {
+		go func(hv string) {
+			var host model.HostInventory
+
+			out, err := s.exec(ctx, opList, Script(scriptPath).Arg("hv", hv).OnHost(hv))
+			if err == nil {
+				if err = json.Unmarshal(out, &host); err != nil {
+					logit.Log("OUTPUT:", string(out))
+				}
+			}
+
+			if err != nil {
+				logit.Log("Не удалось получить ресурсы гипервизора", hv, err)
+
+				host = s.cachedHost(hv)
+				host.Status = model.HostStatusUnreachable
+				host.Error = err.Error()
+
+				results <- host
+
+				return
+			}
+
+			host.Name = hv
+			host.Status = model.HostStatusOK
+			host.UpdatedAt = time.Now()
+
+			if host.Storage == nil {
+				host.Storage = make([]model.HostStorage, 0)
+			}
+
+			results <- host
+		}(hv)
+	}
+
+	hosts := make([]model.HostInventory, 0, len(hvs))
+	for range hvs {
+		hosts = append(hosts, <-results)
+	}
+
+	sortHosts(hosts)
+
+	// остальные гипервизоры остаются в кеше без изменений
+	cached := append([]model.HostInventory{}, hosts...)
+	for _, h := range s.cache.Hosts() {
+		if !contains(hvs, h.Name) {
+			cached = append(cached, h)
+		}
+	}
+
+	sortHosts(cached)
+	s.cache.SetHosts(cached)
+
+	return hosts, nil
+}
+
+func sortHosts(hosts []model.HostInventory) {
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name < hosts[j].Name
+	})
+}
+
+// cachedHost возвращает последние известные данные гипервизора hv либо пустые данные с его именем
+func (s *ServerService) cachedHost(hv string) model.HostInventory {
+	for _, h := range s.cache.Hosts() {
+		if strings.EqualFold(h.Name, hv) {
+			return h
+		}
+	}
+
+	return model.HostInventory{Name: hv, Storage: make([]model.HostStorage, 0)}
+}
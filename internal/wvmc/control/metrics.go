@@ -0,0 +1,72 @@
+package control
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/prom"
+)
+
+// Метрики выполнения команд powershell по скрипту и гипервизору, hv пуст у локальных команд
+var (
+	commandsTotal = prom.NewCounterVec("wvmc_pwsh_commands_total",
+		"PowerShell commands executed.", "script", "hv")
+	commandFailures = prom.NewCounterVec("wvmc_pwsh_failures_total",
+		"PowerShell commands that failed or timed out.", "script", "hv")
+	commandDuration = prom.NewHistogramVec("wvmc_pwsh_duration_seconds",
+		"Duration of PowerShell commands.", prom.SlowBuckets, "script", "hv")
+)
+
+// observeCommand учитывает выполнение команды cmd, которое заняло d и завершилось ошибкой err
+func observeCommand(cmd *Cmd, d time.Duration, err error) {
+	script := filepath.Base(cmd.Name())
+
+	commandsTotal.Inc(script, cmd.Host())
+	commandDuration.Observe(d.Seconds(), script, cmd.Host())
+
+	if err != nil {
+		commandFailures.Inc(script, cmd.Host())
+	}
+}
+
+// Metrics возвращает метрики кеша и ВМ включенных гипервизоров на текущий момент
+func (s *ServerService) Metrics() []*prom.GaugeSet {
+	age := prom.NewGaugeSet("wvmc_cache_age_seconds", "Age of hypervisor data in the cache.", "hv")
+	stale := prom.NewGaugeSet("wvmc_cache_stale", "Whether hypervisor data in the cache is stale.", "hv")
+	state := prom.NewGaugeSet("wvmc_vm_state", "VM state from the cache, the value is always 1.",
+		"hv", "name", "state")
+	cpu := prom.NewGaugeSet("wvmc_vm_cpu_load_percent", "VM CPU load from the cache.", "hv", "name")
+	memory := prom.NewGaugeSet("wvmc_vm_memory_assigned_gigabytes", "VM assigned memory from the cache.",
+		"hv", "name")
+
+	result := []*prom.GaugeSet{age, stale, state, cpu, memory}
+
+	hvs, err := s.HostNames(context.Background())
+	if err != nil {
+		logit.Log("Не удалось получить гипервизоры для метрик", err)
+		return result
+	}
+
+	for _, info := range s.cache.Info(hvs) {
+		if !info.UpdatedAt.IsZero() {
+			age.Set(time.Since(info.UpdatedAt).Seconds(), info.HV)
+		}
+
+		v := 0.0
+		if info.Stale {
+			v = 1
+		}
+
+		stale.Set(v, info.HV)
+	}
+
+	for _, vm := range s.cache.HostServers(hvs) {
+		state.Set(1, vm.HV, vm.Name, vm.State)
+		cpu.Set(float64(vm.CpuLoad), vm.HV, vm.Name)
+		memory.Set(vm.MemoryAssigned, vm.HV, vm.Name)
+	}
+
+	return result
+}
@@ -0,0 +1,288 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Ошибки переноса ВМ
+var (
+	ErrSameHost            = errors.New("virtual machine is already on this hypervisor")
+	ErrMigrationInProgress = errors.New("virtual machine is already being migrated")
+)
+
+// Состояния переноса ВМ
+const (
+	MigrationRunning   = "running"
+	MigrationCompleted = "completed"
+	MigrationFailed    = "failed"
+)
+
+// migrationPollInterval как часто запрашивается ход переноса
+const migrationPollInterval = time.Second * 5
+
+// maxFinishedMigrations сколько завершенных переносов хранится в памяти
+const maxFinishedMigrations = 100
+
+// Migration перенос ВМ с гипервизора Source на Destination
+type Migration struct {
+	ID          int64      `json:"id"`
+	ServerID    int64      `json:"server_id"`
+	Name        string     `json:"name"`
+	Source      string     `json:"source"`
+	Destination string     `json:"destination"`
+	State       string     `json:"state"`
+	Progress    int        `json:"progress"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// migrationProgress вывод скрипта GetVmMigrationProgress.ps1
+type migrationProgress struct {
+	Active   bool `json:"active"`
+	Progress int  `json:"progress"`
+}
+
+// MigrateServer запускает перенос ВМ server на включенный гипервизор destination
+// и сразу возвращает его описание. Если storage не пуст, диски ВМ переносятся в этот каталог
+// гипервизора destination, иначе остаются в общем хранилище.
+// Перенос выполняется в фоне, после его завершения обновляется кеш и вызывается done.
+func (s *ServerService) MigrateServer(ctx context.Context, server model.Server, destination,
+	storage string, done func(Migration)) (Migration, error) {
+	hv, err := s.Hypervisor(ctx, destination)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	host := hv.Name
+
+	if strings.EqualFold(host, server.HV) {
+		return Migration{}, ErrSameHost
+	}
+
+	m, err := s.migrations.add(server, host)
+	if err != nil {
+		return m, err
+	}
+
+	logit.Info("Переносим сервер", server.Name, "с", server.HV, "на", host)
+
+	go s.migrate(m, server, storage, done)
+
+	return m, nil
+}
+
+// GetMigrations возвращает все переносы ВМ, начиная с последнего
+func (s *ServerService) GetMigrations() []Migration {
+	return s.migrations.all()
+}
+
+// GetMigration возвращает перенос ВМ id
+func (s *ServerService) GetMigration(id int64) (Migration, bool) {
+	return s.migrations.get(id)
+}
+
+// migrate выполняет перенос m и запрашивает его ход, пока перенос не завершится
+func (s *ServerService) migrate(m Migration, server model.Server, storage string,
+	done func(Migration)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	polled := make(chan struct{})
+
+	go func() {
+		s.pollMigration(ctx, m.ID, server)
+		close(polled)
+	}()
+
+	command := Script(s.scripts.Path(scriptMoveVM)).Arg("hv", server.HV).Arg("name", server.Name).
+		Arg("destination", m.Destination).OnHost(server.HV)
+	if storage != "" {
+		command.Arg("storage", storage)
+	}
+
+	_, err := s.exec(ctx, opMigrate, command)
+
+	cancel()
+	<-polled
+
+	m, _ = s.migrations.get(m.ID)
+	finishedAt := time.Now()
+	m.FinishedAt = &finishedAt
+
+	if err != nil {
+		logit.Log("Не удалось перенести сервер", server.Name, "на", m.Destination, err)
+
+		m.State = MigrationFailed
+		m.Error = err.Error()
+	} else {
+		logit.Info("Сервер перенесен", server.Name, "на", m.Destination)
+
+		m.State = MigrationCompleted
+		m.Progress = 100
+
+		s.cache.MoveServer(server, m.Destination)
+	}
+
+	// done видит перенос уже завершенным в списке переносов
+	s.migrations.set(m)
+
+	if done != nil {
+		done(m)
+	}
+}
+
+// pollMigration обновляет ход переноса id, пока не отменен ctx
+func (s *ServerService) pollMigration(ctx context.Context, id int64, server model.Server) {
+	ticker := time.NewTicker(migrationPollInterval)
+	defer ticker.Stop()
+
+	scriptPath := s.scripts.Path(scriptMigrationProgress)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		out, err := s.exec(ctx, opList, Script(scriptPath).Arg("hv", server.HV).
+			Arg("id", server.VMID).OnHost(server.HV))
+		if err != nil {
+			if ctx.Err() == nil {
+				logit.Log("Не удалось получить ход переноса сервера", server.Name, err)
+			}
+
+			continue
+		}
+
+		var progress migrationProgress
+		if err = json.Unmarshal(out, &progress); err != nil {
+			logit.Log("OUTPUT:", string(out))
+			continue
+		}
+
+		if progress.Active {
+			s.migrations.setProgress(id, progress.Progress)
+		}
+	}
+}
+
+// migrationList переносы ВМ, выполняющиеся и завершенные
+type migrationList struct {
+	mu     sync.Mutex
+	lastID int64
+	items  []Migration
+}
+
+func newMigrationList() *migrationList {
+	return &migrationList{}
+}
+
+// add добавляет перенос ВМ server на destination, если эта ВМ еще не переносится
+func (l *migrationList) add(server model.Server, destination string) (Migration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	finished := 0
+
+	for _, m := range l.items {
+		if m.State != MigrationRunning {
+			finished++
+			continue
+		}
+
+		if m.Name == server.Name && strings.EqualFold(m.Source, server.HV) {
+			return m, ErrMigrationInProgress
+		}
+	}
+
+	// удаляем самые старые завершенные переносы
+	if finished >= maxFinishedMigrations {
+		items := make([]Migration, 0, len(l.items))
+
+		for _, m := range l.items {
+			if m.State != MigrationRunning && finished >= maxFinishedMigrations {
+				finished--
+				continue
+			}
+
+			items = append(items, m)
+		}
+
+		l.items = items
+	}
+
+	l.lastID++
+
+	m := Migration{
+		ID:          l.lastID,
+		ServerID:    server.ID,
+		Name:        server.Name,
+		Source:      server.HV,
+		Destination: destination,
+		State:       MigrationRunning,
+		StartedAt:   time.Now(),
+	}
+
+	l.items = append(l.items, m)
+
+	return m, nil
+}
+
+// set сохраняет перенос m
+func (l *migrationList) set(m Migration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range l.items {
+		if l.items[i].ID == m.ID {
+			l.items[i] = m
+			return
+		}
+	}
+}
+
+// setProgress изменяет ход выполняющегося переноса id
+func (l *migrationList) setProgress(id int64, progress int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range l.items {
+		if l.items[i].ID == id && l.items[i].State == MigrationRunning {
+			l.items[i].Progress = progress
+			return
+		}
+	}
+}
+
+func (l *migrationList) get(id int64) (Migration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, m := range l.items {
+		if m.ID == id {
+			return m, true
+		}
+	}
+
+	return Migration{}, false
+}
+
+func (l *migrationList) all() []Migration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make([]Migration, 0, len(l.items))
+	for i := len(l.items) - 1; i >= 0; i-- {
+		result = append(result, l.items[i])
+	}
+
+	return result
+}
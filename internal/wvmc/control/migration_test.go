@@ -0,0 +1,167 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func TestMigrationListAdd(t *testing.T) {
+	l := newMigrationList()
+	vm := model.Server{ID: 1, Name: "vm1", HV: "hv1"}
+
+	m, err := l.add(vm, "hv2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ID != 1 || m.ServerID != 1 || m.Source != "hv1" || m.Destination != "hv2" || m.State != MigrationRunning {
+		t.Fatalf("migration = %+v", m)
+	}
+
+	// ВМ уже переносится, имя гипервизора сравнивается без учета регистра
+	if running, err := l.add(model.Server{ID: 1, Name: "vm1", HV: "HV1"}, "hv3"); !errors.Is(err,
+		ErrMigrationInProgress) || running.ID != m.ID {
+		t.Fatalf("second migration: got %+v, %v; want ErrMigrationInProgress", running, err)
+	}
+
+	// одноименная ВМ другого гипервизора переносится независимо
+	if _, err = l.add(model.Server{ID: 2, Name: "vm1", HV: "hv2"}, "hv1"); err != nil {
+		t.Fatalf("migration of vm1 from hv2: %v", err)
+	}
+
+	m.State = MigrationCompleted
+	l.set(m)
+
+	if _, err = l.add(vm, "hv3"); err != nil {
+		t.Fatalf("migration after the previous one completed: %v", err)
+	}
+}
+
+func TestMigrationListPrune(t *testing.T) {
+	l := newMigrationList()
+
+	// перенос 1 выполняется все время, остальные завершены
+	if _, err := l.add(model.Server{ID: 1, Name: "running", HV: "hv1"}, "hv2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxFinishedMigrations+5; i++ {
+		m, err := l.add(model.Server{ID: int64(i + 2), Name: "vm", HV: "hv1"}, "hv2")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m.State = MigrationFailed
+		l.set(m)
+	}
+
+	all := l.all()
+	if len(all) > maxFinishedMigrations+1 {
+		t.Fatalf("migrations = %d, want at most %d", len(all), maxFinishedMigrations+1)
+	}
+
+	// остаются выполняющийся перенос и самые новые завершенные, последний перенос первый в списке
+	last := int64(maxFinishedMigrations + 6)
+
+	if all[0].ID != last || all[len(all)-1].ID != 1 || all[len(all)-1].State != MigrationRunning {
+		t.Errorf("first = %+v, last = %+v", all[0], all[len(all)-1])
+	}
+
+	for i := 1; i < len(all)-1; i++ {
+		if all[i].ID != all[i-1].ID-1 {
+			t.Fatalf("migration %d has ID %d after %d", i, all[i].ID, all[i-1].ID)
+		}
+	}
+
+	if _, ok := l.get(2); ok {
+		t.Errorf("the oldest finished migration is kept")
+	}
+
+	if m, ok := l.get(1); !ok || m.State != MigrationRunning {
+		t.Errorf("running migration = %+v, %v", m, ok)
+	}
+}
+
+// waitMigration ждет вызова done переноса
+func waitMigration(t *testing.T, done chan Migration) Migration {
+	t.Helper()
+
+	select {
+	case m := <-done:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("migration is not finished")
+	}
+
+	return Migration{}
+}
+
+func TestMigrateServer(t *testing.T) {
+	svc, sim, c := newTestService(t, "hv1", "hv2")
+	ctx := context.Background()
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := cachedServer(t, c, "hv1-VM1")
+
+	if _, err := svc.MigrateServer(ctx, vm, "HV1", "", nil); !errors.Is(err, ErrSameHost) {
+		t.Errorf("migration to the same host: got %v, want ErrSameHost", err)
+	}
+
+	if _, err := svc.MigrateServer(ctx, vm, "hv3", "", nil); !errors.Is(err, ErrUnknownHost) {
+		t.Errorf("migration to unknown host: got %v, want ErrUnknownHost", err)
+	}
+
+	done := make(chan Migration, 1)
+	listed := make(chan Migration, 1)
+
+	m, err := svc.MigrateServer(ctx, vm, "hv2", "", func(m Migration) {
+		// done вызывается после сохранения результата в списке переносов
+		current, _ := svc.GetMigration(m.ID)
+		listed <- current
+		done <- m
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.State != MigrationRunning || m.Source != "hv1" || m.Destination != "hv2" {
+		t.Fatalf("started migration = %+v", m)
+	}
+
+	m = waitMigration(t, done)
+
+	if m.State != MigrationCompleted || m.Progress != 100 || m.FinishedAt == nil {
+		t.Fatalf("finished migration = %+v", m)
+	}
+
+	if current := <-listed; current.State != MigrationCompleted {
+		t.Errorf("migration in the list during done = %+v, want completed", current)
+	}
+
+	if v := cachedServer(t, c, "hv1-VM1"); v.HV != "hv2" || v.VMID != vm.VMID {
+		t.Errorf("cached server = %+v, want it on hv2", v)
+	}
+
+	// перенос на недоступный гипервизор завершается ошибкой, кеш не меняется
+	vm2 := cachedServer(t, c, "hv1-VM2")
+	sim.SetHostDown("hv2", true)
+
+	if _, err = svc.MigrateServer(ctx, vm2, "hv2", "", func(m Migration) { done <- m }); err != nil {
+		t.Fatal(err)
+	}
+
+	if m = waitMigration(t, done); m.State != MigrationFailed || m.Error == "" {
+		t.Errorf("failed migration = %+v", m)
+	}
+
+	if v := cachedServer(t, c, "hv1-VM2"); v.HV != "hv1" {
+		t.Errorf("server of failed migration is on %s", v.HV)
+	}
+}
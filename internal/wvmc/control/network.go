@@ -0,0 +1,143 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// defaultSwitchName коммутатор, к которому подключается сеть сервера, если для сервера он не задан
+const defaultSwitchName = "DMZ - Virtual Switch"
+
+// VMSwitch виртуальный коммутатор гипервизора
+type VMSwitch struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// NetworkAdapter сетевой адаптер ВМ
+type NetworkAdapter struct {
+	Name       string   `json:"name"`
+	SwitchName string   `json:"switch_name"`
+	MAC        string   `json:"mac"`
+	IP         []string `json:"ip"`
+}
+
+// DefaultSwitch возвращает коммутатор по умолчанию из переменной окружения DEFAULT_SWITCH
+func DefaultSwitch() string {
+	if name := os.Getenv("DEFAULT_SWITCH"); name != "" {
+		return name
+	}
+
+	return defaultSwitchName
+}
+
+// GetHostSwitches получает список виртуальных коммутаторов гипервизора hv
+func (s *ServerService) GetHostSwitches(ctx context.Context, hv string) ([]VMSwitch, error) {
+	switches := make([]VMSwitch, 0)
+	scriptPath := s.scripts.Path(scriptSwitches)
+
+	out, err := s.exec(ctx, opList, Script(scriptPath).Arg("hv", hv).OnHost(hv))
+	if err != nil {
+		return switches, err
+	}
+
+	if err = json.Unmarshal(out, &switches); err != nil {
+		return switches, err
+	}
+
+	return switches, nil
+}
+
+// GetServerAdapters получает список сетевых адаптеров ВМ server
+func (s *ServerService) GetServerAdapters(ctx context.Context, server model.Server) ([]NetworkAdapter,
+	error) {
+	adapters := make([]NetworkAdapter, 0)
+	scriptPath := s.scripts.Path(scriptNetworkAdapters)
+
+	out, err := s.exec(ctx, opList, Script(scriptPath).Arg("hv", server.HV).
+		Arg("name", server.Name).OnHost(server.HV))
+	if err != nil {
+		return adapters, err
+	}
+
+	if err = json.Unmarshal(out, &adapters); err != nil {
+		return adapters, err
+	}
+
+	return adapters, nil
+}
+
+// StartServerNetwork подключает адаптер adapter сервера (все адаптеры, если adapter пуст)
+// к коммутатору сервера, либо к коммутатору по умолчанию
+func (s *ServerService) StartServerNetwork(ctx context.Context, server model.Server,
+	adapter string) ([]byte, error) {
+	switchName := server.Switch
+	if switchName == "" {
+		switchName = DefaultSwitch()
+	}
+
+	command := Cmdlet("Connect-VMNetworkAdapter").Arg("VMName", server.Name)
+	if adapter != "" {
+		command.Arg("Name", adapter)
+	}
+
+	command.Arg("SwitchName", switchName).Arg("ComputerName", server.HV).OnHost(server.HV)
+
+	out, err := s.exec(ctx, opPower, command)
+	if err != nil {
+		return nil, err
+	}
+
+	s.updateCachedNetwork(ctx, server)
+	return out, nil
+}
+
+// StopServerNetwork отключает адаптер adapter сервера (все адаптеры, если adapter пуст)
+func (s *ServerService) StopServerNetwork(ctx context.Context, server model.Server,
+	adapter string) ([]byte, error) {
+	command := Cmdlet("Disconnect-VMNetworkAdapter").Arg("VMName", server.Name)
+	if adapter != "" {
+		command.Arg("Name", adapter)
+	}
+
+	command.Arg("ComputerName", server.HV).OnHost(server.HV)
+
+	out, err := s.exec(ctx, opPower, command)
+	if err != nil {
+		return nil, err
+	}
+
+	s.updateCachedNetwork(ctx, server)
+	return out, nil
+}
+
+// updateCachedNetwork записывает в кеш коммутаторы, к которым сейчас подключены адаптеры сервера.
+// Если получить адаптеры не удалось, кеш обновится при следующем обновлении списка ВМ.
+func (s *ServerService) updateCachedNetwork(ctx context.Context, server model.Server) {
+	adapters, err := s.GetServerAdapters(ctx, server)
+	if err != nil {
+		logit.Log("Не удалось получить сетевые адаптеры сервера", server.Name, err)
+		return
+	}
+
+	s.cache.SetServerNetwork(server, networkName(adapters))
+}
+
+// networkName возвращает коммутаторы подключенных адаптеров так же, как скрипты списка ВМ
+func networkName(adapters []NetworkAdapter) string {
+	names := make([]string, 0, len(adapters))
+
+	for _, a := range adapters {
+		if a.SwitchName != "" {
+			names = append(names, a.SwitchName)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,126 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// adapterSwitches возвращает коммутаторы адаптеров ВМ server по имени адаптера
+func adapterSwitches(t *testing.T, svc *ServerService, server model.Server) map[string]string {
+	t.Helper()
+
+	adapters, err := svc.GetServerAdapters(context.Background(), server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(map[string]string, len(adapters))
+	for _, a := range adapters {
+		result[a.Name] = a.SwitchName
+	}
+
+	return result
+}
+
+func TestGetHostSwitches(t *testing.T) {
+	svc, _, _ := newTestService(t, "hv1")
+
+	switches, err := svc.GetHostSwitches(context.Background(), "hv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(switches) != 2 || switches[0].Name != simSwitchName || switches[1].Name != simLANSwitchName {
+		t.Errorf("switches = %+v", switches)
+	}
+
+	if _, err = svc.GetHostSwitches(context.Background(), "hv2"); err == nil {
+		t.Errorf("switches of unknown host succeeded")
+	}
+}
+
+func TestStartServerNetworkSwitch(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1")
+	ctx := context.Background()
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// у VM2 адаптеры "Network Adapter" в DMZ и "LAN" в локальной сети
+	server := cachedServer(t, c, "hv1-VM2")
+
+	if _, err := svc.StopServerNetwork(ctx, server, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// коммутатор сервера подключается только к выбранному адаптеру
+	server.Switch = simLANSwitchName
+
+	if _, err := svc.StartServerNetwork(ctx, server, "LAN"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := adapterSwitches(t, svc, server)
+	if got["LAN"] != simLANSwitchName || got["Network Adapter"] != "" {
+		t.Errorf("adapters after start of LAN = %v", got)
+	}
+
+	if net := cachedServer(t, c, server.Name).Network; net != simLANSwitchName {
+		t.Errorf("cached network = %q, want %q", net, simLANSwitchName)
+	}
+
+	// без коммутатора сервера используется коммутатор по умолчанию
+	server.Switch = ""
+
+	if _, err := svc.StartServerNetwork(ctx, server, "Network Adapter"); err != nil {
+		t.Fatal(err)
+	}
+
+	got = adapterSwitches(t, svc, server)
+	if got["Network Adapter"] != defaultSwitchName || got["LAN"] != simLANSwitchName {
+		t.Errorf("adapters after start with default switch = %v", got)
+	}
+
+	// коммутатор по умолчанию из DEFAULT_SWITCH подключается ко всем адаптерам
+	t.Setenv("DEFAULT_SWITCH", simLANSwitchName)
+
+	if _, err := svc.StartServerNetwork(ctx, server, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got = adapterSwitches(t, svc, server)
+	if got["Network Adapter"] != simLANSwitchName || got["LAN"] != simLANSwitchName {
+		t.Errorf("adapters after start with DEFAULT_SWITCH = %v", got)
+	}
+}
+
+func TestStartServerNetworkErrors(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1")
+	ctx := context.Background()
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := cachedServer(t, c, "hv1-VM1")
+	server.Switch = "WAN - Virtual Switch"
+
+	if _, err := svc.StartServerNetwork(ctx, server, ""); !errors.Is(err, ErrSwitchNotFound) {
+		t.Errorf("start with unknown switch: got %v, want ErrSwitchNotFound", err)
+	}
+
+	// адаптер остается подключенным к прежнему коммутатору
+	if got := adapterSwitches(t, svc, server); got["Network Adapter"] != simSwitchName {
+		t.Errorf("adapters after failed start = %v", got)
+	}
+
+	server.Switch = ""
+
+	if _, err := svc.StartServerNetwork(ctx, server, "LAN"); !errors.Is(err, ErrAdapterNotFound) {
+		t.Errorf("start of unknown adapter: got %v, want ErrAdapterNotFound", err)
+	}
+}
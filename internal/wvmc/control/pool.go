@@ -0,0 +1,404 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anaxita/logit"
+)
+
+// ErrPoolClosed возвращается при выполнении команды после закрытия пула
+var ErrPoolClosed = errors.New("powershell pool is closed")
+
+// workerScript цикл обработки команд внутри процесса pwsh.
+// Каждая команда приходит отдельной строкой stdin (base64 от UTF-16LE, как для -EncodedCommand),
+// ответ пишется одной строкой: "<метка> <код> <память процесса> <base64 stdout> <base64 stderr>".
+// Метка случайна для каждого процесса, поэтому посторонний вывод в консоль не может подделать ответ.
+// Команда считается неуспешной при завершающей ошибке или если она записала ошибки и ничего не вывела.
+const workerScript = `
+$marker = %s
+$utf8 = New-Object System.Text.UTF8Encoding $false
+[Console]::OutputEncoding = $utf8
+[Console]::InputEncoding = $utf8
+$ProgressPreference = 'SilentlyContinue'
+$WarningPreference = 'SilentlyContinue'
+$InformationPreference = 'SilentlyContinue'
+
+while ($true) {
+    $line = [Console]::In.ReadLine()
+    if ($null -eq $line) {
+        break
+    }
+
+    $status = 0
+    $output = ''
+    $errors = ''
+
+    try {
+        $command = [System.Text.Encoding]::Unicode.GetString([Convert]::FromBase64String($line))
+        $result = @(& ([scriptblock]::Create($command)) 2>&1)
+        $records = @($result | Where-Object { $_ -is [System.Management.Automation.ErrorRecord] })
+        $output = @($result |
+            Where-Object { $_ -isnot [System.Management.Automation.ErrorRecord] } |
+            ForEach-Object { [string]$_ }) -join "` + "`" + `n"
+
+        if ($records.Count -gt 0) {
+            $errors = $records | Out-String
+            if ($output -eq '') {
+                $status = 1
+            }
+        }
+    } catch {
+        $status = 1
+        $errors = $_ | Out-String
+    }
+
+    $memory = [System.Diagnostics.Process]::GetCurrentProcess().WorkingSet64
+    $out64 = [Convert]::ToBase64String($utf8.GetBytes([string]$output))
+    $err64 = [Convert]::ToBase64String($utf8.GetBytes([string]$errors))
+
+    [Console]::Out.WriteLine("$marker $status $memory $out64 $err64")
+    [Console]::Out.Flush()
+}
+`
+
+// PoolConfig содержит настройки пула процессов pwsh
+type PoolConfig struct {
+	Size        int   // максимальное количество процессов
+	MaxCommands int   // после скольких команд процесс перезапускается
+	MaxMemory   int64 // при каком объеме памяти процесса (байт) он перезапускается
+}
+
+// PoolConfigFromEnv возвращает настройки пула из переменных окружения
+// PWSH_POOL_SIZE, PWSH_POOL_MAX_COMMANDS и PWSH_POOL_MAX_MEMORY_MB
+func PoolConfigFromEnv() PoolConfig {
+	return PoolConfig{
+		Size:        intFromEnv("PWSH_POOL_SIZE", 4),
+		MaxCommands: intFromEnv("PWSH_POOL_MAX_COMMANDS", 200),
+		MaxMemory:   int64(intFromEnv("PWSH_POOL_MAX_MEMORY_MB", 512)) * 1024 * 1024,
+	}
+}
+
+func intFromEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		logit.Log("Неверное значение", key, v, "используем", def)
+		return def
+	}
+
+	return n
+}
+
+// Pool реализует интерфейс Commander через пул долгоживущих процессов pwsh.
+// Процессы запускаются по мере необходимости, но не больше Size одновременно;
+// упавшие, превысившие лимит команд или памяти процессы перезапускаются.
+type Pool struct {
+	config PoolConfig
+	slots  chan struct{}
+	idle   chan *poolWorker
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPool создает пул процессов pwsh с настройками config
+func NewPool(config PoolConfig) *Pool {
+	if config.Size <= 0 {
+		config.Size = 1
+	}
+
+	return &Pool{
+		config: config,
+		slots:  make(chan struct{}, config.Size),
+		idle:   make(chan *poolWorker, config.Size),
+	}
+}
+
+// run выполняет команду в свободном процессе пула.
+// При отмене ctx процесс, выполняющий команду, завершается и будет запущен заново.
+func (p *Pool) run(ctx context.Context, cmd *Cmd) ([]byte, error) {
+	w, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := w.exec(ctx, cmd)
+	p.release(w)
+
+	if err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) {
+			logit.Log("COMMAND", cmdErr)
+		} else {
+			logit.Log("COMMAND", cmd.Name(), err)
+		}
+
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Close завершает все свободные процессы, занятые процессы завершаются после выполнения команды
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	for {
+		select {
+		case w := <-p.idle:
+			w.kill()
+			<-p.slots
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.closed
+}
+
+// acquire занимает слот пула и возвращает свободный процесс, при необходимости запуская новый
+func (p *Pool) acquire(ctx context.Context) (*poolWorker, error) {
+	if p.isClosed() {
+		return nil, ErrPoolClosed
+	}
+
+	var w *poolWorker
+
+	select {
+	case w = <-p.idle:
+	default:
+		select {
+		case w = <-p.idle:
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if w != nil {
+		if w.alive() {
+			return w, nil
+		}
+
+		// слот упавшего процесса переходит новому процессу
+		logit.Log("Процесс pwsh завершился, запускаем новый", w.pid())
+	}
+
+	w, err := startPoolWorker()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// release возвращает процесс в пул или завершает его, если процесс нужно перезапустить
+func (p *Pool) release(w *poolWorker) {
+	recycle := !w.alive() || p.isClosed() ||
+		(p.config.MaxCommands > 0 && w.commands >= p.config.MaxCommands) ||
+		(p.config.MaxMemory > 0 && w.memory >= p.config.MaxMemory)
+
+	if recycle {
+		logit.Info("Перезапускаем процесс pwsh", w.pid(), "команд:", w.commands, "память:", w.memory)
+		w.kill()
+		<-p.slots
+
+		return
+	}
+
+	p.idle <- w
+}
+
+// poolWorker долгоживущий процесс pwsh, выполняющий команды по одной
+type poolWorker struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	marker   string
+	commands int
+	memory   int64
+	done     chan struct{}
+	killOnce sync.Once
+}
+
+func startPoolWorker() (*poolWorker, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	marker := "WVMC-" + hex.EncodeToString(b)
+
+	e := exec.Command("pwsh", "-NoLogo", "-Mta", "-NoProfile", "-NonInteractive", "-EncodedCommand",
+		encodeCommand(fmt.Sprintf(workerScript, quote(marker))))
+	setProcessGroup(e)
+
+	stdin, err := e.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := e.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = e.Start(); err != nil {
+		return nil, err
+	}
+
+	w := &poolWorker{
+		cmd:    e,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		marker: marker,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		_ = e.Wait()
+		close(w.done)
+	}()
+
+	logit.Info("Запущен процесс pwsh", w.pid())
+
+	return w, nil
+}
+
+func (w *poolWorker) pid() int {
+	return w.cmd.Process.Pid
+}
+
+func (w *poolWorker) alive() bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (w *poolWorker) kill() {
+	if !w.alive() {
+		return
+	}
+
+	w.killOnce.Do(func() {
+		if err := killProcessTree(w.cmd.Process); err != nil {
+			logit.Log("Не удалось завершить процесс pwsh", w.pid(), err)
+		}
+	})
+
+	<-w.done
+}
+
+// exec выполняет команду, при отмене ctx процесс завершается
+func (w *poolWorker) exec(ctx context.Context, cmd *Cmd) ([]byte, error) {
+	type result struct {
+		out []byte
+		err error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		out, err := w.roundTrip(cmd)
+		ch <- result{out, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.out, r.err
+	case <-ctx.Done():
+		w.kill()
+		<-ch
+
+		return nil, ctx.Err()
+	}
+}
+
+// roundTrip отправляет команду процессу и читает ответ
+func (w *poolWorker) roundTrip(cmd *Cmd) ([]byte, error) {
+	w.commands++
+
+	if _, err := io.WriteString(w.stdin, cmd.Encoded()+"\n"); err != nil {
+		w.kill()
+		return nil, fmt.Errorf("pwsh worker: write command: %w", err)
+	}
+
+	for {
+		line, err := w.stdout.ReadString('\n')
+		if err != nil {
+			w.kill()
+			return nil, fmt.Errorf("pwsh worker: read response: %w", err)
+		}
+
+		line = strings.TrimRight(strings.TrimPrefix(line, "\ufeff"), "\r\n")
+		if !strings.HasPrefix(line, w.marker+" ") {
+			continue
+		}
+
+		return w.parseResponse(cmd, line)
+	}
+}
+
+// parseResponse разбирает строку ответа процесса
+func (w *poolWorker) parseResponse(cmd *Cmd, line string) ([]byte, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) != 5 {
+		w.kill()
+		return nil, fmt.Errorf("pwsh worker: malformed response %q", line)
+	}
+
+	status, err := strconv.Atoi(fields[1])
+	if err != nil {
+		w.kill()
+		return nil, fmt.Errorf("pwsh worker: malformed status %q", fields[1])
+	}
+
+	if memory, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+		w.memory = memory
+	}
+
+	out, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil {
+		w.kill()
+		return nil, fmt.Errorf("pwsh worker: decode output: %w", err)
+	}
+
+	stderr, err := base64.StdEncoding.DecodeString(fields[4])
+	if err != nil {
+		w.kill()
+		return nil, fmt.Errorf("pwsh worker: decode errors: %w", err)
+	}
+
+	if status != 0 {
+		return nil, newCommandError(cmd.Name(), status, string(stderr))
+	}
+
+	return out, nil
+}
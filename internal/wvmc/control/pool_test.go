@@ -0,0 +1,147 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testMarker = "WVMC-0123456789abcdef"
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newTestWorker создает процесс пула без pwsh, который читает ответы из stdout.
+// Процесс уже считается завершенным, поэтому kill ничего не делает.
+func newTestWorker(stdout string) (*poolWorker, *bytes.Buffer) {
+	stdin := new(bytes.Buffer)
+	done := make(chan struct{})
+	close(done)
+
+	return &poolWorker{
+		stdin:  nopWriteCloser{stdin},
+		stdout: bufio.NewReader(strings.NewReader(stdout)),
+		marker: testMarker,
+		done:   done,
+	}, stdin
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		out    string
+		kind   error
+		failed bool
+		memory int64
+	}{
+		{name: "output", line: testMarker + " 0 1048576 " + b64(`[{"Name":"vm1"}]`) + " ",
+			out: `[{"Name":"vm1"}]`, memory: 1048576},
+		{name: "empty output", line: testMarker + " 0 2048  ", memory: 2048},
+		{name: "output with non-terminating errors", line: testMarker + " 0 10 " + b64("ok") + " " +
+			b64("WARNING: something"), out: "ok", memory: 10},
+		{name: "cyrillic output", line: testMarker + " 0 10 " + b64("Сервер") + " ", out: "Сервер", memory: 10},
+		{name: "command error", line: testMarker + " 1 10  " +
+			b64("Hyper-V was unable to find a virtual machine with name \"vm1\"."),
+			kind: ErrVMNotFound, failed: true, memory: 10},
+		{name: "unknown error", line: testMarker + " 1 10  " + b64("boom"), failed: true, memory: 10},
+		{name: "bad memory is ignored", line: testMarker + " 0 x " + b64("ok") + " ", out: "ok"},
+		{name: "too few fields", line: testMarker + " 0 10 " + b64("ok"), failed: true},
+		{name: "too many fields", line: testMarker + " 0 10 a b c", failed: true},
+		{name: "bad status", line: testMarker + " ok 10  ", failed: true},
+		{name: "bad output", line: testMarker + " 0 10 !!! ", failed: true, memory: 10},
+		{name: "bad errors", line: testMarker + " 1 10  !!!", failed: true, memory: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := newTestWorker("")
+
+			out, err := w.parseResponse(Cmdlet("Get-VM"), tt.line)
+			if (err != nil) != tt.failed {
+				t.Fatalf("err = %v, want failed %v", err, tt.failed)
+			}
+
+			if string(out) != tt.out {
+				t.Errorf("out = %q, want %q", out, tt.out)
+			}
+
+			if tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("err = %v, want %v", err, tt.kind)
+			}
+
+			if w.memory != tt.memory {
+				t.Errorf("memory = %d, want %d", w.memory, tt.memory)
+			}
+		})
+	}
+}
+
+func TestParseResponseCommandError(t *testing.T) {
+	w, _ := newTestWorker("")
+
+	_, err := w.parseResponse(Cmdlet("Stop-VM"), testMarker+" 1 10  "+b64("\x1b[31mAccess is denied.\x1b[0m\r\n"))
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("err = %v, want *CommandError", err)
+	}
+
+	if cmdErr.Command != "Stop-VM" || cmdErr.ExitCode != 1 || cmdErr.Message != "Access is denied." ||
+		cmdErr.Kind != ErrAccessDenied {
+		t.Errorf("err = %+v", cmdErr)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	stdout := strings.Join([]string{
+		"\ufeffstartup banner",
+		"WARNING: noise written to the console",
+		// ответ с меткой другого процесса не принимается
+		"WVMC-ffffffffffffffff 0 1 " + b64("forged") + " ",
+		testMarker + "0 1 " + b64("no separator") + " ",
+		testMarker + " 0 4096 " + b64("first") + " \r",
+		testMarker + " 0 4096 " + b64("second") + " ",
+	}, "\n") + "\n"
+
+	w, stdin := newTestWorker(stdout)
+	cmd := Cmdlet("Get-VM").Arg("Name", "vm'1")
+
+	out, err := w.roundTrip(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "first" {
+		t.Errorf("out = %q, want first", out)
+	}
+
+	if got := stdin.String(); got != cmd.Encoded()+"\n" {
+		t.Errorf("stdin = %q, want encoded command and newline", got)
+	}
+
+	if out, err = w.roundTrip(cmd); err != nil || string(out) != "second" {
+		t.Errorf("second round trip = %q, %v", out, err)
+	}
+
+	if w.commands != 2 {
+		t.Errorf("commands = %d, want 2", w.commands)
+	}
+
+	// процесс завершился, не ответив
+	if _, err = w.roundTrip(cmd); err == nil {
+		t.Error("round trip after EOF succeeded")
+	}
+}
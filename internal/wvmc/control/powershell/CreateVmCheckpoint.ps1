@@ -0,0 +1,17 @@
+param (
+    [string]$hv,
+    [string]$name,
+    [string]$checkpoint
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+$vm = Get-VM -ComputerName $hv -Name $name -ErrorAction Stop
+$snapshot = Checkpoint-VM -VM $vm -SnapshotName $checkpoint -Passthru -ErrorAction Stop
+
+[PSCustomObject]@{
+    "id"         = [string]$snapshot.Id
+    "name"       = $snapshot.Name
+    "parent_id"  = [string]$snapshot.ParentSnapshotId
+    "created_at" = $snapshot.CreationTime.ToUniversalTime().ToString("o")
+} | ConvertTo-Json -Compress
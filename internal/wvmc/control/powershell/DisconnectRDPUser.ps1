@@ -11,4 +11,4 @@ $Creds = New-Object -TypeName System.Management.Automation.PSCredential -Argumen
 
 Invoke-Command -ComputerName $ip -Authentication Negotiate -Credential $Creds -ScriptBlock {
     logoff.exe $Using:id
-}
+} -ErrorAction Stop
@@ -0,0 +1,85 @@
+param (
+    [string]$hv
+)
+
+# память передается в МБ, место на томах - в ГБ
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+# Get-CimInstance не берет учетную запись из $PSDefaultParameterValues, поэтому запросы к гипервизору
+# выполняются в отдельной сессии с учетной записью, если она задана
+$credential = Get-Variable -Name credential -ValueOnly -ErrorAction SilentlyContinue
+$sessionParams = @{ ComputerName = $hv }
+if ($null -ne $credential) {
+    $sessionParams["Credential"] = $credential
+}
+
+$cim = New-CimSession @sessionParams -ErrorAction Stop
+
+try {
+    $os = Get-CimInstance -CimSession $cim -ClassName Win32_OperatingSystem -ErrorAction Stop
+    $volumes = @(Get-CimInstance -CimSession $cim -ClassName Win32_Volume -Filter "DriveType = 3" -ErrorAction Stop |
+        Where-Object { $_.Name -and $_.Name -notlike "\\?\*" })
+} finally {
+    Remove-CimSession -CimSession $cim -ErrorAction SilentlyContinue
+}
+
+$vmHost = Get-VMHost -ComputerName $hv -ErrorAction Stop
+$vms = @(Get-VM -ComputerName $hv -ErrorAction Stop)
+
+$version = Invoke-Command -ComputerName $hv -ErrorAction Stop -ScriptBlock {
+    (Get-Item "$env:SystemRoot\System32\vmms.exe").VersionInfo.ProductVersion
+}
+
+$states = [ordered]@{
+    "total"   = $vms.Count
+    "running" = 0
+    "off"     = 0
+    "saved"   = 0
+    "paused"  = 0
+    "other"   = 0
+}
+
+foreach ($vm in $vms) {
+    $state = switch ([int]$vm.State) {
+        2 { "running" }
+        3 { "off" }
+        6 { "saved" }
+        9 { "paused" }
+        default { "other" }
+    }
+
+    $states[$state]++
+}
+
+# тома, на которых находятся ВМ и каталоги ВМ гипервизора по умолчанию
+$paths = @($vmHost.VirtualMachinePath, $vmHost.VirtualHardDiskPath) + @($vms | ForEach-Object { $_.Path }) |
+    Where-Object { $_ } | Sort-Object -Unique
+
+$storage = [ordered]@{}
+
+foreach ($path in $paths) {
+    $volume = $volumes | Where-Object { $path.StartsWith($_.Name, [StringComparison]::OrdinalIgnoreCase) } |
+        Sort-Object { $_.Name.Length } -Descending | Select-Object -First 1
+
+    if ($null -ne $volume -and -not $storage.Contains($volume.Name)) {
+        $storage[$volume.Name] = [PSCustomObject]@{
+            "path"        = $volume.Name
+            "space_total" = [math]::Round($volume.Capacity / 1GB, 1)
+            "space_free"  = [math]::Round($volume.FreeSpace / 1GB, 1)
+        }
+    }
+}
+
+[PSCustomObject]@{
+    "name"               = $hv
+    "os"                 = $os.Caption
+    "hyperv_version"     = [string]$version
+    "logical_processors" = $vmHost.LogicalProcessorCount
+    "virtual_processors" = [int]($vms | Measure-Object -Property ProcessorCount -Sum).Sum
+    "memory_total"       = [int64]($os.TotalVisibleMemorySize / 1KB)
+    "memory_free"        = [int64]($os.FreePhysicalMemory / 1KB)
+    "memory_assigned"    = [int64](($vms | Measure-Object -Property MemoryAssigned -Sum).Sum / 1MB)
+    "storage"            = @($storage.Values)
+    "vms"                = [PSCustomObject]$states
+} | ConvertTo-Json -Depth 3 -Compress
@@ -10,7 +10,7 @@ $Creds = New-Object -TypeName System.Management.Automation.PSCredential -Argumen
 
 $result = Invoke-Command -ComputerName $ip -Credential $Creds -ScriptBlock {
         Get-WmiObject -Class win32_service | Select-Object Name, DisplayName, State, StartName
-    }
+    } -ErrorAction Stop
 
 $result |
     ForEach-Object {
@@ -4,12 +4,13 @@ param (
 )
 
 [Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
-    $vm = Get-VM -ComputerName $hv -Name $name
+    $vm = Get-VM -ComputerName $hv -Name $name -ErrorAction Stop
             $state = $vm.State;
-            if ($state -eq 2) {
-                $state = "Running"
-            } else {
-                $state = "Off"
+            $state = switch ([int]$state) {
+                2 { "Running" }
+                6 { "Saved" }
+                9 { "Paused" }
+                default { "Off" }
             }
             
             $data = @{
@@ -21,7 +22,8 @@ param (
                 "weight" = ($vm | Get-VMProcessor).RelativeWeight
                 "description" = $vm.Description
                 "memory" = [math]::Round(($vm.MemoryStartup / 1GB), 0)
-                "network" = ($vm | Get-VMNetworkAdapter).SwitchName
+                "network" = (@($vm | Get-VMNetworkAdapter | Where-Object { $_.SwitchName } |
+                    ForEach-Object { $_.SwitchName }) -join ", ")
                 "hv" = $vm.ComputerName
             }
 
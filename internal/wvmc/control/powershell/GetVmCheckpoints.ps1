@@ -0,0 +1,22 @@
+param (
+    [string]$hv,
+    [string]$name
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+$vm = Get-VM -ComputerName $hv -Name $name -ErrorAction Stop
+
+$checkpoints = @(Get-VMSnapshot -VM $vm -ErrorAction Stop | ForEach-Object {
+    [PSCustomObject]@{
+        "id"         = [string]$_.Id
+        "name"       = $_.Name
+        "parent_id"  = [string]$_.ParentSnapshotId
+        "created_at" = $_.CreationTime.ToUniversalTime().ToString("o")
+    }
+})
+
+@{
+    "current"     = [string]$vm.ParentSnapshotId
+    "checkpoints" = $checkpoints
+} | ConvertTo-Json -Depth 3 -Compress
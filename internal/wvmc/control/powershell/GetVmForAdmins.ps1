@@ -0,0 +1,63 @@
+param (
+    [string[]]$hvList
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+# учетная запись гипервизора задается вызывающей стороной, если она есть. ForEach-Object -Parallel
+# не наследует ни ее, ни $PSDefaultParameterValues, поэтому она передается в каждый блок через $using:
+$credential = Get-Variable -Name credential -ValueOnly -ErrorAction SilentlyContinue
+
+$servers =  $hvList | ForEach-Object -Parallel {
+    $credential = $using:credential
+    if ($null -ne $credential) {
+        $PSDefaultParameterValues = @{ "*-VM*:Credential" = $credential }
+    }
+
+    $vms = Get-VM -ComputerName "$_" | Where-Object {$_.ReplicationMode -lt 2};
+
+    if ($null -eq $vms) {
+        continue
+    }
+
+        $vms | ForEach-Object -Parallel {
+            $credential = $using:credential
+            if ($null -ne $credential) {
+                $PSDefaultParameterValues = @{ "*-VM*:Credential" = $credential }
+            }
+
+            $state = $_.State;
+            $networkAdapter = $_ | Get-VMNetworkAdapter;
+            $ip = ''
+
+            $ip4 = $networkAdapter.IPAddresses
+            if ($ip4 -gt 0) {
+                $ip = $ip4[0]
+            }
+            $state = switch ([int]$state) {
+                2 { "Running" }
+                6 { "Saved" }
+                9 { "Paused" }
+                default { "Off" }
+            }
+
+            [PSCustomObject]@{
+                "vmid"      = $_.Id;
+                "name"    = $_.Name;
+                "state"   = $state;
+                "network" = (@($networkAdapter | Where-Object { $_.SwitchName } |
+                    ForEach-Object { $_.SwitchName }) -join ", ");
+                "status"  = $_.Status;
+                "cpu_load" = $_.CPUUsage;
+                "cpu_cores" = $_.ProcessorCount;
+                "memory"  = [math]::Round(($_.MemoryStartup / 1GB), 1);
+                "memory_assigned" = [math]::Round(($_.MemoryAssigned / 1GB), 1);
+                "hv"      = $_.ComputerName;
+                "ip"      = $ip;
+            }
+        
+    }
+} -ThrottleLimit 30;
+
+
+$servers | ConvertTo-Json -AsArray -Compress
\ No newline at end of file
@@ -0,0 +1,39 @@
+param (
+    [string]$hv,
+    [string]$id
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+# учетная запись передается CIM-сессии явно, как в GetHostInventory.ps1
+$credential = Get-Variable -Name credential -ValueOnly -ErrorAction SilentlyContinue
+$sessionParams = @{ ComputerName = $hv }
+if ($null -ne $credential) {
+    $sessionParams["Credential"] = $credential
+}
+
+$cim = New-CimSession @sessionParams -ErrorAction Stop
+
+# задание миграции ВМ на исходном гипервизоре, VirtualSystemName содержит ID ВМ
+try {
+    $job = Get-CimInstance -CimSession $cim -Namespace "root\virtualization\v2" `
+        -ClassName Msvm_MigrationJob -ErrorAction Stop |
+        Where-Object { $_.VirtualSystemName -eq $id } |
+        Select-Object -First 1
+} finally {
+    Remove-CimSession -CimSession $cim -ErrorAction SilentlyContinue
+}
+
+if ($null -eq $job) {
+    [PSCustomObject]@{
+        "active"   = $false
+        "progress" = 0
+    } | ConvertTo-Json -Compress
+
+    return
+}
+
+[PSCustomObject]@{
+    "active"   = $true
+    "progress" = [int]$job.PercentComplete
+} | ConvertTo-Json -Compress
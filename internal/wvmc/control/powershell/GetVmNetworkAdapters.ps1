@@ -0,0 +1,15 @@
+param (
+    [string]$hv,
+    [string]$name
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+Get-VMNetworkAdapter -ComputerName $hv -VMName $name -ErrorAction Stop | ForEach-Object {
+    [PSCustomObject]@{
+        "name"        = $_.Name
+        "switch_name" = [string]$_.SwitchName
+        "mac"         = $_.MacAddress
+        "ip"          = @($_.IPAddresses)
+    }
+} | ConvertTo-Json -AsArray -Compress
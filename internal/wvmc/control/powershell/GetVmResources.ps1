@@ -0,0 +1,25 @@
+param (
+    [string]$hv,
+    [string]$name
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+$vm = Get-VM -ComputerName $hv -Name $name -ErrorAction Stop
+$memory = $vm | Get-VMMemory -ErrorAction Stop
+
+$state = switch ([int]$vm.State) {
+    2 { "Running" }
+    6 { "Saved" }
+    9 { "Paused" }
+    default { "Off" }
+}
+
+[PSCustomObject]@{
+    "state"          = $state
+    "cpu_cores"      = $vm.ProcessorCount
+    "memory_startup" = [int64]($memory.Startup / 1MB)
+    "memory_minimum" = [int64]($memory.Minimum / 1MB)
+    "memory_maximum" = [int64]($memory.Maximum / 1MB)
+    "dynamic_memory" = [bool]$memory.DynamicMemoryEnabled
+} | ConvertTo-Json -Compress
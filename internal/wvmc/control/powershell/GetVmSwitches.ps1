@@ -0,0 +1,13 @@
+param (
+    [string]$hv
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+Get-VMSwitch -ComputerName $hv -ErrorAction Stop | ForEach-Object {
+    [PSCustomObject]@{
+        "name"        = $_.Name
+        "type"        = [string]$_.SwitchType
+        "description" = [string]$_.NetAdapterInterfaceDescription
+    }
+} | ConvertTo-Json -AsArray -Compress
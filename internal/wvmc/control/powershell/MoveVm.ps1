@@ -0,0 +1,18 @@
+param (
+    [string]$hv,
+    [string]$name,
+    [string]$destination,
+    [string]$storage = ""
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+# без storage диски ВМ остаются на месте (общее хранилище), иначе переносятся в каталог storage
+$move = @{}
+
+if ($storage -ne "") {
+    $move["IncludeStorage"] = $true
+    $move["DestinationStoragePath"] = $storage
+}
+
+Move-VM -ComputerName $hv -Name $name -DestinationHost $destination @move -Confirm:$false -ErrorAction Stop
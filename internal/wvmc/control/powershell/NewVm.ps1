@@ -0,0 +1,98 @@
+param (
+    [string]$hv,
+    [string]$name,
+    [string]$vhdx,
+    [string]$disk = "differencing",
+    [int]$generation = 2,
+    [int]$cpu = 1,
+    [int64]$memory = 1024,
+    [string]$switch = "",
+    [string]$path = ""
+)
+
+# память передается в МБ, disk - differencing (разностный диск от $vhdx) или copy (копия $vhdx)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+if ($null -ne (Get-VM -ComputerName $hv -Name $name -ErrorAction SilentlyContinue)) {
+    throw "A virtual machine with the same name already exists: '$name' on '$hv'."
+}
+
+# без path ВМ и ее диск создаются в каталоге ВМ гипервизора по умолчанию
+if ($path -eq "") {
+    $path = (Get-VMHost -ComputerName $hv -ErrorAction Stop).VirtualMachinePath
+}
+
+$diskPath = Join-Path (Join-Path $path $name) ($name + [IO.Path]::GetExtension($vhdx))
+
+# чужой диск по этому пути не перезаписывается и не удаляется при откате
+$diskExists = Invoke-Command -ComputerName $hv -ErrorAction Stop -ScriptBlock {
+    param($to)
+    Test-Path -LiteralPath $to
+} -ArgumentList $diskPath
+
+if ($diskExists) {
+    throw "The virtual hard disk already exists: '$diskPath' on '$hv'."
+}
+
+$vm = $null
+$diskCreated = $false
+
+try {
+    Invoke-Command -ComputerName $hv -ErrorAction Stop -ScriptBlock {
+        param($to)
+        New-Item -ItemType Directory -Path (Split-Path $to) -Force -ErrorAction Stop | Out-Null
+    } -ArgumentList $diskPath
+
+    # диска по этому пути не было, поэтому все, что там появится дальше (в том числе недокопированный
+    # файл), создано этим запуском
+    $diskCreated = $true
+
+    if ($disk -eq "copy") {
+        Invoke-Command -ComputerName $hv -ErrorAction Stop -ScriptBlock {
+            param($from, $to)
+            Copy-Item -LiteralPath $from -Destination $to -ErrorAction Stop
+        } -ArgumentList $vhdx, $diskPath
+    } else {
+        New-VHD -ComputerName $hv -Path $diskPath -ParentPath $vhdx -Differencing -ErrorAction Stop | Out-Null
+    }
+
+    $params = @{
+        ComputerName       = $hv
+        Name               = $name
+        Generation         = $generation
+        MemoryStartupBytes = $memory * 1MB
+        VHDPath            = $diskPath
+        Path               = $path
+    }
+
+    if ($switch -ne "") {
+        $params["SwitchName"] = $switch
+    }
+
+    $vm = New-VM @params -ErrorAction Stop
+    Set-VM -VM $vm -ProcessorCount $cpu -StaticMemory -ErrorAction Stop
+} catch {
+    # удаляем то, что успели создать, чтобы создание можно было повторить
+    if ($null -ne $vm) {
+        Remove-VM -VM $vm -Force -ErrorAction SilentlyContinue
+    }
+
+    if ($diskCreated) {
+        Invoke-Command -ComputerName $hv -ErrorAction SilentlyContinue -ScriptBlock {
+            param($to)
+            Remove-Item -LiteralPath $to -Force -ErrorAction SilentlyContinue
+        } -ArgumentList $diskPath
+    }
+
+    throw
+}
+
+[PSCustomObject]@{
+    "vmid"      = [string]$vm.Id
+    "name"      = $vm.Name
+    "hv"        = $hv
+    "state"     = "Off"
+    "cpu_cores" = $cpu
+    "memory"    = $memory / 1024
+} | ConvertTo-Json -Compress
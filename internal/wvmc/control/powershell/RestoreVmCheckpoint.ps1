@@ -0,0 +1,17 @@
+param (
+    [string]$hv,
+    [string]$name,
+    [string]$id
+)
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+# контрольная точка ищется среди точек этой ВМ, чтобы нельзя было применить точку другой ВМ
+$snapshot = Get-VMSnapshot -ComputerName $hv -VMName $name -ErrorAction Stop |
+    Where-Object { [string]$_.Id -eq $id }
+
+if ($null -eq $snapshot) {
+    throw "Unable to find a checkpoint with id '$id' for virtual machine '$name'."
+}
+
+Restore-VMSnapshot -VMSnapshot $snapshot -Confirm:$false -ErrorAction Stop
@@ -0,0 +1,43 @@
+param (
+    [string]$hv,
+    [string]$name,
+    [int]$cpu = 0,
+    [int64]$startup = 0,
+    [int64]$minimum = 0,
+    [int64]$maximum = 0,
+    [string]$dynamic = ""
+)
+
+# параметры со значением 0 или пустые не изменяются, память передается в МБ
+
+[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding("utf-8")
+
+$vm = Get-VM -ComputerName $hv -Name $name -ErrorAction Stop
+
+if ($cpu -gt 0) {
+    Set-VMProcessor -VM $vm -Count $cpu -ErrorAction Stop
+}
+
+$memory = @{}
+
+if ($dynamic -eq "true") {
+    $memory["DynamicMemoryEnabled"] = $true
+} elseif ($dynamic -eq "false") {
+    $memory["DynamicMemoryEnabled"] = $false
+}
+
+if ($startup -gt 0) {
+    $memory["StartupBytes"] = $startup * 1MB
+}
+
+if ($minimum -gt 0) {
+    $memory["MinimumBytes"] = $minimum * 1MB
+}
+
+if ($maximum -gt 0) {
+    $memory["MaximumBytes"] = $maximum * 1MB
+}
+
+if ($memory.Count -gt 0) {
+    Set-VMMemory -VM $vm @memory -ErrorAction Stop
+}
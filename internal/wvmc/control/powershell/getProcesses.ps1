@@ -59,4 +59,4 @@ Invoke-Command -ComputerName $ip -Authentication Negotiate -Credential $Creds -S
         Write-Output $rdpSessions
     }
 
-} | ConvertTo-Json -AsArray -Depth 3 -Compress
+} -ErrorAction Stop | ConvertTo-Json -AsArray -Depth 3 -Compress
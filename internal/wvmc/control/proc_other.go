@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package control
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup запускает процесс в отдельной группе, чтобы завершать его вместе с дочерними
+func setProcessGroup(e *exec.Cmd) {
+	e.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessTree завершает процесс p вместе со всеми дочерними процессами
+func killProcessTree(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,20 @@
+package control
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup на windows не требуется: дерево процессов завершает taskkill
+func setProcessGroup(_ *exec.Cmd) {}
+
+// killProcessTree завершает процесс p вместе со всеми дочерними процессами
+func killProcessTree(p *os.Process) error {
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run()
+	if err != nil {
+		return p.Kill()
+	}
+
+	return nil
+}
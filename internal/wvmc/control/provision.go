@@ -0,0 +1,115 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Ошибки создания ВМ из шаблона
+var (
+	ErrInvalidTemplate   = errors.New("invalid template")
+	ErrInvalidServerName = errors.New("invalid virtual machine name")
+)
+
+// serverNameRe допустимое имя новой ВМ, имя используется и как имя файла диска
+var serverNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidateTemplate проверяет шаблон t и приводит его поля к значениям по умолчанию
+func (s *ServerService) ValidateTemplate(ctx context.Context, t model.Template) (model.Template,
+	error) {
+	t.Name = strings.TrimSpace(t.Name)
+	t.VHDXPath = strings.TrimSpace(t.VHDXPath)
+	t.Switch = strings.TrimSpace(t.Switch)
+	t.StoragePath = strings.TrimSpace(t.StoragePath)
+
+	if t.DiskType == "" {
+		t.DiskType = model.TemplateDiskDifferencing
+	}
+
+	if t.Generation == 0 {
+		t.Generation = 2
+	}
+
+	if t.Name == "" {
+		return t, fmt.Errorf("%w: name cannot be empty", ErrInvalidTemplate)
+	}
+
+	host, err := s.Hypervisor(ctx, strings.TrimSpace(t.HV))
+	if errors.Is(err, ErrUnknownHost) {
+		return t, fmt.Errorf("%w: hypervisor %q is not in the list of known hypervisors",
+			ErrInvalidTemplate, t.HV)
+	}
+
+	if err != nil {
+		return t, err
+	}
+
+	t.HV = host.Name
+
+	ext := strings.ToLower(path.Ext(strings.ReplaceAll(t.VHDXPath, "\\", "/")))
+	if ext != ".vhdx" && ext != ".vhd" {
+		return t, fmt.Errorf("%w: vhdx_path must be a path to a .vhdx or .vhd file", ErrInvalidTemplate)
+	}
+
+	switch {
+	case t.DiskType != model.TemplateDiskDifferencing && t.DiskType != model.TemplateDiskCopy:
+		return t, fmt.Errorf("%w: disk_type must be %s or %s", ErrInvalidTemplate,
+			model.TemplateDiskDifferencing, model.TemplateDiskCopy)
+	case t.Generation != 1 && t.Generation != 2:
+		return t, fmt.Errorf("%w: generation must be 1 or 2", ErrInvalidTemplate)
+	case t.CPUCores < 1:
+		return t, fmt.Errorf("%w: cpu_cores must be at least 1", ErrInvalidTemplate)
+	case t.Memory < minMemory || t.Memory%2 != 0:
+		return t, fmt.Errorf("%w: memory must be an even number of MB not less than %d",
+			ErrInvalidTemplate, minMemory)
+	}
+
+	return t, nil
+}
+
+// CreateServer создает выключенную ВМ name из шаблона t на гипервизоре шаблона и добавляет ее в кеш.
+// Сеть ВМ подключается к коммутатору шаблона, если он не задан - к коммутатору по умолчанию.
+func (s *ServerService) CreateServer(ctx context.Context, t model.Template,
+	name string) (model.Server, error) {
+	var server model.Server
+
+	if !serverNameRe.MatchString(name) {
+		return server, fmt.Errorf("%w: use up to 64 latin letters, digits, dots, dashes and "+
+			"underscores", ErrInvalidServerName)
+	}
+
+	switchName := t.Switch
+	if switchName == "" {
+		switchName = DefaultSwitch()
+	}
+
+	command := Script(s.scripts.Path(scriptNewVM)).Arg("hv", t.HV).Arg("name", name).
+		Arg("vhdx", t.VHDXPath).Arg("disk", t.DiskType).Int("generation", t.Generation).
+		Int("cpu", t.CPUCores).Int("memory", int(t.Memory)).Arg("switch", switchName).
+		OnHost(t.HV)
+	if t.StoragePath != "" {
+		command.Arg("path", t.StoragePath)
+	}
+
+	out, err := s.exec(ctx, opProvision, command)
+	if err != nil {
+		return server, err
+	}
+
+	if err = json.Unmarshal(out, &server); err != nil {
+		return server, err
+	}
+
+	server.Network = switchName
+
+	s.cache.AddServer(server)
+
+	return server, nil
+}
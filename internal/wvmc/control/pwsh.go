@@ -0,0 +1,284 @@
+package control
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	// paramNameRe допустимое имя параметра powershell
+	paramNameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+	// cmdletNameRe допустимое имя командлета powershell
+	cmdletNameRe = regexp.MustCompile(`^[A-Za-z]+-[A-Za-z]+$`)
+)
+
+// singleQuotes все символы, которые powershell считает одинарной кавычкой
+const singleQuotes = "'‘’‚‛"
+
+type paramKind int
+
+const (
+	paramString paramKind = iota
+	paramInt
+	paramList
+	paramSwitch
+)
+
+// param параметр команды powershell
+type param struct {
+	name   string
+	kind   paramKind
+	value  string
+	values []string
+}
+
+// Cmd описывает команду powershell (скрипт или командлет) с параметрами.
+// Значения параметров передаются только как строковые литералы,
+// поэтому не могут изменить саму команду.
+type Cmd struct {
+	name     string
+	isScript bool
+	host     string
+	user     string
+	password string
+	params   []param
+
+	// op операция, для которой выполняется команда, задается в ServerService.exec
+	op operation
+}
+
+// Script создает команду запуска скрипта по пути path
+func Script(path string) *Cmd {
+	return &Cmd{name: path, isScript: true}
+}
+
+// Cmdlet создает команду вызова командлета name, например Start-VM
+func Cmdlet(name string) *Cmd {
+	if !cmdletNameRe.MatchString(name) {
+		panic(fmt.Sprintf("control: invalid cmdlet name %q", name))
+	}
+
+	return &Cmd{name: name}
+}
+
+// Name возвращает путь скрипта или имя командлета
+func (c *Cmd) Name() string {
+	return c.name
+}
+
+// OnHost указывает гипервизор hv, на котором выполняется команда
+func (c *Cmd) OnHost(hv string) *Cmd {
+	c.host = hv
+	return c
+}
+
+// Host возвращает гипервизор, на котором выполняется команда, либо пустую строку
+func (c *Cmd) Host() string {
+	return c.host
+}
+
+// Credential задает учетную запись, с которой командлеты Hyper-V и Invoke-Command
+// подключаются к гипервизору
+func (c *Cmd) Credential(user, password string) *Cmd {
+	c.user = user
+	c.password = password
+
+	return c
+}
+
+// Arg добавляет строковый параметр
+func (c *Cmd) Arg(name, value string) *Cmd {
+	return c.add(param{name: name, kind: paramString, value: value})
+}
+
+// Int добавляет числовой параметр
+func (c *Cmd) Int(name string, value int) *Cmd {
+	return c.add(param{name: name, kind: paramInt, value: strconv.Itoa(value)})
+}
+
+// List добавляет параметр-массив строк
+func (c *Cmd) List(name string, values []string) *Cmd {
+	return c.add(param{name: name, kind: paramList, values: values})
+}
+
+// Switch добавляет параметр-флаг, например -Force
+func (c *Cmd) Switch(name string) *Cmd {
+	return c.add(param{name: name, kind: paramSwitch})
+}
+
+func (c *Cmd) add(p param) *Cmd {
+	if !paramNameRe.MatchString(p.name) {
+		panic(fmt.Sprintf("control: invalid parameter name %q", p.name))
+	}
+
+	c.params = append(c.params, p)
+
+	return c
+}
+
+// Value возвращает значение строкового или числового параметра name
+func (c *Cmd) Value(name string) string {
+	if p, ok := c.param(name); ok {
+		return p.value
+	}
+
+	return ""
+}
+
+// Values возвращает значения параметра-массива name
+func (c *Cmd) Values(name string) []string {
+	if p, ok := c.param(name); ok {
+		return p.values
+	}
+
+	return nil
+}
+
+// Has проверяет, передан ли параметр name
+func (c *Cmd) Has(name string) bool {
+	_, ok := c.param(name)
+	return ok
+}
+
+func (c *Cmd) param(name string) (param, bool) {
+	for _, p := range c.params {
+		if strings.EqualFold(p.name, name) {
+			return p, true
+		}
+	}
+
+	return param{}, false
+}
+
+// credentialDefaults параметры, которым передается учетная запись команды.
+// Значения задаются в отдельной области видимости и не сохраняются в процессе pwsh пула.
+// Блоки ForEach-Object -Parallel и CIM-сессии их не получают, скрипты передают им $credential сами.
+var credentialDefaults = []string{"*-VM*:Credential", "*-VHD:Credential", "Invoke-Command:Credential"}
+
+// String возвращает текст команды powershell
+func (c *Cmd) String() string {
+	var b strings.Builder
+
+	if c.user != "" {
+		b.WriteString("& { $credential = [pscredential]::new(")
+		b.WriteString(quote(c.user))
+
+		// ConvertTo-SecureString не принимает пустую строку
+		if c.password == "" {
+			b.WriteString(", [securestring]::new())")
+		} else {
+			b.WriteString(", (ConvertTo-SecureString ")
+			b.WriteString(quote(c.password))
+			b.WriteString(" -AsPlainText -Force))")
+		}
+
+		b.WriteString("; $PSDefaultParameterValues = @{")
+
+		for i, name := range credentialDefaults {
+			if i > 0 {
+				b.WriteString("; ")
+			}
+
+			b.WriteString(quote(name))
+			b.WriteString(" = $credential")
+		}
+
+		b.WriteString("}; ")
+	}
+
+	if c.isScript {
+		b.WriteString("& ")
+		b.WriteString(quote(c.name))
+	} else {
+		b.WriteString(c.name)
+	}
+
+	for _, p := range c.params {
+		b.WriteString(" -")
+		b.WriteString(p.name)
+
+		switch p.kind {
+		case paramString:
+			b.WriteString(":")
+			b.WriteString(quote(p.value))
+		case paramInt:
+			b.WriteString(":")
+			b.WriteString(p.value)
+		case paramList:
+			quoted := make([]string, 0, len(p.values))
+			for _, v := range p.values {
+				quoted = append(quoted, quote(v))
+			}
+
+			b.WriteString(":@(")
+			b.WriteString(strings.Join(quoted, ","))
+			b.WriteString(")")
+		}
+	}
+
+	if c.user != "" {
+		b.WriteString(" }")
+	}
+
+	return b.String()
+}
+
+// Encoded возвращает команду в виде для параметра pwsh -EncodedCommand
+func (c *Cmd) Encoded() string {
+	return encodeCommand(c.String())
+}
+
+// encodeCommand кодирует текст команды в base64 от UTF-16LE, как того требует pwsh -EncodedCommand
+func encodeCommand(command string) string {
+	runes := utf16.Encode([]rune(command))
+	b := make([]byte, 0, len(runes)*2)
+
+	for _, r := range runes {
+		b = append(b, byte(r), byte(r>>8))
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// quote возвращает строковый литерал powershell в одинарных кавычках.
+// Внутри такого литерала не раскрываются переменные и выражения,
+// а любая одинарная кавычка экранируется удвоением.
+func quote(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('\'')
+
+	for _, r := range s {
+		if r == 0 {
+			continue
+		}
+
+		if strings.ContainsRune(singleQuotes, r) {
+			b.WriteRune(r)
+		}
+
+		b.WriteRune(r)
+	}
+
+	b.WriteByte('\'')
+
+	return b.String()
+}
+
+// ParseList разбирает список вида "a, 'b', c" из переменной окружения
+func ParseList(s string) []string {
+	result := make([]string, 0)
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.Trim(v, " '\"")
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,181 @@
+package control
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// hostileValues значения, которые не должны менять текст команды
+var hostileValues = []struct {
+	name  string
+	value string
+	quote string
+}{
+	{"empty", "", `''`},
+	{"spaces", "VM 1  web", `'VM 1  web'`},
+	{"apostrophe", "O'Brien", `'O''Brien'`},
+	{"left quote", "a‘b", `'a‘‘b'`},
+	{"right quote", "a’b", `'a’’b'`},
+	{"low quote", "a‚b", `'a‚‚b'`},
+	{"reversed quote", "a‛b", `'a‛‛b'`},
+	{"closing quote and command", "x'; Remove-VM -Name * -Force; '", `'x''; Remove-VM -Name * -Force; '''`},
+	{"mixed quotes", "'’‘", `'''’’‘‘'`},
+	{"subexpression", "$(Stop-Computer)", `'$(Stop-Computer)'`},
+	{"variable", "$env:TOKEN", `'$env:TOKEN'`},
+	{"backtick", "a`'b`", "'a`''b`'"},
+	{"semicolon", "vm; Stop-VM", `'vm; Stop-VM'`},
+	{"newline", "vm\nStop-VM *", "'vm\nStop-VM *'"},
+	{"crlf", "vm\r\nStop-VM *", "'vm\r\nStop-VM *'"},
+	{"nul", "vm\x00'; Stop-VM", `'vm''; Stop-VM'`},
+	{"double quotes", `"vm" “x”`, `'"vm" “x”'`},
+	{"cyrillic", "Сервер 1", `'Сервер 1'`},
+}
+
+// parseQuoted разбирает строковый литерал в одинарных кавычках в начале s так же, как powershell:
+// две подряд одинарные кавычки любого вида - одна кавычка. Возвращает значение и остаток s.
+func parseQuoted(t *testing.T, s string) (string, string) {
+	t.Helper()
+
+	runes := []rune(s)
+	if len(runes) == 0 || !strings.ContainsRune(singleQuotes, runes[0]) {
+		t.Fatalf("%q does not start with a quote", s)
+	}
+
+	var b strings.Builder
+
+	for i := 1; i < len(runes); i++ {
+		if !strings.ContainsRune(singleQuotes, runes[i]) {
+			b.WriteRune(runes[i])
+			continue
+		}
+
+		if i+1 < len(runes) && strings.ContainsRune(singleQuotes, runes[i+1]) {
+			b.WriteRune(runes[i+1])
+			i++
+
+			continue
+		}
+
+		return b.String(), string(runes[i+1:])
+	}
+
+	t.Fatalf("%q is not terminated", s)
+
+	return "", ""
+}
+
+func TestQuote(t *testing.T) {
+	for _, tt := range hostileValues {
+		t.Run(tt.name, func(t *testing.T) {
+			got := quote(tt.value)
+			if got != tt.quote {
+				t.Fatalf("quote(%q) = %q, want %q", tt.value, got, tt.quote)
+			}
+
+			// литерал заканчивается ровно там, где кончается quote, и содержит исходное значение без NUL
+			value, rest := parseQuoted(t, got)
+			if rest != "" {
+				t.Errorf("literal %q ends early, rest %q", got, rest)
+			}
+
+			if want := strings.ReplaceAll(tt.value, "\x00", ""); value != want {
+				t.Errorf("literal %q holds %q, want %q", got, value, want)
+			}
+		})
+	}
+}
+
+func TestCmdString(t *testing.T) {
+	for _, tt := range hostileValues {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Cmdlet("Stop-VM").Arg("Name", tt.value).Switch("Force").Arg("ComputerName", "hv1")
+
+			want := "Stop-VM -Name:" + tt.quote + " -Force -ComputerName:'hv1'"
+			if got := cmd.String(); got != want {
+				t.Fatalf("String() = %q, want %q", got, want)
+			}
+
+			script := Script(`C:\wvmc\scripts\Get VM.ps1`).Arg("name", tt.value).Int("cores", 4).
+				List("hvList", []string{tt.value, "hv1"})
+
+			want = `& 'C:\wvmc\scripts\Get VM.ps1' -name:` + tt.quote + " -cores:4 -hvList:@(" + tt.quote + ",'hv1')"
+			if got := script.String(); got != want {
+				t.Fatalf("String() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCmdStringCredential(t *testing.T) {
+	cmd := Cmdlet("Get-VM").Arg("ComputerName", "hv1").Credential(`DOMAIN\o'neil`, "p'a$s`s")
+
+	want := `& { $credential = [pscredential]::new('DOMAIN\o''neil', (ConvertTo-SecureString 'p''a$s` + "`" +
+		`s' -AsPlainText -Force)); $PSDefaultParameterValues = @{'*-VM*:Credential' = $credential; ` +
+		`'*-VHD:Credential' = $credential; 'Invoke-Command:Credential' = $credential}; ` +
+		`Get-VM -ComputerName:'hv1' }`
+	if got := cmd.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	cmd = Cmdlet("Get-VM").Credential("admin", "")
+	if got := cmd.String(); !strings.Contains(got, "[pscredential]::new('admin', [securestring]::new())") {
+		t.Errorf("String() with empty password = %q", got)
+	}
+}
+
+func TestCmdInvalidNames(t *testing.T) {
+	names := []func(){
+		func() { Cmdlet("Stop-VM; Remove-VM") },
+		func() { Cmdlet("& calc") },
+		func() { Cmdlet("Get-VM").Arg("Name; calc", "x") },
+		func() { Cmdlet("Get-VM").Switch("Force:$true") },
+		func() { Script("a.ps1").List("-hv", nil) },
+	}
+
+	for i, fn := range names {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("case %d: invalid name did not panic", i)
+				}
+			}()
+
+			fn()
+		}()
+	}
+}
+
+func TestEncoded(t *testing.T) {
+	for _, tt := range hostileValues {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Cmdlet("Start-VM").Arg("Name", tt.value).Credential("admin", tt.value)
+			encoded := cmd.Encoded()
+
+			raw, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(raw)%2 != 0 {
+				t.Fatalf("encoded command has odd length %d", len(raw))
+			}
+
+			units := make([]uint16, 0, len(raw)/2)
+			for i := 0; i < len(raw); i += 2 {
+				units = append(units, uint16(raw[i])|uint16(raw[i+1])<<8)
+			}
+
+			if got := string(utf16.Decode(units)); got != cmd.String() {
+				t.Errorf("decoded command = %q, want %q", got, cmd.String())
+			}
+		})
+	}
+
+	// символ вне BMP кодируется суррогатной парой
+	want := base64.StdEncoding.EncodeToString([]byte{0x3d, 0xd8, 0x00, 0xde})
+	if got := encodeCommand("😀"); got != want {
+		t.Errorf("encodeCommand(emoji) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Ошибки изменения процессора и памяти ВМ
+var (
+	ErrVMMustBeOff      = errors.New("virtual machine must be turned off")
+	ErrInvalidResources = errors.New("invalid processor or memory settings")
+)
+
+// minMemory минимальный объем памяти ВМ в МБ
+const minMemory = 32
+
+// Resources процессор и память ВМ, память в МБ
+type Resources struct {
+	State         string `json:"state"`
+	CPUCores      int    `json:"cpu_cores"`
+	MemoryStartup int64  `json:"memory_startup"`
+	MemoryMinimum int64  `json:"memory_minimum"`
+	MemoryMaximum int64  `json:"memory_maximum"`
+	DynamicMemory bool   `json:"dynamic_memory"`
+}
+
+// ResourcesUpdate новые значения процессора и памяти ВМ, nil означает "не изменять"
+type ResourcesUpdate struct {
+	CPUCores      *int   `json:"cpu_cores"`
+	MemoryStartup *int64 `json:"memory_startup"`
+	MemoryMinimum *int64 `json:"memory_minimum"`
+	MemoryMaximum *int64 `json:"memory_maximum"`
+	DynamicMemory *bool  `json:"dynamic_memory"`
+}
+
+// GetServerResources получает процессор и память ВМ server
+func (s *ServerService) GetServerResources(ctx context.Context, server model.Server) (Resources,
+	error) {
+	var resources Resources
+
+	scriptPath := s.scripts.Path(scriptResources)
+
+	out, err := s.exec(ctx, opList, Script(scriptPath).Arg("hv", server.HV).
+		Arg("name", server.Name).OnHost(server.HV))
+	if err != nil {
+		return resources, err
+	}
+
+	if err = json.Unmarshal(out, &resources); err != nil {
+		return resources, err
+	}
+
+	return resources, nil
+}
+
+// SetServerResources изменяет процессор и память ВМ server и возвращает значения до и после изменения.
+// Если изменение невозможно на работающей ВМ, возвращается ErrVMMustBeOff,
+// если новые значения некорректны - ErrInvalidResources.
+func (s *ServerService) SetServerResources(ctx context.Context, server model.Server,
+	update ResourcesUpdate) (Resources, Resources, error) {
+	before, err := s.GetServerResources(ctx, server)
+	if err != nil {
+		return before, before, err
+	}
+
+	after := before.apply(update)
+
+	if !after.DynamicMemory && (update.MemoryMinimum != nil || update.MemoryMaximum != nil) {
+		return before, before, fmt.Errorf("%w: memory_minimum and memory_maximum require dynamic memory",
+			ErrInvalidResources)
+	}
+
+	if after == before {
+		return before, after, nil
+	}
+
+	if err = after.validate(); err != nil {
+		return before, before, err
+	}
+
+	if before.State != string(model.ServerStateStopped) {
+		if reason := offRequired(before, after); reason != "" {
+			return before, before, fmt.Errorf("%w: %s", ErrVMMustBeOff, reason)
+		}
+	}
+
+	command := Script(s.scripts.Path(scriptSetResources)).Arg("hv", server.HV).
+		Arg("name", server.Name).OnHost(server.HV)
+
+	if after.CPUCores != before.CPUCores {
+		command.Int("cpu", after.CPUCores)
+	}
+
+	if after.DynamicMemory != before.DynamicMemory {
+		command.Arg("dynamic", strconv.FormatBool(after.DynamicMemory))
+	}
+
+	if after.MemoryStartup != before.MemoryStartup {
+		command.Int("startup", int(after.MemoryStartup))
+	}
+
+	// минимум и максимум есть только у динамической памяти
+	if after.DynamicMemory {
+		if after.MemoryMinimum != before.MemoryMinimum {
+			command.Int("minimum", int(after.MemoryMinimum))
+		}
+
+		if after.MemoryMaximum != before.MemoryMaximum {
+			command.Int("maximum", int(after.MemoryMaximum))
+		}
+	}
+
+	if _, err = s.exec(ctx, opPower, command); err != nil {
+		return before, before, err
+	}
+
+	s.cache.SetServerResources(server, after.CPUCores, float64(after.MemoryStartup)/1024)
+
+	return before, after, nil
+}
+
+// Diff возвращает описание изменившихся значений в виде "cpu_cores: 2 -> 4"
+func (r Resources) Diff(after Resources) []string {
+	diff := make([]string, 0)
+
+	add := func(name string, before, after interface{}) {
+		if before != after {
+			diff = append(diff, fmt.Sprintf("%s: %v -> %v", name, before, after))
+		}
+	}
+
+	add("cpu_cores", r.CPUCores, after.CPUCores)
+	add("dynamic_memory", r.DynamicMemory, after.DynamicMemory)
+	add("memory_startup", r.MemoryStartup, after.MemoryStartup)
+	add("memory_minimum", r.MemoryMinimum, after.MemoryMinimum)
+	add("memory_maximum", r.MemoryMaximum, after.MemoryMaximum)
+
+	return diff
+}
+
+// apply возвращает значения с примененными изменениями update
+func (r Resources) apply(update ResourcesUpdate) Resources {
+	if update.CPUCores != nil {
+		r.CPUCores = *update.CPUCores
+	}
+
+	if update.MemoryStartup != nil {
+		r.MemoryStartup = *update.MemoryStartup
+	}
+
+	if update.MemoryMinimum != nil {
+		r.MemoryMinimum = *update.MemoryMinimum
+	}
+
+	if update.MemoryMaximum != nil {
+		r.MemoryMaximum = *update.MemoryMaximum
+	}
+
+	if update.DynamicMemory != nil {
+		r.DynamicMemory = *update.DynamicMemory
+	}
+
+	return r
+}
+
+// validate проверяет значения так же, как Hyper-V: память кратна 2 МБ,
+// у динамической памяти минимум <= начальный объем <= максимум
+func (r Resources) validate() error {
+	if r.CPUCores < 1 {
+		return fmt.Errorf("%w: cpu_cores must be at least 1", ErrInvalidResources)
+	}
+
+	type memoryValue struct {
+		name  string
+		value int64
+	}
+
+	memory := []memoryValue{{"memory_startup", r.MemoryStartup}}
+
+	if r.DynamicMemory {
+		memory = append(memory, memoryValue{"memory_minimum", r.MemoryMinimum},
+			memoryValue{"memory_maximum", r.MemoryMaximum})
+	}
+
+	for _, m := range memory {
+		if m.value < minMemory || m.value%2 != 0 {
+			return fmt.Errorf("%w: %s must be an even number of MB not less than %d",
+				ErrInvalidResources, m.name, minMemory)
+		}
+	}
+
+	if r.DynamicMemory && (r.MemoryMinimum > r.MemoryStartup || r.MemoryStartup > r.MemoryMaximum) {
+		return fmt.Errorf("%w: memory_minimum <= memory_startup <= memory_maximum is required",
+			ErrInvalidResources)
+	}
+
+	return nil
+}
+
+// offRequired возвращает причину, по которой изменение before -> after невозможно на включенной ВМ,
+// либо пустую строку. Работающей ВМ Hyper-V позволяет менять только объем статической памяти,
+// а у динамической - уменьшать минимум и увеличивать максимум.
+func offRequired(before, after Resources) string {
+	switch {
+	case after.CPUCores != before.CPUCores:
+		return "processor count can be changed only when the virtual machine is off"
+	case after.DynamicMemory != before.DynamicMemory:
+		return "dynamic memory can be turned on or off only when the virtual machine is off"
+	case before.State != string(model.ServerStateRunning):
+		return "memory can be changed only when the virtual machine is off or running"
+	case !after.DynamicMemory:
+		return ""
+	case after.MemoryStartup != before.MemoryStartup:
+		return "startup memory with dynamic memory can be changed only when the virtual machine is off"
+	case after.MemoryMinimum > before.MemoryMinimum:
+		return "minimum memory can be increased only when the virtual machine is off"
+	case after.MemoryMaximum < before.MemoryMaximum:
+		return "maximum memory can be decreased only when the virtual machine is off"
+	}
+
+	return ""
+}
@@ -0,0 +1,171 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func intPtr(v int) *int       { return &v }
+func int64Ptr(v int64) *int64 { return &v }
+func boolPtr(v bool) *bool    { return &v }
+
+func TestResourcesValidate(t *testing.T) {
+	static := Resources{CPUCores: 2, MemoryStartup: 4096}
+	dynamic := Resources{CPUCores: 2, MemoryStartup: 4096, MemoryMinimum: 512, MemoryMaximum: 8192,
+		DynamicMemory: true}
+
+	tests := []struct {
+		name  string
+		r     Resources
+		valid bool
+	}{
+		{"static", static, true},
+		{"dynamic", dynamic, true},
+		{"minimum memory", Resources{CPUCores: 1, MemoryStartup: minMemory}, true},
+		{"no cores", Resources{CPUCores: 0, MemoryStartup: 4096}, false},
+		{"odd memory", Resources{CPUCores: 1, MemoryStartup: 4095}, false},
+		{"too little memory", Resources{CPUCores: 1, MemoryStartup: minMemory - 2}, false},
+		{"negative memory", Resources{CPUCores: 1, MemoryStartup: -4096}, false},
+		// у статической памяти минимум и максимум не проверяются
+		{"static ignores limits", Resources{CPUCores: 1, MemoryStartup: 4096, MemoryMinimum: 1}, true},
+		{"dynamic odd maximum", Resources{CPUCores: 1, MemoryStartup: 4096, MemoryMinimum: 512,
+			MemoryMaximum: 8191, DynamicMemory: true}, false},
+		{"dynamic minimum above startup", Resources{CPUCores: 1, MemoryStartup: 1024, MemoryMinimum: 2048,
+			MemoryMaximum: 8192, DynamicMemory: true}, false},
+		{"dynamic startup above maximum", Resources{CPUCores: 1, MemoryStartup: 8194, MemoryMinimum: 512,
+			MemoryMaximum: 8192, DynamicMemory: true}, false},
+		{"dynamic equal limits", Resources{CPUCores: 1, MemoryStartup: 2048, MemoryMinimum: 2048,
+			MemoryMaximum: 2048, DynamicMemory: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.r.validate()
+			if (err == nil) != tt.valid {
+				t.Fatalf("validate() = %v, want valid %v", err, tt.valid)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidResources) {
+				t.Errorf("validate() = %v, want ErrInvalidResources", err)
+			}
+		})
+	}
+}
+
+func TestOffRequired(t *testing.T) {
+	running := string(model.ServerStateRunning)
+	static := Resources{State: running, CPUCores: 2, MemoryStartup: 4096}
+	dynamic := Resources{State: running, CPUCores: 2, MemoryStartup: 4096, MemoryMinimum: 1024,
+		MemoryMaximum: 8192, DynamicMemory: true}
+
+	with := func(r Resources, fn func(r *Resources)) Resources {
+		fn(&r)
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		before Resources
+		after  Resources
+		off    bool
+	}{
+		{"nothing changes", static, static, false},
+		{"cores", static, with(static, func(r *Resources) { r.CPUCores = 4 }), true},
+		{"static memory up", static, with(static, func(r *Resources) { r.MemoryStartup = 8192 }), false},
+		{"static memory down", static, with(static, func(r *Resources) { r.MemoryStartup = 2048 }), false},
+		{"turn on dynamic", static, with(static, func(r *Resources) { r.DynamicMemory = true }), true},
+		{"turn off dynamic", dynamic, with(dynamic, func(r *Resources) { r.DynamicMemory = false }), true},
+		{"dynamic startup", dynamic, with(dynamic, func(r *Resources) { r.MemoryStartup = 2048 }), true},
+		{"dynamic minimum down", dynamic, with(dynamic, func(r *Resources) { r.MemoryMinimum = 512 }), false},
+		{"dynamic minimum up", dynamic, with(dynamic, func(r *Resources) { r.MemoryMinimum = 2048 }), true},
+		{"dynamic maximum up", dynamic, with(dynamic, func(r *Resources) { r.MemoryMaximum = 16384 }), false},
+		{"dynamic maximum down", dynamic, with(dynamic, func(r *Resources) { r.MemoryMaximum = 4096 }), true},
+		{"saved vm memory", with(static, func(r *Resources) { r.State = string(model.ServerStateSaved) }),
+			with(static, func(r *Resources) { r.MemoryStartup = 8192 }), true},
+		{"paused vm memory", with(static, func(r *Resources) { r.State = string(model.ServerStatePaused) }),
+			with(static, func(r *Resources) { r.MemoryStartup = 8192 }), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := offRequired(tt.before, tt.after)
+			if (reason != "") != tt.off {
+				t.Errorf("offRequired() = %q, want off required %v", reason, tt.off)
+			}
+		})
+	}
+}
+
+func TestResourcesApplyDiff(t *testing.T) {
+	before := Resources{CPUCores: 2, MemoryStartup: 4096}
+
+	after := before.apply(ResourcesUpdate{CPUCores: intPtr(4), DynamicMemory: boolPtr(true),
+		MemoryMaximum: int64Ptr(8192)})
+
+	want := Resources{CPUCores: 4, MemoryStartup: 4096, MemoryMaximum: 8192, DynamicMemory: true}
+	if after != want {
+		t.Fatalf("apply() = %+v, want %+v", after, want)
+	}
+
+	if before.apply(ResourcesUpdate{}) != before {
+		t.Error("empty update changed resources")
+	}
+
+	diff := before.Diff(after)
+	wantDiff := []string{"cpu_cores: 2 -> 4", "dynamic_memory: false -> true", "memory_maximum: 0 -> 8192"}
+
+	if !reflect.DeepEqual(diff, wantDiff) {
+		t.Errorf("Diff() = %q, want %q", diff, wantDiff)
+	}
+}
+
+func TestSetServerResources(t *testing.T) {
+	svc, _, c := newTestService(t, "hv1")
+	ctx := context.Background()
+
+	if _, err := svc.RefreshServers(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := cachedServer(t, c, "hv1-VM1")
+
+	// процессор работающей ВМ не меняется
+	_, _, err := svc.SetServerResources(ctx, server, ResourcesUpdate{CPUCores: intPtr(4)})
+	if !errors.Is(err, ErrVMMustBeOff) {
+		t.Fatalf("cores of running vm: got %v, want ErrVMMustBeOff", err)
+	}
+
+	_, _, err = svc.SetServerResources(ctx, server, ResourcesUpdate{MemoryMaximum: int64Ptr(8192)})
+	if !errors.Is(err, ErrInvalidResources) {
+		t.Fatalf("maximum of static memory: got %v, want ErrInvalidResources", err)
+	}
+
+	before, after, err := svc.SetServerResources(ctx, server, ResourcesUpdate{MemoryStartup: int64Ptr(6144)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before.MemoryStartup != 4096 || after.MemoryStartup != 6144 {
+		t.Errorf("memory %d -> %d, want 4096 -> 6144", before.MemoryStartup, after.MemoryStartup)
+	}
+
+	if got := cachedServer(t, c, server.Name).Memory; got != 6 {
+		t.Errorf("cached memory = %v, want 6", got)
+	}
+
+	if _, err = svc.StopServer(ctx, server); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = svc.SetServerResources(ctx, server, ResourcesUpdate{CPUCores: intPtr(4)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if current, err := svc.GetServerResources(ctx, server); err != nil || current.CPUCores != 4 {
+		t.Errorf("cores after change = %d, %v, want 4", current.CPUCores, err)
+	}
+}
@@ -0,0 +1,199 @@
+package control
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anaxita/logit"
+)
+
+// Имена скриптов powershell, которые использует ServerService
+const (
+	scriptVMsForAdmins   = "GetVmForAdmins.ps1"
+	scriptVMByHvAndName  = "GetVmByHvAndName.ps1"
+	scriptServerServices = "GetServerServices.ps1"
+	scriptStartService   = "StartService.ps1"
+	scriptStopService    = "StopService.ps1"
+	scriptRestartService = "RestartService.ps1"
+	scriptDiskFreeSpace  = "GetDiskFreeSpace.ps1"
+	scriptProcesses      = "getProcesses.ps1"
+	scriptStopProcess    = "StopProcess.ps1"
+	scriptDisconnectRDP  = "DisconnectRDPUser.ps1"
+
+	scriptCheckpoints       = "GetVmCheckpoints.ps1"
+	scriptCreateCheckpoint  = "CreateVmCheckpoint.ps1"
+	scriptRestoreCheckpoint = "RestoreVmCheckpoint.ps1"
+	scriptRemoveCheckpoint  = "RemoveVmCheckpoint.ps1"
+
+	scriptSwitches        = "GetVmSwitches.ps1"
+	scriptNetworkAdapters = "GetVmNetworkAdapters.ps1"
+
+	scriptResources    = "GetVmResources.ps1"
+	scriptSetResources = "SetVmResources.ps1"
+
+	scriptMoveVM            = "MoveVm.ps1"
+	scriptMigrationProgress = "GetVmMigrationProgress.ps1"
+
+	scriptNewVM = "NewVm.ps1"
+
+	scriptHostInventory = "GetHostInventory.ps1"
+)
+
+// embeddedScriptsSource источник скриптов, встроенных в бинарный файл
+const embeddedScriptsSource = "embedded"
+
+// requiredScripts скрипты, без которых сервис не может работать
+var requiredScripts = []string{
+	scriptVMsForAdmins,
+	scriptVMByHvAndName,
+	scriptServerServices,
+	scriptStartService,
+	scriptStopService,
+	scriptRestartService,
+	scriptDiskFreeSpace,
+	scriptProcesses,
+	scriptStopProcess,
+	scriptDisconnectRDP,
+	scriptCheckpoints,
+	scriptCreateCheckpoint,
+	scriptRestoreCheckpoint,
+	scriptRemoveCheckpoint,
+	scriptSwitches,
+	scriptNetworkAdapters,
+	scriptResources,
+	scriptSetResources,
+	scriptMoveVM,
+	scriptMigrationProgress,
+	scriptNewVM,
+	scriptHostInventory,
+}
+
+//go:embed powershell/*.ps1
+var embeddedScripts embed.FS
+
+// ScriptSet набор скриптов powershell, записанных во временный каталог.
+// Скрипты берутся из бинарного файла, а одноименные файлы каталога переопределения заменяют их.
+type ScriptSet struct {
+	dir        string
+	source     string
+	version    string
+	overridden []string
+}
+
+// LoadScripts записывает встроенные скрипты во временный каталог, заменяя их файлами
+// из overrideDir (если каталог задан), и проверяет, что есть все необходимые скрипты
+func LoadScripts(overrideDir string) (*ScriptSet, error) {
+	scripts := make(map[string][]byte)
+
+	embedded, err := fs.Glob(embeddedScripts, "powershell/*.ps1")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range embedded {
+		b, err := embeddedScripts.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		scripts[filepath.Base(path)] = b
+	}
+
+	set := &ScriptSet{source: embeddedScriptsSource}
+
+	if overrideDir != "" {
+		paths, err := filepath.Glob(filepath.Join(overrideDir, "*.ps1"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			scripts[filepath.Base(path)] = b
+			set.overridden = append(set.overridden, filepath.Base(path))
+		}
+
+		if len(set.overridden) > 0 {
+			set.source = fmt.Sprintf("%s + %s", embeddedScriptsSource, overrideDir)
+		}
+	}
+
+	for _, name := range requiredScripts {
+		if _, ok := scripts[name]; !ok {
+			return nil, fmt.Errorf("powershell script %s is missing", name)
+		}
+	}
+
+	set.dir, err = os.MkdirTemp("", "wvmc-powershell-")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(scripts))
+	for name := range scripts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	hash := sha256.New()
+
+	for _, name := range names {
+		if err = os.WriteFile(filepath.Join(set.dir, name), scripts[name], 0600); err != nil {
+			_ = set.Close()
+			return nil, err
+		}
+
+		hash.Write([]byte(name))
+		hash.Write(scripts[name])
+	}
+
+	set.version = hex.EncodeToString(hash.Sum(nil))[:12]
+
+	return set, nil
+}
+
+// Path возвращает путь к скрипту name
+func (s *ScriptSet) Path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// Source возвращает источник скриптов: встроенные или встроенные с каталогом переопределения
+func (s *ScriptSet) Source() string {
+	return s.source
+}
+
+// Version возвращает версию набора скриптов: хеш имен и содержимого всех скриптов
+func (s *ScriptSet) Version() string {
+	return s.version
+}
+
+// Overridden возвращает имена скриптов, взятых из каталога переопределения
+func (s *ScriptSet) Overridden() []string {
+	return s.overridden
+}
+
+// LogInfo выводит в лог, какой набор скриптов используется
+func (s *ScriptSet) LogInfo() {
+	logit.Info("Скрипты powershell:", s.source, "версия", s.version, "каталог", s.dir)
+
+	if len(s.overridden) > 0 {
+		logit.Info("Переопределенные скрипты:", strings.Join(s.overridden, ", "))
+	}
+}
+
+// Close удаляет временный каталог со скриптами
+func (s *ScriptSet) Close() error {
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,963 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// simVM описывает состояние виртуальной машины в симуляторе
+type simVM struct {
+	ID          string
+	Name        string
+	HV          string
+	IP          string
+	State       string
+	Adapters    []*simAdapter
+	Description string
+	CPUCores    int
+	Weight      int
+	Memory      int64
+	MemoryMin   int64
+	MemoryMax   int64
+	Dynamic     bool
+	Services    []WinServices
+	Volumes     []WinVolume
+	Sessions    []WinRDPSesion
+	Checkpoints []*simCheckpoint
+	Current     string
+}
+
+// simAdapter сетевой адаптер ВМ в симуляторе
+type simAdapter struct {
+	Name   string
+	Switch string
+	MAC    string
+}
+
+// simCheckpoint контрольная точка ВМ в симуляторе
+type simCheckpoint struct {
+	ID        string
+	Name      string
+	ParentID  string
+	CreatedAt time.Time
+	Running   bool // ВМ работала, когда создавалась точка
+}
+
+// Simulator - симулятор Hyper-V, реализует интерфейс Commander без вызова powershell.
+// Хранит состояние ВМ, служб, процессов и дисков в памяти.
+type Simulator struct {
+	mu      sync.Mutex
+	vms     []*simVM
+	rnd     *rand.Rand
+	latency time.Duration
+	down    map[string]bool
+	moving  map[string]simMove
+}
+
+// simMove перенос ВМ в симуляторе, длится столько же, сколько задержка команды
+type simMove struct {
+	startedAt time.Time
+	duration  time.Duration
+}
+
+// NewSimulator создает симулятор с тестовыми ВМ на каждом гипервизоре из hvs
+func NewSimulator(hvs ...string) *Simulator {
+	s := &Simulator{rnd: rand.New(rand.NewSource(1)), down: make(map[string]bool),
+		moving: make(map[string]simMove)}
+
+	for i, hv := range hvs {
+		hv = strings.TrimSpace(hv)
+		if hv == "" {
+			continue
+		}
+
+		for n := 1; n <= 3; n++ {
+			state := string(model.ServerStateRunning)
+			network := simSwitchName
+			if n == 3 {
+				state = string(model.ServerStateStopped)
+				network = ""
+			}
+
+			adapters := []*simAdapter{
+				{Name: "Network Adapter", Switch: network, MAC: fmt.Sprintf("00155D%02X%02X01", i+1, n)},
+			}
+
+			// у второй ВМ есть адаптер локальной сети
+			if n == 2 {
+				adapters = append(adapters, &simAdapter{Name: "LAN", Switch: simLANSwitchName,
+					MAC: fmt.Sprintf("00155D%02X%02X02", i+1, n)})
+			}
+
+			s.vms = append(s.vms, &simVM{
+				ID:          s.newID(),
+				Name:        fmt.Sprintf("%s-VM%d", hv, n),
+				HV:          hv,
+				IP:          fmt.Sprintf("10.0.%d.%d", i+1, n+10),
+				State:       state,
+				Adapters:    adapters,
+				Description: "simulated vm",
+				CPUCores:    2 * n,
+				Weight:      100,
+				Memory:      int64(4096 * n),
+				MemoryMin:   512,
+				MemoryMax:   int64(8192 * n),
+				Dynamic:     n == 2,
+				Services:    newSimServices(),
+				Volumes:     newSimVolumes(n),
+				Sessions:    newSimSessions(),
+			})
+		}
+	}
+
+	return s
+}
+
+// Коммутаторы каждого гипервизора симулятора
+const (
+	simSwitchName    = "DMZ - Virtual Switch"
+	simLANSwitchName = "LAN - Virtual Switch"
+)
+
+// SetLatency задает задержку выполнения каждой команды, чтобы имитировать медленный гипервизор
+func (s *Simulator) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.latency = d
+	s.mu.Unlock()
+}
+
+// SetHostDown делает гипервизор hv недоступным (down = true) или снова доступным
+func (s *Simulator) SetHostDown(hv string, down bool) {
+	s.mu.Lock()
+	s.down[strings.ToLower(hv)] = down
+	s.mu.Unlock()
+}
+
+// run выполняет команду powershell над состоянием в памяти
+func (s *Simulator) run(ctx context.Context, cmd *Cmd) ([]byte, error) {
+	name := filepath.Base(cmd.Name())
+
+	logit.Info("SIMULATOR", cmd.Name(), cmd.Host())
+
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+
+	switch name {
+	case scriptMoveVM:
+		id := s.startMove(cmd.Value("hv"), cmd.Value("name"), latency)
+		defer s.finishMove(id)
+	case scriptMigrationProgress:
+		// ход переноса запрашивается без задержки, иначе ответ придет после окончания переноса
+		latency = 0
+	}
+
+	select {
+	case <-time.After(latency):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	out, err := s.exec(name, cmd)
+	if err != nil {
+		return nil, newCommandError(cmd.Name(), 1, err.Error())
+	}
+
+	return out, nil
+}
+
+// exec выполняет команду name, ошибки возвращаются с текстом как у powershell
+func (s *Simulator) exec(name string, cmd *Cmd) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if hv := cmd.Host(); s.down[strings.ToLower(hv)] {
+		return nil, fmt.Errorf("Connecting to remote server %s failed: WinRM cannot complete the "+
+			"operation.", hv)
+	}
+
+	switch name {
+	case scriptVMsForAdmins:
+		return s.vmsForAdmins(cmd.Values("hvList"))
+	case scriptVMByHvAndName:
+		return s.vmByHvAndName(cmd.Value("hv"), cmd.Value("name"))
+	case "Start-VM":
+		return s.setState(cmd.Value("ComputerName"), cmd.Value("Name"), model.ServerStateRunning,
+			model.ServerStateStopped, model.ServerStateSaved)
+	case "Stop-VM":
+		return s.setState(cmd.Value("ComputerName"), cmd.Value("Name"), model.ServerStateStopped,
+			model.ServerStateRunning, model.ServerStateSaved, model.ServerStatePaused)
+	case "Restart-VM":
+		return s.setState(cmd.Value("ComputerName"), cmd.Value("Name"), model.ServerStateRunning,
+			model.ServerStateRunning)
+	case "Save-VM":
+		return s.setState(cmd.Value("ComputerName"), cmd.Value("Name"), model.ServerStateSaved,
+			model.ServerStateRunning, model.ServerStatePaused)
+	case "Suspend-VM":
+		return s.setState(cmd.Value("ComputerName"), cmd.Value("Name"), model.ServerStatePaused,
+			model.ServerStateRunning)
+	case "Resume-VM":
+		return s.setState(cmd.Value("ComputerName"), cmd.Value("Name"), model.ServerStateRunning,
+			model.ServerStatePaused)
+	case "Connect-VMNetworkAdapter":
+		return s.setSwitch(cmd.Value("ComputerName"), cmd.Value("VMName"), cmd.Value("Name"),
+			cmd.Value("SwitchName"))
+	case "Disconnect-VMNetworkAdapter":
+		return s.setSwitch(cmd.Value("ComputerName"), cmd.Value("VMName"), cmd.Value("Name"), "")
+	case scriptSwitches:
+		return s.switches()
+	case scriptNetworkAdapters:
+		return s.adapters(cmd.Value("hv"), cmd.Value("name"))
+	case scriptCheckpoints:
+		return s.checkpoints(cmd.Value("hv"), cmd.Value("name"))
+	case scriptCreateCheckpoint:
+		return s.createCheckpoint(cmd.Value("hv"), cmd.Value("name"), cmd.Value("checkpoint"))
+	case scriptRestoreCheckpoint:
+		return s.restoreCheckpoint(cmd.Value("hv"), cmd.Value("name"), cmd.Value("id"))
+	case scriptRemoveCheckpoint:
+		return s.removeCheckpoint(cmd.Value("hv"), cmd.Value("name"), cmd.Value("id"))
+	case scriptMoveVM:
+		return s.move(cmd.Value("hv"), cmd.Value("name"), cmd.Value("destination"))
+	case scriptMigrationProgress:
+		return s.migrationProgress(cmd.Value("hv"), cmd.Value("id"))
+	case scriptNewVM:
+		return s.newVM(cmd)
+	case scriptHostInventory:
+		return s.hostInventory(cmd.Value("hv"))
+	case scriptResources:
+		return s.resources(cmd.Value("hv"), cmd.Value("name"))
+	case scriptSetResources:
+		return s.setResources(cmd)
+	case scriptServerServices:
+		return s.services(cmd.Value("ip"))
+	case scriptStartService:
+		return s.setServiceState(cmd.Value("ip"), cmd.Value("name"), "Running")
+	case scriptStopService:
+		return s.setServiceState(cmd.Value("ip"), cmd.Value("name"), "Stopped")
+	case scriptRestartService:
+		return s.setServiceState(cmd.Value("ip"), cmd.Value("name"), "Running")
+	case scriptDiskFreeSpace:
+		return s.volumes(cmd.Value("ip"))
+	case scriptProcesses:
+		return s.processes(cmd.Value("ip"))
+	case scriptStopProcess:
+		return s.stopProcess(cmd.Value("ip"), cmd.Value("id"))
+	case scriptDisconnectRDP:
+		return s.disconnectSession(cmd.Value("ip"), cmd.Value("id"))
+	}
+
+	return nil, fmt.Errorf("The term '%s' is not recognized as a name of a cmdlet, function, "+
+		"script file, or executable program.", name)
+}
+
+func (s *Simulator) vmsForAdmins(hvs []string) ([]byte, error) {
+	type vm struct {
+		VMID     string  `json:"vmid"`
+		Name     string  `json:"name"`
+		State    string  `json:"state"`
+		Network  string  `json:"network"`
+		Status   string  `json:"status"`
+		CPU      int     `json:"cpu_load"`
+		Cores    int     `json:"cpu_cores"`
+		Memory   float64 `json:"memory"`
+		Assigned float64 `json:"memory_assigned"`
+		HV       string  `json:"hv"`
+		IP       string  `json:"ip"`
+	}
+
+	result := make([]vm, 0)
+
+	for _, v := range s.filter(hvs, nil) {
+		result = append(result, vm{
+			VMID:     v.ID,
+			Name:     v.Name,
+			State:    v.State,
+			Network:  v.network(),
+			Status:   "Operating normally",
+			CPU:      s.cpuUsage(v),
+			Cores:    v.CPUCores,
+			Memory:   v.memoryGB(),
+			Assigned: v.memoryAssignedGB(),
+			HV:       v.HV,
+			IP:       v.ip(),
+		})
+	}
+
+	return json.Marshal(result)
+}
+
+func (s *Simulator) vmByHvAndName(hv, name string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"vmid":        v.ID,
+		"name":        v.Name,
+		"state":       v.State,
+		"cpu_load":    s.cpuUsage(v),
+		"cpu_cores":   v.CPUCores,
+		"weight":      v.Weight,
+		"description": v.Description,
+		"memory":      v.memoryGB(),
+		"network":     v.network(),
+		"hv":          v.HV,
+	}
+
+	return json.Marshal(data)
+}
+
+// setState переводит ВМ в состояние state, если она находится в одном из состояний from
+func (s *Simulator) setState(hv, name string, state model.ServerState,
+	from ...model.ServerState) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := false
+	for _, f := range from {
+		if v.State == string(f) {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return nil, fmt.Errorf("'%s' failed to change state. The operation cannot be performed "+
+			"while the object is in its current state.", name)
+	}
+
+	v.State = string(state)
+
+	return []byte{}, nil
+}
+
+// setSwitch подключает адаптер adapter (все адаптеры, если adapter пуст) к коммутатору switchName,
+// пустой switchName отключает адаптер
+func (s *Simulator) setSwitch(hv, name, adapter, switchName string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if switchName != "" && switchName != simSwitchName && switchName != simLANSwitchName {
+		return nil, fmt.Errorf("Hyper-V was unable to find a virtual switch with name \"%s\".",
+			switchName)
+	}
+
+	found := false
+	for _, a := range v.Adapters {
+		if adapter == "" || a.Name == adapter {
+			a.Switch = switchName
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Hyper-V was unable to find a network adapter with name \"%s\" "+
+			"for virtual machine \"%s\".", adapter, name)
+	}
+
+	return []byte{}, nil
+}
+
+func (s *Simulator) switches() ([]byte, error) {
+	return json.Marshal([]VMSwitch{
+		{Name: simSwitchName, Type: "External", Description: "Intel(R) Ethernet Connection"},
+		{Name: simLANSwitchName, Type: "Internal"},
+	})
+}
+
+func (s *Simulator) adapters(hv, name string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]NetworkAdapter, 0, len(v.Adapters))
+	for _, a := range v.Adapters {
+		ip := make([]string, 0)
+		if a.Switch != "" && v.ip() != "" {
+			ip = append(ip, v.ip())
+		}
+
+		result = append(result, NetworkAdapter{Name: a.Name, SwitchName: a.Switch, MAC: a.MAC, IP: ip})
+	}
+
+	return json.Marshal(result)
+}
+
+func (s *Simulator) checkpoints(hv, name string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	list := checkpointList{Current: v.Current, Checkpoints: make([]Checkpoint, 0)}
+	for _, c := range v.Checkpoints {
+		list.Checkpoints = append(list.Checkpoints, c.checkpoint())
+	}
+
+	return json.Marshal(list)
+}
+
+func (s *Simulator) createCheckpoint(hv, name, checkpoint string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &simCheckpoint{
+		ID:        s.newID(),
+		Name:      checkpoint,
+		ParentID:  v.Current,
+		CreatedAt: time.Now().UTC(),
+		Running:   v.State == string(model.ServerStateRunning) || v.State == string(model.ServerStatePaused),
+	}
+
+	v.Checkpoints = append(v.Checkpoints, c)
+	v.Current = c.ID
+
+	return json.Marshal(c.checkpoint())
+}
+
+func (s *Simulator) restoreCheckpoint(hv, name, id string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := v.findCheckpoint(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// как в Hyper-V: точка работающей ВМ восстанавливается в сохраненное состояние, остальные - выключенными
+	v.State = string(model.ServerStateStopped)
+	if v.Checkpoints[i].Running {
+		v.State = string(model.ServerStateSaved)
+	}
+
+	v.Current = id
+
+	return []byte{}, nil
+}
+
+func (s *Simulator) removeCheckpoint(hv, name, id string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := v.findCheckpoint(id)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := v.Checkpoints[i]
+	v.Checkpoints = append(v.Checkpoints[:i], v.Checkpoints[i+1:]...)
+
+	// дочерние точки и текущее состояние переходят к родителю удаленной точки
+	for _, c := range v.Checkpoints {
+		if c.ParentID == removed.ID {
+			c.ParentID = removed.ParentID
+		}
+	}
+
+	if v.Current == removed.ID {
+		v.Current = removed.ParentID
+	}
+
+	return []byte{}, nil
+}
+
+func (s *Simulator) resources(hv, name string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Resources{
+		State:         v.State,
+		CPUCores:      v.CPUCores,
+		MemoryStartup: v.Memory,
+		MemoryMinimum: v.MemoryMin,
+		MemoryMaximum: v.MemoryMax,
+		DynamicMemory: v.Dynamic,
+	})
+}
+
+// setResources изменяет процессор и память, как Hyper-V отказывая в изменениях,
+// которые нельзя выполнить на включенной ВМ
+func (s *Simulator) setResources(cmd *Cmd) ([]byte, error) {
+	v, err := s.find(cmd.Value("hv"), cmd.Value("name"))
+	if err != nil {
+		return nil, err
+	}
+
+	invalidState := fmt.Errorf("Failed to modify device. '%s' is not in a valid state to perform "+
+		"the operation.", v.Name)
+	off := v.State == string(model.ServerStateStopped)
+
+	if cmd.Has("cpu") {
+		if !off {
+			return nil, invalidState
+		}
+
+		v.CPUCores, _ = strconv.Atoi(cmd.Value("cpu"))
+	}
+
+	if cmd.Has("dynamic") {
+		if !off {
+			return nil, invalidState
+		}
+
+		v.Dynamic = cmd.Value("dynamic") == "true"
+	}
+
+	memory := func(name string, value *int64) {
+		if cmd.Has(name) {
+			*value, _ = strconv.ParseInt(cmd.Value(name), 10, 64)
+		}
+	}
+
+	memory("startup", &v.Memory)
+	memory("minimum", &v.MemoryMin)
+	memory("maximum", &v.MemoryMax)
+
+	return []byte{}, nil
+}
+
+// newVM создает выключенную ВМ со статической памятью, как скрипт NewVm.ps1
+func (s *Simulator) newVM(cmd *Cmd) ([]byte, error) {
+	hv, name := cmd.Value("hv"), cmd.Value("name")
+
+	if _, err := s.find(hv, name); err == nil {
+		return nil, fmt.Errorf("A virtual machine with the same name already exists: '%s' on '%s'.",
+			name, hv)
+	}
+
+	// базовые диски симулятора находятся в C:\Templates
+	if !strings.HasPrefix(strings.ToLower(cmd.Value("vhdx")), `c:\templates\`) {
+		return nil, fmt.Errorf("The system cannot find the file specified: '%s'.", cmd.Value("vhdx"))
+	}
+
+	cpu, _ := strconv.Atoi(cmd.Value("cpu"))
+	memory, _ := strconv.ParseInt(cmd.Value("memory"), 10, 64)
+
+	v := &simVM{
+		ID:          s.newID(),
+		Name:        name,
+		HV:          hv,
+		State:       string(model.ServerStateStopped),
+		Adapters:    []*simAdapter{{Name: "Network Adapter", Switch: cmd.Value("switch"), MAC: "00155D0000FF"}},
+		Description: "created from template",
+		CPUCores:    cpu,
+		Weight:      100,
+		Memory:      memory,
+		MemoryMin:   memory,
+		MemoryMax:   memory,
+	}
+
+	s.vms = append(s.vms, v)
+
+	return json.Marshal(map[string]interface{}{
+		"vmid":      v.ID,
+		"name":      v.Name,
+		"hv":        v.HV,
+		"state":     v.State,
+		"cpu_cores": v.CPUCores,
+		"memory":    v.memoryGB(),
+	})
+}
+
+// Ресурсы каждого гипервизора симулятора
+const (
+	simHostProcessors = 32
+	simHostMemory     = 262144 // МБ
+	simHostReserve    = 8192   // память, занятая самим гипервизором, МБ
+	simHostStorage    = 2048   // ГБ
+	simVMDisk         = 60     // место, занятое одной ВМ, ГБ
+)
+
+// hostInventory возвращает ресурсы гипервизора hv так же, как скрипт GetHostInventory.ps1
+func (s *Simulator) hostInventory(hv string) ([]byte, error) {
+	host := model.HostInventory{
+		Name:              hv,
+		OS:                "Microsoft Windows Server 2022 Datacenter",
+		HypervVersion:     "10.0.20348.1",
+		LogicalProcessors: simHostProcessors,
+	}
+
+	for _, v := range s.vms {
+		if !strings.EqualFold(v.HV, hv) {
+			continue
+		}
+
+		host.VMs.Total++
+		host.VirtualProcessors += v.CPUCores
+
+		switch model.ServerState(v.State) {
+		case model.ServerStateRunning:
+			host.VMs.Running++
+			host.MemoryAssigned += v.Memory
+		case model.ServerStateStopped:
+			host.VMs.Off++
+		case model.ServerStateSaved:
+			host.VMs.Saved++
+		case model.ServerStatePaused:
+			host.VMs.Paused++
+			host.MemoryAssigned += v.Memory
+		default:
+			host.VMs.Other++
+		}
+	}
+
+	host.MemoryTotal = simHostMemory
+	host.MemoryFree = simHostMemory - simHostReserve - host.MemoryAssigned
+	host.Storage = []model.HostStorage{{
+		Path:       `D:\`,
+		SpaceTotal: simHostStorage,
+		SpaceFree:  float64(simHostStorage - simVMDisk*host.VMs.Total),
+	}}
+
+	return json.Marshal(host)
+}
+
+// startMove отмечает начало переноса ВМ и возвращает ее ID
+func (s *Simulator) startMove(hv, name string, duration time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, err := s.find(hv, name)
+	if err != nil {
+		return ""
+	}
+
+	s.moving[v.ID] = simMove{startedAt: time.Now(), duration: duration}
+
+	return v.ID
+}
+
+func (s *Simulator) finishMove(id string) {
+	s.mu.Lock()
+	delete(s.moving, id)
+	s.mu.Unlock()
+}
+
+// move переносит ВМ на гипервизор destination вместе с ее контрольными точками
+func (s *Simulator) move(hv, name, destination string) ([]byte, error) {
+	v, err := s.find(hv, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.down[strings.ToLower(destination)] {
+		return nil, fmt.Errorf("Virtual machine migration operation for '%s' failed at migration "+
+			"source '%s'. The Virtual Machine Management Service failed to establish a connection "+
+			"for a Virtual Machine migration with host '%s'.", name, hv, destination)
+	}
+
+	if _, err = s.find(destination, name); err == nil {
+		return nil, fmt.Errorf("Virtual machine migration operation for '%s' failed at migration "+
+			"destination '%s'. A virtual machine with the same name already exists.", name,
+			destination)
+	}
+
+	v.HV = destination
+
+	return []byte{}, nil
+}
+
+// migrationProgress возвращает ход переноса ВМ id так же, как Msvm_MigrationJob
+func (s *Simulator) migrationProgress(hv, id string) ([]byte, error) {
+	progress := migrationProgress{}
+
+	if m, ok := s.moving[id]; ok && m.duration > 0 {
+		progress.Active = true
+		progress.Progress = int(time.Since(m.startedAt) * 100 / m.duration)
+
+		if progress.Progress > 99 {
+			progress.Progress = 99
+		}
+	}
+
+	return json.Marshal(progress)
+}
+
+func (s *Simulator) services(ip string) ([]byte, error) {
+	v, err := s.findGuest(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v.Services)
+}
+
+func (s *Simulator) setServiceState(ip, name, state string) ([]byte, error) {
+	v, err := s.findGuest(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, svc := range v.Services {
+		if strings.EqualFold(svc.Name, name) {
+			v.Services[i].State = state
+			return []byte{}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Cannot find any service with service name '%s'", name)
+}
+
+func (s *Simulator) volumes(ip string) ([]byte, error) {
+	v, err := s.findGuest(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v.Volumes)
+}
+
+func (s *Simulator) processes(ip string) ([]byte, error) {
+	v, err := s.findGuest(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(v.Sessions) == 0 {
+		return []byte{}, nil
+	}
+
+	for i := range v.Sessions {
+		for j := range v.Sessions[i].Processes {
+			v.Sessions[i].Processes[j].CPULoad = s.rnd.Intn(30)
+		}
+	}
+
+	return json.Marshal(v.Sessions)
+}
+
+func (s *Simulator) stopProcess(ip, id string) ([]byte, error) {
+	v, err := s.findGuest(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	pid, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, session := range v.Sessions {
+		for j, p := range session.Processes {
+			if p.ID == pid {
+				v.Sessions[i].Processes = append(session.Processes[:j], session.Processes[j+1:]...)
+				return []byte{}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("Cannot find a process with the process identifier %d", pid)
+}
+
+func (s *Simulator) disconnectSession(ip, id string) ([]byte, error) {
+	v, err := s.findGuest(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, session := range v.Sessions {
+		if session.SessionID == sessionID {
+			v.Sessions = append(v.Sessions[:i], v.Sessions[i+1:]...)
+			return []byte{}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Session %d is not found", sessionID)
+}
+
+// filter возвращает ВМ с гипервизоров hvs и, если ids не пуст, только с указанными ID
+func (s *Simulator) filter(hvs, ids []string) []*simVM {
+	result := make([]*simVM, 0)
+
+	for _, v := range s.vms {
+		if !contains(hvs, v.HV) {
+			continue
+		}
+
+		if len(ids) > 0 && !contains(ids, v.ID) {
+			continue
+		}
+
+		result = append(result, v)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].HV == result[j].HV {
+			return result[i].Name < result[j].Name
+		}
+
+		return result[i].HV < result[j].HV
+	})
+
+	return result
+}
+
+func (s *Simulator) find(hv, name string) (*simVM, error) {
+	for _, v := range s.vms {
+		if strings.EqualFold(v.HV, hv) && v.Name == name {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Hyper-V was unable to find a virtual machine with name \"%s\" on %s.",
+		name, hv)
+}
+
+func (s *Simulator) findGuest(ip string) (*simVM, error) {
+	for _, v := range s.vms {
+		if v.IP == ip {
+			if v.State != string(model.ServerStateRunning) {
+				return nil, fmt.Errorf("Connecting to remote server %s failed: WinRM cannot complete the operation.", ip)
+			}
+
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Connecting to remote server %s failed: WinRM cannot complete the operation.", ip)
+}
+
+func (s *Simulator) cpuUsage(v *simVM) int {
+	if v.State != string(model.ServerStateRunning) {
+		return 0
+	}
+
+	return s.rnd.Intn(100)
+}
+
+func (s *Simulator) newID() string {
+	b := make([]byte, 16)
+	s.rnd.Read(b)
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (v *simVM) findCheckpoint(id string) (int, error) {
+	for i, c := range v.Checkpoints {
+		if c.ID == id {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unable to find a checkpoint with id '%s' for virtual machine '%s'.", id,
+		v.Name)
+}
+
+func (c *simCheckpoint) checkpoint() Checkpoint {
+	return Checkpoint{ID: c.ID, Name: c.Name, ParentID: c.ParentID, CreatedAt: c.CreatedAt}
+}
+
+// network возвращает коммутаторы подключенных адаптеров так же, как скрипты списка ВМ
+func (v *simVM) network() string {
+	names := make([]string, 0, len(v.Adapters))
+	for _, a := range v.Adapters {
+		if a.Switch != "" {
+			names = append(names, a.Switch)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// memoryGB возвращает начальный объем памяти в ГБ, как скрипты списка ВМ
+func (v *simVM) memoryGB() float64 {
+	return float64(v.Memory) / 1024
+}
+
+// memoryAssignedGB возвращает память, выделенную ВМ, как MemoryAssigned: только для работающих
+// и приостановленных ВМ
+func (v *simVM) memoryAssignedGB() float64 {
+	if v.State != string(model.ServerStateRunning) && v.State != string(model.ServerStatePaused) {
+		return 0
+	}
+
+	return v.memoryGB()
+}
+
+// ip возвращает адрес ВМ так же, как Get-VMNetworkAdapter: только для включенных ВМ
+func (v *simVM) ip() string {
+	if v.State != string(model.ServerStateRunning) {
+		return ""
+	}
+
+	return v.IP
+}
+
+func newSimServices() []WinServices {
+	return []WinServices{
+		{Name: "Spooler", DisplayName: "Print Spooler", State: "Running", User: "LocalSystem"},
+		{Name: "wuauserv", DisplayName: "Windows Update", State: "Stopped", User: "LocalSystem"},
+		{Name: "W3SVC", DisplayName: "World Wide Web Publishing Service", State: "Running",
+			User: "LocalSystem"},
+		{Name: "Apache2.4", DisplayName: "Apache2.4", State: "Stopped", User: "LocalSystem"},
+	}
+}
+
+func newSimVolumes(n int) []WinVolume {
+	return []WinVolume{
+		{Letter: "C", SpaceTotal: 100, SpaceFree: float32(50 - n*5)},
+		{Letter: "D", SpaceTotal: 500, SpaceFree: float32(300 - n*20)},
+	}
+}
+
+func newSimSessions() []WinRDPSesion {
+	return []WinRDPSesion{
+		{
+			SessionID: 2,
+			UserName:  "user1",
+			State:     "Active",
+			Processes: []WinProcess{
+				{SessionID: 2, ID: 1204, Name: "explorer", Memory: 60},
+				{SessionID: 2, ID: 3320, Name: "1cv8", Memory: 420},
+			},
+		},
+		{
+			SessionID: 3,
+			UserName:  "user2",
+			State:     "Disconnected",
+			Processes: []WinProcess{
+				{SessionID: 3, ID: 4410, Name: "explorer", Memory: 55},
+			},
+		},
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package control
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/anaxita/logit"
+)
+
+// ErrTimeout возвращается, если команда powershell не завершилась за отведенное время
+var ErrTimeout = errors.New("powershell command timed out")
+
+// Timeouts содержит время ожидания выполнения команд по типам операций
+type Timeouts struct {
+	List      time.Duration // получение списка и данных ВМ с гипервизоров
+	Power     time.Duration // управление питанием и сетью ВМ
+	Guest     time.Duration // запросы к гостевой ОС: службы, процессы, диски
+	Migrate   time.Duration // перенос ВМ на другой гипервизор
+	Provision time.Duration // создание ВМ из шаблона, включая копирование диска
+}
+
+// operation тип операции команды. От него зависят время ожидания команды и то,
+// как HostGuard учитывает команду на гипервизоре.
+type operation int
+
+const (
+	opPower     operation = iota // управление питанием и сетью ВМ
+	opList                       // получение списка и данных ВМ с гипервизоров
+	opGuest                      // запросы к гостевой ОС
+	opMigrate                    // перенос ВМ
+	opProvision                  // создание ВМ из шаблона
+)
+
+// long проверяет, что операция может выполняться часами
+func (op operation) long() bool {
+	return op == opMigrate || op == opProvision
+}
+
+// timeout возвращает время ожидания операции op
+func (t Timeouts) timeout(op operation) time.Duration {
+	switch op {
+	case opList:
+		return t.List
+	case opGuest:
+		return t.Guest
+	case opMigrate:
+		return t.Migrate
+	case opProvision:
+		return t.Provision
+	default:
+		return t.Power
+	}
+}
+
+// DefaultTimeouts возвращает время ожидания по умолчанию
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		List:      time.Minute * 2,
+		Power:     time.Minute * 5,
+		Guest:     time.Minute * 1,
+		Migrate:   time.Hour * 2,
+		Provision: time.Minute * 30,
+	}
+}
+
+// TimeoutsFromEnv возвращает время ожидания из переменных окружения
+// PWSH_TIMEOUT_LIST, PWSH_TIMEOUT_POWER, PWSH_TIMEOUT_GUEST, PWSH_TIMEOUT_MIGRATE
+// и PWSH_TIMEOUT_PROVISION (например 90s или 5m),
+// для незаданных значений используются значения по умолчанию
+func TimeoutsFromEnv() Timeouts {
+	t := DefaultTimeouts()
+
+	t.List = durationFromEnv("PWSH_TIMEOUT_LIST", t.List)
+	t.Power = durationFromEnv("PWSH_TIMEOUT_POWER", t.Power)
+	t.Guest = durationFromEnv("PWSH_TIMEOUT_GUEST", t.Guest)
+	t.Migrate = durationFromEnv("PWSH_TIMEOUT_MIGRATE", t.Migrate)
+	t.Provision = durationFromEnv("PWSH_TIMEOUT_PROVISION", t.Provision)
+
+	return t
+}
+
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		logit.Log("Неверное значение", key, v, "используем", def)
+		return def
+	}
+
+	return d
+}
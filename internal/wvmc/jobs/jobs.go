@@ -0,0 +1,256 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/anaxita/wvmc/internal/wvmc/store"
+)
+
+// Ошибки заданий
+var (
+	ErrJobFinished = errors.New("job is already finished")
+	ErrQueueFull   = errors.New("job queue is full")
+)
+
+const (
+	// DefaultWorkers количество одновременно выполняемых заданий по умолчанию
+	DefaultWorkers = 4
+
+	// queueSize сколько заданий может ждать выполнения
+	queueSize = 1000
+
+	// maxOutput сколько байт вывода команды сохраняется в задании
+	maxOutput = 64 * 1024
+
+	// keepFinished сколько хранятся завершенные задания
+	keepFinished = 30 * 24 * time.Hour
+)
+
+// Func выполняет задание и возвращает вывод команды. Выполнение прерывается отменой ctx.
+type Func func(ctx context.Context) ([]byte, error)
+
+// queued задание, которое ждет свободного обработчика
+type queued struct {
+	id int64
+	fn Func
+}
+
+// running выполняемое задание, done закрывается после сохранения результата
+type running struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// JobService выполняет задания в фоне пулом обработчиков и сохраняет их состояние в БД
+type JobService struct {
+	store   *store.Store
+	queue   chan queued
+	mu      sync.Mutex
+	running map[int64]*running
+}
+
+// WorkersFromEnv возвращает количество обработчиков заданий из переменной окружения JOB_WORKERS,
+// если она не задана или неверна - DefaultWorkers
+func WorkersFromEnv() int {
+	v := os.Getenv("JOB_WORKERS")
+	if v == "" {
+		return DefaultWorkers
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		logit.Log("Неверное значение JOB_WORKERS", v, "используем", DefaultWorkers)
+		return DefaultWorkers
+	}
+
+	return n
+}
+
+// NewJobService запускает workers обработчиков заданий. Задания, которые не завершились
+// до перезапуска, отмечаются как неудачные: их команды не сохраняются и не могут быть продолжены.
+func NewJobService(st *store.Store, workers int) *JobService {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	s := &JobService{
+		store:   st,
+		queue:   make(chan queued, queueSize),
+		running: make(map[int64]*running),
+	}
+
+	n, err := st.Job(context.Background()).FailUnfinished("wvmc was restarted before the job finished",
+		time.Now())
+	if err != nil {
+		logit.Log("Не удалось завершить прерванные задания", err)
+	}
+
+	if n > 0 {
+		logit.Info("Прерванных перезапуском заданий:", n)
+	}
+
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+
+	go s.cleanup()
+
+	return s
+}
+
+// Submit добавляет задание j в очередь и сразу возвращает его с ID, fn выполняется в фоне
+func (s *JobService) Submit(ctx context.Context, j model.Job, fn Func) (model.Job, error) {
+	j.State = model.JobQueued
+	j.CreatedAt = time.Now().UTC()
+
+	id, err := s.store.Job(ctx).Create(j)
+	if err != nil {
+		return j, err
+	}
+
+	j.ID = id
+
+	select {
+	case s.queue <- queued{id: id, fn: fn}:
+	default:
+		_, err = s.store.Job(ctx).Finish(id, model.JobFailed, "", ErrQueueFull.Error(), time.Now())
+		if err != nil {
+			logit.Log("Не удалось завершить задание", id, err)
+		}
+
+		return j, ErrQueueFull
+	}
+
+	logit.Info("Задание", id, "добавлено в очередь:", j.Kind, j.Command, j.HV, j.Server)
+
+	return j, nil
+}
+
+// Cancel отменяет задание id: задание из очереди не будет выполнено, выполнение команды прерывается.
+// Возвращает задание после отмены, если оно уже завершено - ErrJobFinished.
+func (s *JobService) Cancel(ctx context.Context, id int64) (model.Job, error) {
+	s.mu.Lock()
+	r, ok := s.running[id]
+	s.mu.Unlock()
+
+	if ok {
+		logit.Info("Прерываем задание", id)
+		r.cancel()
+
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			return model.Job{}, ctx.Err()
+		}
+	} else {
+		canceled, err := s.store.Job(ctx).Finish(id, model.JobCanceled, "", "", time.Now())
+		if err != nil {
+			return model.Job{}, err
+		}
+
+		if !canceled {
+			return model.Job{}, ErrJobFinished
+		}
+
+		logit.Info("Задание", id, "удалено из очереди")
+	}
+
+	return s.store.Job(ctx).Find(id)
+}
+
+// work выполняет задания из очереди
+func (s *JobService) work() {
+	for q := range s.queue {
+		s.run(q)
+	}
+}
+
+// run выполняет задание q, если оно не отменено, и сохраняет результат
+func (s *JobService) run(q queued) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &running{cancel: cancel, done: make(chan struct{})}
+
+	s.mu.Lock()
+	s.running[q.id] = r
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, q.id)
+		s.mu.Unlock()
+
+		close(r.done)
+	}()
+
+	st := s.store.Job(context.Background())
+
+	started, err := st.Start(q.id, time.Now())
+	if err != nil {
+		logit.Log("Не удалось запустить задание", q.id, err)
+
+		if _, err = st.Finish(q.id, model.JobFailed, "", err.Error(), time.Now()); err != nil {
+			logit.Log("Не удалось завершить задание", q.id, err)
+		}
+	}
+
+	if !started {
+		return
+	}
+
+	out, err := s.call(ctx, q.fn)
+
+	state := model.JobCompleted
+	errText := ""
+
+	switch {
+	case ctx.Err() != nil:
+		state = model.JobCanceled
+		errText = "job was canceled"
+	case err != nil:
+		state = model.JobFailed
+		errText = err.Error()
+	}
+
+	if len(out) > maxOutput {
+		out = out[:maxOutput]
+	}
+
+	logit.Info("Задание", q.id, "завершено:", state, errText)
+
+	if _, err = st.Finish(q.id, state, string(out), errText, time.Now()); err != nil {
+		logit.Log("Не удалось сохранить результат задания", q.id, err)
+	}
+}
+
+// call выполняет fn, паника в fn завершает задание с ошибкой
+func (s *JobService) call(ctx context.Context, fn Func) (out []byte, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("job panicked: %v", v)
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// cleanup раз в сутки удаляет задания, завершенные больше keepFinished назад
+func (s *JobService) cleanup() {
+	for {
+		err := s.store.Job(context.Background()).DeleteFinishedBefore(time.Now().Add(-keepFinished))
+		if err != nil {
+			logit.Log("Не удалось удалить старые задания", err)
+		}
+
+		time.Sleep(24 * time.Hour)
+	}
+}
@@ -0,0 +1,325 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/anaxita/wvmc/internal/wvmc/store"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wvmc-jobs")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = logit.New(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	logit.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestStore создает пустую БД
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "wvmc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = store.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return store.New(db)
+}
+
+// findJob возвращает задание id из БД
+func findJob(t *testing.T, st *store.Store, id int64) model.Job {
+	t.Helper()
+
+	j, err := st.Job(context.Background()).Find(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return j
+}
+
+// waitJob ждет, пока задание id перейдет в состояние state
+func waitJob(t *testing.T, st *store.Store, id int64, state string) model.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		j := findJob(t, st, id)
+		if j.State == state {
+			return j
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d is %s, want %s", id, j.State, state)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// blockingFunc задание, которое ждет закрытия release или отмены
+func blockingFunc(started chan<- struct{}, release <-chan struct{}) Func {
+	return func(ctx context.Context) ([]byte, error) {
+		close(started)
+
+		select {
+		case <-release:
+			return []byte("done"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	st := newTestStore(t)
+	s := NewJobService(st, 1)
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	first, err := s.Submit(ctx, model.Job{UserID: "1", Kind: "control", Command: "start_power"},
+		blockingFunc(started, release))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.ID == 0 || first.State != model.JobQueued || first.CreatedAt.IsZero() {
+		t.Fatalf("submitted job = %+v", first)
+	}
+
+	<-started
+
+	if j := findJob(t, st, first.ID); j.State != model.JobRunning || j.StartedAt == nil {
+		t.Fatalf("started job = %+v", j)
+	}
+
+	// единственный обработчик занят, второе задание ждет в очереди
+	second, err := s.Submit(ctx, model.Job{UserID: "1"}, func(ctx context.Context) ([]byte, error) {
+		return []byte("second"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j := findJob(t, st, second.ID); j.State != model.JobQueued || j.StartedAt != nil {
+		t.Fatalf("waiting job = %+v", j)
+	}
+
+	close(release)
+
+	j := waitJob(t, st, first.ID, model.JobCompleted)
+	if j.Output != "done" || j.Error != "" || j.FinishedAt == nil {
+		t.Errorf("completed job = %+v", j)
+	}
+
+	if j = waitJob(t, st, second.ID, model.JobCompleted); j.Output != "second" {
+		t.Errorf("second job = %+v", j)
+	}
+
+	if _, err = s.Cancel(ctx, first.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("cancel of completed job: got %v, want ErrJobFinished", err)
+	}
+}
+
+func TestJobFailed(t *testing.T) {
+	st := newTestStore(t)
+	s := NewJobService(st, 1)
+
+	failed, err := s.Submit(context.Background(), model.Job{}, func(ctx context.Context) ([]byte, error) {
+		return []byte("partial"), errors.New("command failed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j := waitJob(t, st, failed.ID, model.JobFailed); j.Error != "command failed" || j.Output != "partial" {
+		t.Errorf("failed job = %+v", j)
+	}
+
+	// паника в задании не останавливает обработчик
+	panicked, err := s.Submit(context.Background(), model.Job{}, func(ctx context.Context) ([]byte, error) {
+		panic("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j := waitJob(t, st, panicked.ID, model.JobFailed); !strings.Contains(j.Error, "job panicked: boom") {
+		t.Errorf("panicked job = %+v", j)
+	}
+
+	long, err := s.Submit(context.Background(), model.Job{}, func(ctx context.Context) ([]byte, error) {
+		return []byte(strings.Repeat("x", maxOutput+10)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j := waitJob(t, st, long.ID, model.JobCompleted); len(j.Output) != maxOutput {
+		t.Errorf("output length = %d, want %d", len(j.Output), maxOutput)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	st := newTestStore(t)
+	s := NewJobService(st, 1)
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	running, err := s.Submit(ctx, model.Job{}, blockingFunc(started, make(chan struct{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+
+	called := make(chan struct{}, 1)
+	queued, err := s.Submit(ctx, model.Job{}, func(ctx context.Context) ([]byte, error) {
+		called <- struct{}{}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// задание из очереди отменяется сразу и не выполняется
+	j, err := s.Cancel(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if j.State != model.JobCanceled || j.StartedAt != nil || j.FinishedAt == nil {
+		t.Errorf("canceled queued job = %+v", j)
+	}
+
+	// выполняемое задание прерывается, Cancel ждет сохранения результата
+	if j, err = s.Cancel(ctx, running.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if j.State != model.JobCanceled || j.Error != "job was canceled" || j.StartedAt == nil {
+		t.Errorf("canceled running job = %+v", j)
+	}
+
+	// обработчик освободился и пропустил отмененное задание
+	next, err := s.Submit(ctx, model.Job{}, func(ctx context.Context) ([]byte, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitJob(t, st, next.ID, model.JobCompleted)
+
+	select {
+	case <-called:
+		t.Errorf("canceled queued job was executed")
+	default:
+	}
+
+	if j = findJob(t, st, queued.ID); j.State != model.JobCanceled {
+		t.Errorf("canceled queued job after its turn = %+v", j)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	st := newTestStore(t)
+
+	// обработчики не запущены, в очереди помещается одно задание
+	s := &JobService{store: st, queue: make(chan queued, 1), running: make(map[int64]*running)}
+	noop := func(ctx context.Context) ([]byte, error) { return nil, nil }
+
+	if _, err := s.Submit(context.Background(), model.Job{}, noop); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := s.Submit(context.Background(), model.Job{}, noop)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("submit to full queue: got %v, want ErrQueueFull", err)
+	}
+
+	if j = findJob(t, st, j.ID); j.State != model.JobFailed || j.Error != ErrQueueFull.Error() {
+		t.Errorf("rejected job = %+v", j)
+	}
+}
+
+func TestFailUnfinishedAtStart(t *testing.T) {
+	st := newTestStore(t)
+	repo := st.Job(context.Background())
+	now := time.Now()
+
+	ids := make(map[string]int64)
+	for _, state := range []string{model.JobQueued, model.JobRunning, model.JobCompleted} {
+		id, err := repo.Create(model.Job{State: model.JobQueued, CreatedAt: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids[state] = id
+	}
+
+	if _, err := repo.Start(ids[model.JobRunning], now); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Start(ids[model.JobCompleted], now); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Finish(ids[model.JobCompleted], model.JobCompleted, "ok", "", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// задания, прерванные перезапуском, завершаются при создании сервиса
+	NewJobService(st, 1)
+
+	for _, state := range []string{model.JobQueued, model.JobRunning} {
+		if j := findJob(t, st, ids[state]); j.State != model.JobFailed || !strings.Contains(j.Error, "restarted") ||
+			j.FinishedAt == nil {
+			t.Errorf("%s job after restart = %+v", state, j)
+		}
+	}
+
+	if j := findJob(t, st, ids[model.JobCompleted]); j.State != model.JobCompleted || j.Output != "ok" {
+		t.Errorf("completed job after restart = %+v", j)
+	}
+}
+
+func TestWorkersFromEnv(t *testing.T) {
+	tests := map[string]int{"": DefaultWorkers, "8": 8, "0": DefaultWorkers, "-1": DefaultWorkers,
+		"x": DefaultWorkers}
+
+	for v, want := range tests {
+		t.Setenv("JOB_WORKERS", v)
+
+		if got := WorkersFromEnv(); got != want {
+			t.Errorf("WorkersFromEnv() with %q = %d, want %d", v, got, want)
+		}
+	}
+}
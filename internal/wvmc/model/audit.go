@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// AuditRecord запись журнала изменений серверов
+type AuditRecord struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    string    `json:"user_id"`
+	UserEmail string    `json:"user_email"`
+	HV        string    `json:"hv"`
+	Server    string    `json:"server"`
+	Action    string    `json:"action"`
+	Details   string    `json:"details"`
+}
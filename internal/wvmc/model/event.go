@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// Типы событий изменения ВМ
+const (
+	EventVMAppeared          = "vm_appeared"
+	EventVMDisappeared       = "vm_disappeared"
+	EventStateChanged        = "state_changed"
+	EventUnexpectedPowerOff  = "unexpected_power_off"
+	EventNetworkConnected    = "network_connected"
+	EventNetworkDisconnected = "network_disconnected"
+	EventNetworkChanged      = "network_changed"
+	EventIPChanged           = "ip_changed"
+	EventStatusChanged       = "status_changed"
+)
+
+// Источники событий: обновление данных с гипервизора или команда, выполненная через wvmc
+const (
+	EventSourceRefresh = "refresh"
+	EventSourceCommand = "command"
+)
+
+// Event изменение ВМ, найденное при сравнении данных кеша до и после изменения
+type Event struct {
+	ID        int64     `json:"id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Type      string    `json:"type"`
+	Source    string    `json:"source"`
+	HV        string    `json:"hv"`
+	VMID      string    `json:"vmid"`
+	Server    string    `json:"server"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+}
+
+// EventFilter условия поиска событий, пустые поля не учитываются.
+// Если VMIDs не nil, возвращаются только события этих ВМ.
+type EventFilter struct {
+	HV       string
+	Server   string
+	VMID     string
+	VMIDs    []string
+	Types    []string
+	From     time.Time
+	To       time.Time
+	BeforeID int64
+	Limit    int
+}
@@ -0,0 +1,62 @@
+package model
+
+import "time"
+
+// Состояния данных гипервизора
+const (
+	HostStatusOK          = "ok"
+	HostStatusUnreachable = "unreachable"
+	HostStatusDisabled    = "disabled"
+)
+
+// Hypervisor гипервизор из таблицы hypervs. Выключенные гипервизоры не опрашиваются,
+// команды на них не выполняются. Если задан User, команды выполняются с этой учетной записью.
+// Password хранится в таблице hypervs в открытом виде и никогда не отправляется в ответах API.
+type Hypervisor struct {
+	Name     string `json:"name"`
+	IP       string `json:"ip4"`
+	Enabled  bool   `json:"enabled"`
+	User     string `json:"user"`
+	Password string `json:"-"`
+}
+
+// HostInventory ресурсы гипервизора, память в МБ, место на томах в ГБ
+type HostInventory struct {
+	Name              string        `json:"name"`
+	Status            string        `json:"status"`
+	Error             string        `json:"error,omitempty"`
+	OS                string        `json:"os"`
+	HypervVersion     string        `json:"hyperv_version"`
+	LogicalProcessors int           `json:"logical_processors"`
+	VirtualProcessors int           `json:"virtual_processors"`
+	MemoryTotal       int64         `json:"memory_total"`
+	MemoryFree        int64         `json:"memory_free"`
+	MemoryAssigned    int64         `json:"memory_assigned"`
+	Storage           []HostStorage `json:"storage"`
+	VMs               HostVMCounts  `json:"vms"`
+	UpdatedAt         time.Time     `json:"updated_at"`
+}
+
+// HostStorage том гипервизора, на котором находятся ВМ
+type HostStorage struct {
+	Path       string  `json:"path"`
+	SpaceTotal float64 `json:"space_total"`
+	SpaceFree  float64 `json:"space_free"`
+}
+
+// HostVMCounts количество ВМ гипервизора по состояниям
+type HostVMCounts struct {
+	Total   int `json:"total"`
+	Running int `json:"running"`
+	Off     int `json:"off"`
+	Saved   int `json:"saved"`
+	Paused  int `json:"paused"`
+	Other   int `json:"other"`
+}
+
+// HostSnapshot ВМ гипервизора из кеша на момент последнего успешного обновления
+type HostSnapshot struct {
+	HV        string
+	UpdatedAt time.Time
+	Servers   []Server
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// Состояния задания
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// Job команда, которая выполняется в фоне. Output - вывод команды, Error - текст ошибки.
+type Job struct {
+	ID         int64      `json:"id"`
+	UserID     string     `json:"user_id"`
+	UserEmail  string     `json:"user_email"`
+	Kind       string     `json:"kind"`
+	Command    string     `json:"command"`
+	HV         string     `json:"hv"`
+	Server     string     `json:"server"`
+	State      string     `json:"state"`
+	Output     string     `json:"output"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished проверяет, завершено ли задание
+func (j Job) Finished() bool {
+	return j.State == JobCompleted || j.State == JobFailed || j.State == JobCanceled
+}
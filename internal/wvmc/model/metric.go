@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// MetricPoint средние показатели ВМ за шаг, начинающийся в Time.
+// CPU - загрузка процессора в процентах, Memory - выделенная память в ГБ,
+// Running - доля времени, когда ВМ работала, от 0 до 1, Samples - количество замеров.
+type MetricPoint struct {
+	Time    time.Time `json:"time"`
+	CPU     float64   `json:"cpu"`
+	Memory  float64   `json:"memory"`
+	Running float64   `json:"running"`
+	Samples int64     `json:"samples"`
+}
@@ -0,0 +1,50 @@
+package model
+
+import "time"
+
+// Что делать с выполнением расписания, пропущенным, пока wvmc не работал
+const (
+	// ScheduleMissedSkip пропустить выполнение и ждать следующего
+	ScheduleMissedSkip = "skip"
+
+	// ScheduleMissedRunOnce выполнить команду один раз после запуска, сколько бы выполнений ни было пропущено
+	ScheduleMissedRunOnce = "run_once"
+)
+
+// Состояния выполнения расписания, кроме состояний его задания
+const (
+	ScheduleRunSkipped = "skipped"
+	ScheduleRunFailed  = "failed"
+)
+
+// Schedule команда управления сервером, которая выполняется по выражению cron в часовом поясе Timezone
+type Schedule struct {
+	ID        int64      `json:"id"`
+	UserID    string     `json:"user_id"`
+	UserEmail string     `json:"user_email"`
+	ServerID  int64      `json:"server_id"`
+	HV        string     `json:"hv"`
+	Server    string     `json:"server"`
+	Command   string     `json:"command"`
+	Adapter   string     `json:"adapter,omitempty"`
+	Cron      string     `json:"cron"`
+	Timezone  string     `json:"timezone"`
+	Missed    string     `json:"missed"`
+	Enabled   bool       `json:"enabled"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ScheduleRun выполнение расписания. State - состояние задания JobID,
+// если задание не создано - skipped или failed с текстом ошибки Error.
+type ScheduleRun struct {
+	ID          int64     `json:"id"`
+	ScheduleID  int64     `json:"schedule_id"`
+	JobID       int64     `json:"job_id,omitempty"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	State       string    `json:"state"`
+	Error       string    `json:"error,omitempty"`
+}
@@ -3,30 +3,35 @@ package model
 type ServerState string
 
 const (
-	ServerStateRunning   ServerState = "Running"
-	ServerStateStopped   ServerState = "Off"
-	ServerNetworkRunning ServerState = "LAN - Virtual Switch"
-	ServerNetworkStopped ServerState = ""
+	ServerStateRunning ServerState = "Running"
+	ServerStateStopped ServerState = "Off"
+	ServerStateSaved   ServerState = "Saved"
+	ServerStatePaused  ServerState = "Paused"
 )
 
+// ServerStatusHVUnreachable статус ВМ, гипервизор которой не отвечает
+const ServerStatusHVUnreachable = "hypervisor unreachable"
+
 // Server содержит модель сервера из БД
 type Server struct {
-	ID          int64   `json:"id"`
-	VMID        string  `json:"vmid"`
-	Name        string  `json:"name"`
-	HV          string  `json:"hv"`
-	IP          string  `json:"ip"`
-	OutAddr     string  `json:"out_addr"`
-	Company     string  `json:"company"`
-	Description string  `json:"description"`
-	Memory      float64 `json:"memory"`
-	Weight      int     `json:"weight"`
-	State       string  `json:"state"`
-	Status      string  `json:"status"`
-	CpuLoad     int     `json:"cpu_load"`
-	CpuCores    int     `json:"cpu_cores"`
-	Network     string  `json:"network"`
-	Backup      string  `json:"backup"`
-	User        string  `json:"user"`
-	Password    string  `json:"password,omitempty"`
+	ID             int64   `json:"id"`
+	VMID           string  `json:"vmid"`
+	Name           string  `json:"name"`
+	HV             string  `json:"hv"`
+	IP             string  `json:"ip"`
+	OutAddr        string  `json:"out_addr"`
+	Company        string  `json:"company"`
+	Description    string  `json:"description"`
+	Memory         float64 `json:"memory"`
+	MemoryAssigned float64 `json:"memory_assigned"`
+	Weight         int     `json:"weight"`
+	State          string  `json:"state"`
+	Status         string  `json:"status"`
+	CpuLoad        int     `json:"cpu_load"`
+	CpuCores       int     `json:"cpu_cores"`
+	Network        string  `json:"network"`
+	Switch         string  `json:"switch_name"`
+	Backup         string  `json:"backup"`
+	User           string  `json:"user"`
+	Password       string  `json:"password,omitempty"`
 }
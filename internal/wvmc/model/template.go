@@ -0,0 +1,21 @@
+package model
+
+// Способы создания диска ВМ из шаблона
+const (
+	TemplateDiskDifferencing = "differencing" // разностный диск от базового VHDX
+	TemplateDiskCopy         = "copy"         // копия базового VHDX
+)
+
+// Template шаблон для создания ВМ, память в МБ
+type Template struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	HV          string `json:"hv"`
+	VHDXPath    string `json:"vhdx_path"`
+	DiskType    string `json:"disk_type"`
+	Generation  int    `json:"generation"`
+	CPUCores    int    `json:"cpu_cores"`
+	Memory      int64  `json:"memory"`
+	Switch      string `json:"switch_name"`
+	StoragePath string `json:"storage_path"`
+}
@@ -5,11 +5,17 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/prom"
 	"net/http"
 )
 
 const botUrl = "http://localhost:8085"
 
+var (
+	notifySent     = prom.NewCounterVec("wvmc_notify_sent_total", "Notifications sent to the bot.")
+	notifyFailures = prom.NewCounterVec("wvmc_notify_failures_total", "Notifications the bot failed to deliver.")
+)
+
 type KMSBOT struct {
 }
 
@@ -36,12 +42,17 @@ func (s *KMSBOT) Notify(text string) error {
 
 	body := bytes.NewReader(b)
 
+	notifySent.Inc()
+
 	w, err := http.Post(botUrl+"/send", "application/json", body)
 	if err != nil {
+		notifyFailures.Inc()
 		return err
 	}
+	defer w.Body.Close()
 
 	if w.StatusCode != http.StatusOK {
+		notifyFailures.Inc()
 		return errors.New("error send message")
 	}
 
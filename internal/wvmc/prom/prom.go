@@ -0,0 +1,326 @@
+package prom
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType тип ответа в текстовом формате Prometheus
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Границы гистограмм по умолчанию в секундах: для HTTP запросов и для долгих команд
+var (
+	DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	SlowBuckets    = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+)
+
+// Default набор метрик wvmc, который отдается в /metrics
+var Default = NewRegistry()
+
+// family метрика с одинаковым именем и разными значениями меток
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry набор метрик и функций, которые собирают значения метрик при запросе
+type Registry struct {
+	mu         sync.Mutex
+	families   []family
+	collectors []func() []*GaugeSet
+}
+
+// NewRegistry создает пустой набор метрик
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Collect добавляет функцию, которая возвращает значения метрик на момент запроса
+func (r *Registry) Collect(fn func() []*GaugeSet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, fn)
+}
+
+// Write записывает все метрики в текстовом формате Prometheus
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	collectors := append([]func() []*GaugeSet(nil), r.collectors...)
+	r.mu.Unlock()
+
+	for _, fn := range collectors {
+		for _, g := range fn() {
+			families = append(families, g)
+		}
+	}
+
+	w := bufio.NewWriter(out)
+
+	for _, f := range families {
+		f.write(w)
+	}
+
+	return w.Flush()
+}
+
+// add добавляет метрику в набор
+func (r *Registry) add(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.families = append(r.families, f)
+}
+
+// CounterVec счетчик с метками, который только увеличивается
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec создает счетчик с метками labels в наборе Default
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec создает счетчик с метками labels в наборе r.
+// Счетчик без меток отдается сразу со значением 0.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	if len(labels) == 0 {
+		c.values[""] = &counterValue{}
+	}
+
+	r.add(c)
+
+	return c
+}
+
+// Inc увеличивает на 1 значение счетчика с метками labelValues
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add увеличивает на v значение счетчика с метками labelValues
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[key] = cv
+	}
+
+	cv.value += v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+
+	// порядок значений не меняется между запросами
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		cv := c.values[key]
+		writeSample(w, c.name, c.labels, cv.labels, "", "", cv.value)
+	}
+}
+
+// HistogramVec гистограмма с метками: количество значений не больше каждой границы, их сумма и количество
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec создает гистограмму с границами buckets и метками labels в наборе Default
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogramVec создает гистограмму с границами buckets и метками labels в наборе r
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets,
+		values: make(map[string]*histogramValue)}
+	r.add(h)
+
+	return h
+}
+
+// Observe добавляет значение v в гистограмму с метками labelValues
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = hv
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+
+	hv.sum += v
+	hv.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hv := h.values[key]
+
+		for i, upper := range h.buckets {
+			writeSample(w, h.name+"_bucket", h.labels, hv.labels, "le", formatFloat(upper),
+				float64(hv.counts[i]))
+		}
+
+		writeSample(w, h.name+"_bucket", h.labels, hv.labels, "le", "+Inf", float64(hv.count))
+		writeSample(w, h.name+"_sum", h.labels, hv.labels, "", "", hv.sum)
+		writeSample(w, h.name+"_count", h.labels, hv.labels, "", "", float64(hv.count))
+	}
+}
+
+// GaugeSet значения метрики-gauge с метками, собранные на момент запроса
+type GaugeSet struct {
+	name, help string
+	labels     []string
+	samples    []gaugeSample
+}
+
+type gaugeSample struct {
+	labels []string
+	value  float64
+}
+
+// NewGaugeSet создает пустой набор значений метрики-gauge с метками labels
+func NewGaugeSet(name, help string, labels ...string) *GaugeSet {
+	return &GaugeSet{name: name, help: help, labels: labels}
+}
+
+// Set добавляет значение v с метками labelValues
+func (g *GaugeSet) Set(v float64, labelValues ...string) {
+	g.samples = append(g.samples, gaugeSample{labels: labelValues, value: v})
+}
+
+func (g *GaugeSet) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+
+	for _, s := range g.samples {
+		writeSample(w, g.name, g.labels, s.labels, "", "", s.value)
+	}
+}
+
+// writeHeader записывает описание и тип метрики
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+
+	w.WriteString("# HELP " + name + " " + help + "\n")
+	w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// writeSample записывает значение v метрики name с метками names и значениями values,
+// extraName и extraValue - дополнительная метка, например le у гистограммы
+func writeSample(w *bufio.Writer, name string, names, values []string, extraName, extraValue string,
+	v float64) {
+	w.WriteString(name)
+
+	pairs := make([]string, 0, len(names)+1)
+
+	for i, n := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+
+		pairs = append(pairs, n+`="`+escapeLabel(value)+`"`)
+	}
+
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+escapeLabel(extraValue)+`"`)
+	}
+
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+// labelEscaper экранирует значения меток
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// seriesKey ключ значений меток
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
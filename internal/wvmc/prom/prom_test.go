@@ -0,0 +1,134 @@
+package prom
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String()
+}
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+
+	total := r.NewCounterVec("test_total", "Total things.")
+	byCode := r.NewCounterVec("test_requests_total", "Requests by code.", "route", "code")
+
+	byCode.Inc("/b", "200")
+	byCode.Inc("/a", "500")
+	byCode.Add(2.5, "/a", "500")
+
+	want := `# HELP test_total Total things.
+# TYPE test_total counter
+test_total 0
+# HELP test_requests_total Requests by code.
+# TYPE test_requests_total counter
+test_requests_total{route="/a",code="500"} 3.5
+test_requests_total{route="/b",code="200"} 1
+`
+
+	if got := write(t, r); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+
+	total.Inc()
+
+	if got := write(t, r); !strings.Contains(got, "\ntest_total 1\n") {
+		t.Errorf("counter without labels was not increased:\n%s", got)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounterVec("test_total", "Help with \\ and\nnew line.", "name")
+	c.Inc(`say "hi" \ bye` + "\n")
+
+	want := `# HELP test_total Help with \\ and\nnew line.
+# TYPE test_total counter
+test_total{name="say \"hi\" \\ bye\n"} 1
+`
+
+	if got := write(t, r); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+
+	h := r.NewHistogramVec("test_seconds", "Durations.", []float64{0.1, 1, 10}, "op")
+
+	// значение на границе попадает в эту границу, значения накапливаются по возрастанию границ
+	for _, v := range []float64{0.05, 0.1, 0.5, 5, 50} {
+		h.Observe(v, "run")
+	}
+
+	want := `# HELP test_seconds Durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="run",le="0.1"} 2
+test_seconds_bucket{op="run",le="1"} 3
+test_seconds_bucket{op="run",le="10"} 4
+test_seconds_bucket{op="run",le="+Inf"} 5
+test_seconds_sum{op="run"} 55.65
+test_seconds_count{op="run"} 5
+`
+
+	if got := write(t, r); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeCollector(t *testing.T) {
+	r := NewRegistry()
+
+	calls := 0
+	r.Collect(func() []*GaugeSet {
+		calls++
+
+		g := NewGaugeSet("test_vms", "VMs by state.", "hv", "state")
+		g.Set(3, "hv1", "running")
+		g.Set(float64(calls), "hv1", "off")
+
+		return []*GaugeSet{g}
+	})
+
+	write(t, r)
+
+	// значения собираются при каждом запросе
+	want := `# HELP test_vms VMs by state.
+# TYPE test_vms gauge
+test_vms{hv="hv1",state="running"} 3
+test_vms{hv="hv1",state="off"} 2
+`
+
+	if got := write(t, r); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := map[float64]string{
+		0:            "0",
+		1.5:          "1.5",
+		1e-7:         "1e-07",
+		math.Inf(1):  "+Inf",
+		math.Inf(-1): "-Inf",
+		math.NaN():   "NaN",
+	}
+
+	for v, want := range tests {
+		if got := formatFloat(v); got != want {
+			t.Errorf("formatFloat(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField допустимые значения и имена одного поля выражения cron
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	// 7 тоже воскресенье
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// cronMacros сокращения часто используемых выражений
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// maxCronSteps сколько раз Next сдвигает время, прежде чем решить, что выражение никогда не выполняется
+const maxCronSteps = 10000
+
+// Cron разобранное выражение cron: минута, час, день месяца, месяц и день недели
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny и dowAny - день месяца и день недели начинаются с *
+	domAny, dowAny bool
+}
+
+// ParseCron разбирает выражение cron из пяти полей, например "0 9 * * mon-fri".
+// Поля поддерживают списки через запятую, диапазоны, шаги (*/15, 8-18/2) и имена месяцев и дней недели,
+// а также сокращения @hourly, @daily, @weekly, @monthly и @yearly.
+func ParseCron(spec string) (*Cron, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression must have %d fields, got %d", len(cronFields), len(parts))
+	}
+
+	bits := make([]uint64, len(parts))
+
+	for i, part := range parts {
+		var err error
+		if bits[i], err = parseCronField(part, cronFields[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Cron{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(parts[2], "*"),
+		dowAny: strings.HasPrefix(parts[4], "*"),
+	}
+
+	// воскресенье хранится как 0
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+// parseCronField разбирает одно поле выражения в битовую маску значений
+func parseCronField(part string, f cronField) (uint64, error) {
+	var bits uint64
+
+	for _, item := range strings.Split(part, ",") {
+		rng, step := item, 1
+
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", item[i+1:], f.name)
+			}
+
+			rng = item[:i]
+		}
+
+		lo, hi := f.min, f.max
+
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+
+			var err error
+			if lo, err = cronValue(bounds[0], f); err != nil {
+				return 0, err
+			}
+
+			if hi, err = cronValue(bounds[1], f); err != nil {
+				return 0, err
+			}
+
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rng, f.name)
+			}
+		default:
+			v, err := cronValue(rng, f)
+			if err != nil {
+				return 0, err
+			}
+
+			// 5/15 означает с 5 до конца через 15
+			lo, hi = v, v
+			if step > 1 {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// cronValue возвращает числовое значение или имя из поля f
+func cronValue(s string, f cronField) (int, error) {
+	if v, ok := f.names[s]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, must be %d-%d", s, f.name, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// Next возвращает первое время выполнения позже after в часовом поясе after.
+// Время, которого нет из-за перехода на летнее время, и час, который повторяется при переходе
+// на зимнее время, пропускаются, чтобы команда не выполнилась дважды.
+// Если выражение никогда не выполняется (например 30 февраля), возвращает нулевое время.
+func (c *Cron) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := advance(after, after.Truncate(time.Minute).Add(time.Minute))
+
+	for i := 0; i < maxCronSteps; i++ {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = advance(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+		case !c.dayMatches(t):
+			t = advance(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = advance(t, t.Add(time.Duration(60-t.Minute())*time.Minute))
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = advance(t, t.Add(time.Minute))
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// advance возвращает next, если его время на часах позже t. Иначе next попал в час, который
+// повторяется при переходе на зимнее время, или в пропущенное время, и возвращается начало
+// следующего часа после t.
+func advance(t, next time.Time) time.Time {
+	if wall(next).After(wall(t)) {
+		return next
+	}
+
+	hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+	if wall(hour).After(wall(t)) {
+		return hour
+	}
+
+	return t.Add(time.Hour)
+}
+
+// wall возвращает время на часах t без учета часового пояса
+func wall(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// dayMatches проверяет день месяца и день недели. Как в cron, если заданы оба поля,
+// достаточно совпадения одного из них.
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
@@ -0,0 +1,169 @@
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+const cronLayout = "2006-01-02 15:04 -0700"
+
+func location(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loc
+}
+
+func TestParseCron(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"0 9 * * mon-fri",
+		"*/15 8-18 * * *",
+		"0,30 8-18/2 1,15 jan,jul sun",
+		"5/20 * * * 7",
+		"  0 0 1 1 *  ",
+		"@daily",
+		"@Weekly",
+	}
+
+	for _, spec := range valid {
+		if _, err := ParseCron(spec); err != nil {
+			t.Errorf("ParseCron(%q) = %v", spec, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+		"1,,2 * * * *",
+		"* * * foo *",
+		"* * * * monday",
+		"@reboot",
+	}
+
+	for _, spec := range invalid {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestCronFields(t *testing.T) {
+	c, err := ParseCron("0,30 8-18/2 1,15 jan,jul sun")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Cron{
+		minute: 1<<0 | 1<<30,
+		hour:   1<<8 | 1<<10 | 1<<12 | 1<<14 | 1<<16 | 1<<18,
+		dom:    1<<1 | 1<<15,
+		month:  1<<1 | 1<<7,
+		dow:    1 << 0,
+	}
+
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("ParseCron() = %+v, want %+v", c, want)
+	}
+
+	// 7 и 0 - воскресенье
+	seven, _ := ParseCron("0 0 * * 7")
+	zero, _ := ParseCron("0 0 * * 0")
+
+	if seven.dow&zero.dow == 0 {
+		t.Errorf("day of week 7 = %b, want sunday", seven.dow)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	msk := location(t, "Europe/Moscow")
+	ny := location(t, "America/New_York")
+	saoPaulo := location(t, "America/Sao_Paulo")
+
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  []string
+	}{
+		{"weekdays", "0 9 * * mon-fri", time.Date(2026, 10, 16, 9, 0, 0, 0, msk),
+			[]string{"2026-10-19 09:00 +0300", "2026-10-20 09:00 +0300"}},
+		{"step within hours", "*/15 8-18 * * *", time.Date(2026, 10, 16, 18, 50, 0, 0, msk),
+			[]string{"2026-10-17 08:00 +0300", "2026-10-17 08:15 +0300"}},
+		{"seconds are truncated", "* * * * *", time.Date(2026, 10, 16, 9, 0, 59, 0, msk),
+			[]string{"2026-10-16 09:01 +0300", "2026-10-16 09:02 +0300"}},
+		{"step from value", "5/20 * * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-10-18 00:05 +0000", "2026-10-18 00:25 +0000", "2026-10-18 00:45 +0000",
+				"2026-10-18 01:05 +0000"}},
+		{"weekly", "@weekly", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-10-25 00:00 +0000", "2026-11-01 00:00 +0000"}},
+		// заданы и день месяца, и день недели - достаточно совпадения одного из них
+		{"day of month or week", "0 12 1 * 1", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-10-19 12:00 +0000", "2026-10-26 12:00 +0000", "2026-11-01 12:00 +0000",
+				"2026-11-02 12:00 +0000"}},
+		{"leap day", "0 0 29 2 *", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			[]string{"2028-02-29 00:00 +0000", "2032-02-29 00:00 +0000"}},
+		// 8 марта 2:30 в Нью-Йорке нет, часы переводятся с 2:00 на 3:00
+		{"skipped by spring forward", "30 2 * * *", time.Date(2026, 3, 7, 12, 0, 0, 0, ny),
+			[]string{"2026-03-09 02:30 -0400", "2026-03-10 02:30 -0400"}},
+		// 1 ноября час с 1:00 до 2:00 повторяется, второй раз команда не выполняется
+		{"repeated by fall back", "30 1 * * *", time.Date(2026, 11, 1, 1, 30, 0, 0, ny),
+			[]string{"2026-11-02 01:30 -0500"}},
+		{"steps across fall back", "*/30 * * * *", time.Date(2026, 11, 1, 1, 10, 0, 0, ny),
+			[]string{"2026-11-01 01:30 -0400", "2026-11-01 02:00 -0500", "2026-11-01 02:30 -0500"}},
+		// в Сан-Паулу 4 ноября 2018 года полуночи не было, часы переводились с 0:00 на 1:00
+		{"skipped midnight", "@daily", time.Date(2018, 11, 3, 12, 0, 0, 0, saoPaulo),
+			[]string{"2018-11-05 00:00 -0200", "2018-11-06 00:00 -0200"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0, len(tt.want))
+			next := tt.after
+
+			for range tt.want {
+				next = c.Next(next)
+				got = append(got, next.Format(cronLayout))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Next() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	for _, spec := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		c, err := ParseCron(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if next := c.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+			t.Errorf("%q: Next() = %v, want zero time", spec, next)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	// часовые пояса встроены в программу, на Windows их базы может не быть
+	_ "time/tzdata"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/anaxita/wvmc/internal/wvmc/store"
+)
+
+// ErrNeverRuns выражение cron не выполняется никогда, например 30 февраля
+var ErrNeverRuns = errors.New("cron expression never runs")
+
+const (
+	// DefaultTimezone часовой пояс расписаний по умолчанию - часовой пояс сервера wvmc
+	DefaultTimezone = "Local"
+
+	// checkInterval как часто проверяются расписания, время которых наступило
+	checkInterval = 15 * time.Second
+
+	// missedGrace насколько выполнение может опоздать, чтобы не считаться пропущенным
+	missedGrace = 5 * time.Minute
+
+	// keepRuns сколько хранится история выполнений
+	keepRuns = 30 * 24 * time.Hour
+)
+
+// Runner запускает команду расписания s и возвращает ID ее задания
+type Runner func(ctx context.Context, s model.Schedule) (int64, error)
+
+// Scheduler выполняет команды расписаний из БД, когда наступает их время
+type Scheduler struct {
+	store *store.Store
+}
+
+// NewScheduler создает планировщик расписаний из БД st
+func NewScheduler(st *store.Store) *Scheduler {
+	return &Scheduler{store: st}
+}
+
+// Start запускает проверку расписаний в фоне, команды выполняются через run
+func (s *Scheduler) Start(run Runner) {
+	go func() {
+		var cleaned time.Time
+
+		for {
+			s.runDue(run, time.Now())
+
+			if time.Since(cleaned) > 24*time.Hour {
+				cleaned = time.Now()
+
+				err := s.store.Schedule(context.Background()).DeleteRunsBefore(cleaned.Add(-keepRuns))
+				if err != nil {
+					logit.Log("Не удалось удалить старые выполнения расписаний", err)
+				}
+			}
+
+			time.Sleep(checkInterval)
+		}
+	}()
+}
+
+// runDue выполняет расписания, время которых наступило к now, и задает их следующее выполнение.
+// Выполнение, которое опоздало больше чем на missedGrace, потому что wvmc не работал,
+// выполняется один раз только с правилом run_once, иначе пропускается.
+func (s *Scheduler) runDue(run Runner, now time.Time) {
+	st := s.store.Schedule(context.Background())
+
+	due, err := st.FindDue(now)
+	if err != nil {
+		logit.Log("Не удалось получить расписания", err)
+		return
+	}
+
+	for _, sc := range due {
+		r := model.ScheduleRun{ScheduleID: sc.ID, ScheduledAt: *sc.NextRunAt}
+
+		var lastRun *time.Time
+
+		if now.Sub(*sc.NextRunAt) > missedGrace && sc.Missed != model.ScheduleMissedRunOnce {
+			logit.Info("Пропускаем выполнение расписания", sc.ID, "на", sc.NextRunAt)
+
+			r.State = model.ScheduleRunSkipped
+			r.Error = "wvmc was not running at the scheduled time"
+		} else {
+			logit.Info("Выполняем расписание", sc.ID, sc.Command, sc.HV, sc.Server)
+
+			lastRun = &now
+
+			if r.JobID, err = run(context.Background(), sc); err != nil {
+				logit.Log("Не удалось выполнить расписание", sc.ID, err)
+
+				r.State = model.ScheduleRunFailed
+				r.Error = err.Error()
+			} else {
+				r.State = model.JobQueued
+			}
+		}
+
+		if err = st.CreateRun(r); err != nil {
+			logit.Log("Не удалось сохранить выполнение расписания", sc.ID, err)
+		}
+
+		var next *time.Time
+
+		if t, err := NextRun(sc.Cron, sc.Timezone, now); err != nil {
+			logit.Log("Расписание", sc.ID, "больше не выполняется", err)
+		} else {
+			next = &t
+		}
+
+		if err = st.SetNextRun(sc.ID, next, lastRun); err != nil {
+			logit.Log("Не удалось сохранить следующее выполнение расписания", sc.ID, err)
+		}
+	}
+}
+
+// NextRun возвращает первое выполнение выражения cron в часовом поясе timezone позже after
+func NextRun(cron, timezone string, after time.Time) (time.Time, error) {
+	c, err := ParseCron(cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := c.Next(after.In(loc))
+	if next.IsZero() {
+		return next, ErrNeverRuns
+	}
+
+	return next, nil
+}
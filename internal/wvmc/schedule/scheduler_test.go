@@ -0,0 +1,230 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/anaxita/wvmc/internal/wvmc/store"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wvmc-schedule")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = logit.New(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	logit.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestStore создает пустую БД
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "wvmc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = store.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return store.New(db)
+}
+
+// fakeRunner запоминает выполненные расписания и возвращает ID заданий по порядку
+type fakeRunner struct {
+	calls []int64
+	fail  map[int64]error
+}
+
+func (f *fakeRunner) run(ctx context.Context, sc model.Schedule) (int64, error) {
+	f.calls = append(f.calls, sc.ID)
+
+	if err := f.fail[sc.ID]; err != nil {
+		return 0, err
+	}
+
+	return int64(100 + len(f.calls)), nil
+}
+
+func TestRunDue(t *testing.T) {
+	st := newTestStore(t)
+	repo := st.Schedule(context.Background())
+
+	now := time.Date(2026, 10, 18, 12, 7, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	create := func(cron, missed string, enabled bool, next *time.Time) int64 {
+		t.Helper()
+
+		id, err := repo.Create(model.Schedule{UserID: "1", ServerID: 1, Command: "start_power", Cron: cron,
+			Timezone: "UTC", Missed: missed, Enabled: enabled, NextRunAt: next})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return id
+	}
+
+	hourly := "0 * * * *"
+
+	onTime := create(hourly, model.ScheduleMissedSkip, true, at(-3*time.Minute))
+	missed := create(hourly, model.ScheduleMissedSkip, true, at(-3*time.Hour))
+	runOnce := create(hourly, model.ScheduleMissedRunOnce, true, at(-3*time.Hour))
+	failing := create(hourly, model.ScheduleMissedSkip, true, at(-time.Minute))
+	never := create("0 0 30 2 *", model.ScheduleMissedSkip, true, at(-time.Minute))
+	disabled := create(hourly, model.ScheduleMissedSkip, false, at(-time.Minute))
+	future := create(hourly, model.ScheduleMissedSkip, true, at(time.Minute))
+
+	runner := &fakeRunner{fail: map[int64]error{failing: errors.New("no access")}}
+	s := NewScheduler(st)
+
+	s.runDue(runner.run, now)
+
+	// выполнение вовремя, с run_once и с ошибкой; пропущенное с правилом skip не выполняется.
+	// run_once выполняется один раз, хотя пропущено три выполнения.
+	called := make(map[int64]int)
+	for _, id := range runner.calls {
+		called[id]++
+	}
+
+	want := map[int64]int{onTime: 1, runOnce: 1, failing: 1, never: 1}
+	if len(called) != len(want) {
+		t.Fatalf("called = %v, want %v", called, want)
+	}
+
+	for id, n := range want {
+		if called[id] != n {
+			t.Errorf("schedule %d called %d times, want %d", id, called[id], n)
+		}
+	}
+
+	nextHour := time.Date(2026, 10, 18, 13, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		id      int64
+		state   string
+		jobID   bool
+		errText string
+		next    *time.Time
+		lastRun bool
+	}{
+		{onTime, model.JobQueued, true, "", &nextHour, true},
+		{missed, model.ScheduleRunSkipped, false, "wvmc was not running at the scheduled time", &nextHour, false},
+		{runOnce, model.JobQueued, true, "", &nextHour, true},
+		{failing, model.ScheduleRunFailed, false, "no access", &nextHour, true},
+		{never, model.JobQueued, true, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		sc, err := repo.Find(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch {
+		case tt.next == nil && sc.NextRunAt != nil:
+			t.Errorf("schedule %d next run = %v, want none", tt.id, sc.NextRunAt)
+		case tt.next != nil && (sc.NextRunAt == nil || !sc.NextRunAt.Equal(*tt.next)):
+			t.Errorf("schedule %d next run = %v, want %v", tt.id, sc.NextRunAt, tt.next)
+		}
+
+		if lastRun := sc.LastRunAt != nil && sc.LastRunAt.Equal(now); lastRun != tt.lastRun {
+			t.Errorf("schedule %d last run = %v, want set %v", tt.id, sc.LastRunAt, tt.lastRun)
+		}
+
+		runs, err := repo.Runs(tt.id, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(runs) != 1 {
+			t.Fatalf("schedule %d runs = %+v, want one", tt.id, runs)
+		}
+
+		r := runs[0]
+		if r.State != tt.state || (r.JobID != 0) != tt.jobID || r.Error != tt.errText {
+			t.Errorf("schedule %d run = %+v, want state %s, error %q", tt.id, r, tt.state, tt.errText)
+		}
+	}
+
+	// выключенные и будущие расписания не меняются
+	for _, id := range []int64{disabled, future} {
+		sc, err := repo.Find(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if runs, _ := repo.Runs(id, 0); len(runs) != 0 || sc.LastRunAt != nil {
+			t.Errorf("schedule %d = %+v, runs %+v", id, sc, runs)
+		}
+	}
+
+	// следующая проверка в то же время ничего не выполняет
+	runner.calls = nil
+	s.runDue(runner.run, now.Add(checkInterval))
+
+	if len(runner.calls) != 0 {
+		t.Errorf("second check called %v", runner.calls)
+	}
+
+	// наступил следующий час, выполнение future на 12:08 опоздало и пропускается
+	s.runDue(runner.run, nextHour.Add(time.Second))
+
+	sort.Slice(runner.calls, func(i, j int) bool { return runner.calls[i] < runner.calls[j] })
+
+	if want := []int64{onTime, missed, runOnce, failing}; fmt.Sprint(runner.calls) != fmt.Sprint(want) {
+		t.Errorf("calls at the next hour = %v, want %v", runner.calls, want)
+	}
+
+	if runs, _ := repo.Runs(future, 0); len(runs) != 1 || runs[0].State != model.ScheduleRunSkipped {
+		t.Errorf("runs of late schedule = %+v, want skipped", runs)
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	after := time.Date(2026, 10, 18, 12, 7, 0, 0, time.UTC)
+
+	next, err := NextRun("0 9 * * *", "Europe/Moscow", after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("NextRun() = %v, want %v", next, want)
+	}
+
+	if _, err = NextRun("0 0 30 2 *", "UTC", after); !errors.Is(err, ErrNeverRuns) {
+		t.Errorf("NextRun() of 30 february: got %v, want ErrNeverRuns", err)
+	}
+
+	if _, err = NextRun("0 9 * * *", "Mars/Olympus", after); err == nil {
+		t.Errorf("NextRun() with unknown timezone succeeded")
+	}
+
+	if _, err = NextRun("0 9 * *", "UTC", after); err == nil {
+		t.Errorf("NextRun() with invalid cron succeeded")
+	}
+}
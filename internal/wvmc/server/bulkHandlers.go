@@ -0,0 +1,415 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+const (
+	// bulkParallel сколько команд массового управления выполняется одновременно по умолчанию
+	bulkParallel = 4
+
+	// bulkMaxParallel наибольшее количество одновременно выполняемых команд массового управления
+	bulkMaxParallel = 16
+
+	// bulkMaxServers наибольшее количество серверов в одной команде массового управления
+	bulkMaxServers = 200
+
+	// bulkMaxError сколько символов ошибки команды сохраняется в результате сервера
+	bulkMaxError = 200
+)
+
+// Состояния серверов, к которым команда массового управления не применяется
+const (
+	bulkForbidden = "forbidden"
+	bulkNotFound  = "not_found"
+)
+
+// bulkResult результат команды массового управления на одном сервере
+type bulkResult struct {
+	ServerID int64  `json:"server_id"`
+	HV       string `json:"hv"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+}
+
+// bulkSelector выбирает серверы по компании, гипервизору и метке, заданные поля должны совпасть все
+type bulkSelector struct {
+	Company string `json:"company"`
+	HV      string `json:"hv"`
+	Tag     string `json:"tag"`
+}
+
+// empty возвращает true, если не задано ни одно поле
+func (sel bulkSelector) empty() bool {
+	return sel.Company == "" && sel.HV == "" && sel.Tag == ""
+}
+
+// bulkNotice шаблон итогового уведомления о массовом управлении серверами
+const bulkNotice = `
+User: %s %s %s
+Servers: %d, completed: %d, failed: %d, rejected: %d
+Action: %s
+`
+
+// ControlServersBulk запускает команду управления питанием или сетью на серверах server_ids
+// или на серверах, выбранных selector, как одно задание. На каждом сервере проверяются права
+// пользователя, команды выполняются по parallel одновременно. Результаты серверов, включая
+// отклоненные, сохраняются в выводе задания, после выполнения отправляется одно итоговое уведомление.
+func (s *Server) ControlServersBulk() http.HandlerFunc {
+	type request struct {
+		ServerIDs []int64      `json:"server_ids"`
+		Selector  bulkSelector `json:"selector"`
+		Command   string       `json:"command"`
+		Adapter   string       `json:"adapter"`
+		Parallel  int          `json:"parallel"`
+	}
+
+	type response struct {
+		Job     model.Job    `json:"job"`
+		Servers []bulkResult `json:"servers"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		req.Selector.Company = strings.TrimSpace(req.Selector.Company)
+		req.Selector.HV = strings.TrimSpace(req.Selector.HV)
+		req.Selector.Tag = strings.TrimSpace(req.Selector.Tag)
+
+		if s.controlCommand(model.Server{}, req.Command, req.Adapter) == nil {
+			SendErr(w, http.StatusBadRequest, errors.New("incorrect command"), "Неизвестная команда")
+			return
+		}
+
+		if (len(req.ServerIDs) == 0) == req.Selector.empty() {
+			SendErr(w, http.StatusBadRequest, errors.New("either server_ids or selector must be set"),
+				"Нужно указать либо server_ids, либо selector")
+			return
+		}
+
+		if req.Parallel <= 0 {
+			req.Parallel = bulkParallel
+		}
+
+		if req.Parallel > bulkMaxParallel {
+			req.Parallel = bulkMaxParallel
+		}
+
+		var (
+			targets  []model.Server
+			rejected []bulkResult
+			err      error
+		)
+
+		if len(req.ServerIDs) > 0 {
+			targets, rejected, err = s.bulkServersByID(r.Context(), user, req.ServerIDs)
+		} else {
+			targets, err = s.bulkServersBySelector(r.Context(), user, req.Selector)
+		}
+
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		if len(targets) == 0 {
+			for _, v := range rejected {
+				if v.State == bulkForbidden {
+					SendErr(w, http.StatusForbidden, errors.New("user has no permissions"), "Доступ запрещен")
+					return
+				}
+			}
+
+			SendErr(w, http.StatusNotFound, errors.New("servers are not found"), "Серверы не найдены")
+			return
+		}
+
+		if len(targets) > bulkMaxServers {
+			SendErr(w, http.StatusBadRequest, fmt.Errorf("too many servers, max %d", bulkMaxServers),
+				fmt.Sprintf("Слишком много серверов, не больше %d за раз", bulkMaxServers))
+			return
+		}
+
+		command := req.Command
+		if req.Adapter != "" {
+			command = fmt.Sprintf("%s %s", command, req.Adapter)
+		}
+
+		job, ok := s.queueJob(w, r, model.Job{
+			UserID:    user.ID,
+			UserEmail: user.Email,
+			Kind:      "bulk",
+			Command:   command,
+			HV:        req.Selector.HV,
+			Server:    fmt.Sprintf("%d servers", len(targets)),
+		}, func(ctx context.Context) ([]byte, error) {
+			return s.runBulk(ctx, user, targets, rejected, req.Command, req.Adapter, req.Parallel)
+		})
+		if !ok {
+			return
+		}
+
+		servers := make([]bulkResult, 0, len(targets)+len(rejected))
+
+		for _, v := range targets {
+			servers = append(servers, bulkResult{ServerID: v.ID, HV: v.HV, Name: v.Name, State: model.JobQueued})
+		}
+
+		servers = append(servers, rejected...)
+
+		SendOK(w, http.StatusAccepted, response{Job: job, Servers: servers})
+	}
+}
+
+// bulkServersByID возвращает серверы ids, к которым у пользователя user есть доступ,
+// и результаты для остальных серверов: нет доступа или не найден
+func (s *Server) bulkServersByID(ctx context.Context, user model.User,
+	ids []int64) ([]model.Server, []bulkResult, error) {
+	visible, err := s.bulkVisibleServers(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byID := make(map[int64]model.Server, len(visible))
+	for _, v := range visible {
+		byID[v.ID] = v
+	}
+
+	targets := make([]model.Server, 0, len(ids))
+	rejected := make([]bulkResult, 0)
+	seen := make(map[int64]bool, len(ids))
+
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+
+		if v, ok := byID[id]; ok {
+			targets = append(targets, v)
+			continue
+		}
+
+		server, err := s.store.Server(ctx).Find("id", id)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				return nil, nil, err
+			}
+
+			rejected = append(rejected, bulkResult{ServerID: id, State: bulkNotFound, Error: "Сервер не найден"})
+			continue
+		}
+
+		rejected = append(rejected, bulkResult{ServerID: id, HV: server.HV, Name: server.Name,
+			State: bulkForbidden, Error: "Доступ запрещен"})
+	}
+
+	return targets, rejected, nil
+}
+
+// bulkServersBySelector возвращает серверы пользователя user, которые подходят под selector
+func (s *Server) bulkServersBySelector(ctx context.Context, user model.User,
+	sel bulkSelector) ([]model.Server, error) {
+	visible, err := s.bulkVisibleServers(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var tagged map[int64]bool
+
+	if sel.Tag != "" {
+		if tagged, err = s.store.Server(ctx).FindIDsByTag(sel.Tag); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make([]model.Server, 0)
+
+	for _, v := range visible {
+		if sel.Company != "" && !strings.EqualFold(v.Company, sel.Company) {
+			continue
+		}
+
+		if sel.HV != "" && !strings.EqualFold(v.HV, sel.HV) {
+			continue
+		}
+
+		if sel.Tag != "" && !tagged[v.ID] {
+			continue
+		}
+
+		targets = append(targets, v)
+	}
+
+	return targets, nil
+}
+
+// bulkVisibleServers возвращает серверы, которыми может управлять пользователь user,
+// администратор управляет всеми серверами
+func (s *Server) bulkVisibleServers(ctx context.Context, user model.User) ([]model.Server, error) {
+	var (
+		servers []model.Server
+		err     error
+	)
+
+	if user.Role == model.UserRoleAdmin {
+		servers, err = s.store.Server(ctx).All()
+	} else {
+		servers, err = s.store.Server(ctx).FindByUser(user.ID)
+	}
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return servers, nil
+}
+
+// runBulk выполняет команду command на серверах servers по parallel одновременно, возвращает
+// результаты серверов и отклоненных серверов rejected в JSON и отправляет итоговое уведомление.
+// Если команда не выполнилась хотя бы на одном сервере, возвращает ошибку.
+func (s *Server) runBulk(ctx context.Context, user model.User, servers []model.Server, rejected []bulkResult,
+	command, adapter string, parallel int) ([]byte, error) {
+	results := make([]bulkResult, len(servers), len(servers)+len(rejected))
+	sem := make(chan struct{}, parallel)
+
+	var wg sync.WaitGroup
+
+	for i, server := range servers {
+		results[i] = bulkResult{ServerID: server.ID, HV: server.HV, Name: server.Name}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			results[i].State = model.JobCanceled
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(i int, server model.Server) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			_, err := s.controlCommand(server, command, adapter)(ctx)
+
+			switch {
+			case ctx.Err() != nil:
+				results[i].State = model.JobCanceled
+			case err != nil:
+				results[i].State = model.JobFailed
+				results[i].Error = bulkError(err)
+			default:
+				results[i].State = model.JobCompleted
+			}
+		}(i, server)
+	}
+
+	wg.Wait()
+
+	results = append(results, rejected...)
+
+	completed, failed := 0, 0
+
+	for _, v := range results {
+		switch v.State {
+		case model.JobCompleted:
+			completed++
+		case model.JobFailed:
+			failed++
+		}
+	}
+
+	if adapter != "" {
+		command = fmt.Sprintf("%s %s", command, adapter)
+	}
+
+	s.notifyBulk(user, results, completed, failed, len(rejected), command)
+
+	out, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+
+	if failed > 0 {
+		return out, fmt.Errorf("command failed on %d of %d servers", failed, len(servers))
+	}
+
+	return out, nil
+}
+
+// notifyBulk отправляет одно уведомление о массовом управлении серверами с неудачными
+// и отклоненными серверами
+func (s *Server) notifyBulk(user model.User, results []bulkResult, completed, failed, rejected int,
+	action string) {
+	if completed == 0 && failed == 0 {
+		return
+	}
+
+	text := fmt.Sprintf(bulkNotice, user.Email, user.Name, user.Company, len(results), completed, failed,
+		rejected, action)
+
+	if failed > 0 {
+		names := make([]string, 0, failed)
+
+		for _, v := range results {
+			if v.State == model.JobFailed {
+				names = append(names, fmt.Sprintf("%s (%s)", v.Name, v.HV))
+			}
+		}
+
+		text += fmt.Sprintf("Failed: %s\n", strings.Join(names, ", "))
+	}
+
+	if rejected > 0 {
+		names := make([]string, 0, rejected)
+
+		for _, v := range results {
+			switch v.State {
+			case bulkForbidden:
+				names = append(names, fmt.Sprintf("%s (%s): %s", v.Name, v.HV, v.State))
+			case bulkNotFound:
+				names = append(names, fmt.Sprintf("id %d: %s", v.ServerID, v.State))
+			}
+		}
+
+		text += fmt.Sprintf("Rejected: %s\n", strings.Join(names, ", "))
+	}
+
+	if err := s.notify.Notify(text); err != nil {
+		logit.Log("Не удалось отправить уведомление", err)
+	}
+}
+
+// bulkError возвращает ошибку команды, укороченную до bulkMaxError символов
+func bulkError(err error) string {
+	text := []rune(strings.TrimSpace(err.Error()))
+	if len(text) > bulkMaxError {
+		return string(text[:bulkMaxError]) + "..."
+	}
+
+	return string(text)
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// bulkResponse ответ /servers/control/bulk
+type bulkResponse struct {
+	Job     model.Job    `json:"job"`
+	Servers []bulkResult `json:"servers"`
+}
+
+// bulk запускает массовое управление от имени user, ждет завершения задания
+// и возвращает ответ, задание и результаты серверов из его вывода
+func (ts *testServer) bulk(t *testing.T, user model.User, req map[string]interface{}) (bulkResponse, model.Job,
+	map[string]bulkResult) {
+	t.Helper()
+
+	code, body := ts.do(t, user, "POST", "/servers/control/bulk", req)
+	if code != http.StatusAccepted {
+		t.Fatalf("bulk %v: %d %s", req, code, body)
+	}
+
+	var resp bulkResponse
+	decode(t, body, &resp)
+
+	job := ts.waitJob(t, resp.Job.ID)
+
+	var results []bulkResult
+	if err := json.Unmarshal([]byte(job.Output), &results); err != nil {
+		t.Fatalf("job output %q: %v", job.Output, err)
+	}
+
+	byName := make(map[string]bulkResult, len(results))
+	for _, v := range results {
+		key := v.Name
+		if key == "" {
+			key = "id"
+		}
+
+		byName[key] = v
+	}
+
+	return resp, job, byName
+}
+
+func TestBulkPermissions(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+
+	vm1, vm2, vm3 := ts.server(t, "hv1-VM1"), ts.server(t, "hv1-VM2"), ts.server(t, "hv1-VM3")
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1", "hv1-VM3")
+
+	tests := []struct {
+		name string
+		user model.User
+		body map[string]interface{}
+		want int
+	}{
+		{"no token", model.User{}, map[string]interface{}{"server_ids": []int64{vm1.ID}, "command": "stop_power"},
+			http.StatusUnauthorized},
+		{"unknown command", user, map[string]interface{}{"server_ids": []int64{vm1.ID}, "command": "format"},
+			http.StatusBadRequest},
+		{"no servers", user, map[string]interface{}{"command": "stop_power"}, http.StatusBadRequest},
+		{"ids and selector", user, map[string]interface{}{"server_ids": []int64{vm1.ID},
+			"selector": map[string]string{"hv": "hv1"}, "command": "stop_power"}, http.StatusBadRequest},
+		{"only forbidden", user, map[string]interface{}{"server_ids": []int64{vm2.ID}, "command": "stop_power"},
+			http.StatusForbidden},
+		{"only missing", user, map[string]interface{}{"server_ids": []int64{9999}, "command": "stop_power"},
+			http.StatusNotFound},
+		{"selector without matches", user, map[string]interface{}{"selector": map[string]string{"hv": "hv2"},
+			"command": "stop_power"}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, "POST", "/servers/control/bulk", tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	if n := len(ts.notices.sent()); n != 0 {
+		t.Fatalf("rejected requests sent %d notifications", n)
+	}
+
+	resp, job, results := ts.bulk(t, user, map[string]interface{}{
+		"server_ids": []int64{vm1.ID, vm2.ID, vm3.ID, 9999, vm1.ID},
+		"command":    "stop_power",
+	})
+
+	// ответ сразу содержит серверы в очереди и отклоненные серверы
+	states := make([]string, 0, len(resp.Servers))
+	for _, v := range resp.Servers {
+		states = append(states, v.Name+"="+v.State)
+	}
+
+	sort.Strings(states)
+
+	if want := []string{"=not_found", "hv1-VM1=queued", "hv1-VM2=forbidden", "hv1-VM3=queued"}; strings.Join(states,
+		",") != strings.Join(want, ",") {
+		t.Errorf("response servers = %v, want %v", states, want)
+	}
+
+	if job.Kind != "bulk" || job.Server != "2 servers" || job.State != model.JobFailed {
+		t.Errorf("job = %+v", job)
+	}
+
+	// VM3 уже выключена, команда на ней не выполняется
+	want := map[string]string{
+		"hv1-VM1": model.JobCompleted,
+		"hv1-VM3": model.JobFailed,
+		"hv1-VM2": bulkForbidden,
+		"id":      bulkNotFound,
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("job results = %+v", results)
+	}
+
+	for name, state := range want {
+		if results[name].State != state {
+			t.Errorf("result of %s = %+v, want %s", name, results[name], state)
+		}
+	}
+
+	if results["id"].ServerID != 9999 || results["hv1-VM3"].Error == "" {
+		t.Errorf("job results = %+v", results)
+	}
+
+	// сервер без доступа не выключен
+	for _, v := range ts.cache.Servers() {
+		if v.Name == "hv1-VM2" && v.State != string(model.ServerStateRunning) {
+			t.Errorf("forbidden server state = %s", v.State)
+		}
+	}
+
+	notices := ts.notices.sent()
+	if len(notices) != 1 {
+		t.Fatalf("notifications = %q, want exactly one", notices)
+	}
+
+	for _, part := range []string{"user@example.com", "Servers: 4, completed: 1, failed: 1, rejected: 2",
+		"Action: stop_power", "Failed: hv1-VM3 (hv1)", "hv1-VM2 (hv1): forbidden", "id 9999: not_found"} {
+		if !strings.Contains(notices[0], part) {
+			t.Errorf("notification %q has no %q", notices[0], part)
+		}
+	}
+}
+
+func TestBulkSelector(t *testing.T) {
+	ts := newTestServer(t, "hv1", "hv2")
+
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1", "hv1-VM2", "hv2-VM1")
+
+	for _, name := range []string{"hv1-VM2", "hv2-VM1", "hv2-VM2"} {
+		if err := ts.store.Server(context.Background()).SetTags(ts.server(t, name).ID, []string{"web"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		user     model.User
+		selector map[string]string
+		want     []string
+	}{
+		{"admin by host", adminUser, map[string]string{"hv": "HV2"}, []string{"hv2-VM1", "hv2-VM2", "hv2-VM3"}},
+		{"admin by tag", adminUser, map[string]string{"tag": "web"}, []string{"hv1-VM2", "hv2-VM1", "hv2-VM2"}},
+		{"admin by host and tag", adminUser, map[string]string{"hv": "hv2", "tag": "web"},
+			[]string{"hv2-VM1", "hv2-VM2"}},
+		{"user by host", user, map[string]string{"hv": "hv1"}, []string{"hv1-VM1", "hv1-VM2"}},
+		{"user by tag", user, map[string]string{"tag": "web"}, []string{"hv1-VM2", "hv2-VM1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := ts.do(t, tt.user, "POST", "/servers/control/bulk", map[string]interface{}{
+				"selector": tt.selector,
+				// команда на включенных ВМ не выполняется и ничего не меняет
+				"command": "start_power",
+			})
+			if code != http.StatusAccepted {
+				t.Fatalf("bulk: %d %s", code, body)
+			}
+
+			var resp bulkResponse
+			decode(t, body, &resp)
+
+			got := make([]string, 0, len(resp.Servers))
+			for _, v := range resp.Servers {
+				got = append(got, v.Name)
+			}
+
+			sort.Strings(got)
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("servers = %v, want %v", got, tt.want)
+			}
+
+			ts.waitJob(t, resp.Job.ID)
+		})
+	}
+}
+
+func TestBulkParallel(t *testing.T) {
+	ts := newTestServer(t, "hv1", "hv2")
+
+	ids := make([]int64, 0, 6)
+	for _, name := range []string{"hv1-VM1", "hv1-VM2", "hv1-VM3", "hv2-VM1", "hv2-VM2", "hv2-VM3"} {
+		ids = append(ids, ts.server(t, name).ID)
+	}
+
+	// каждая команда выполняется не меньше latency
+	const latency = 40 * time.Millisecond
+
+	ts.sim.SetLatency(latency)
+
+	for _, tt := range []struct {
+		parallel int
+		rounds   int
+	}{
+		{2, 3},
+		// по умолчанию bulkParallel
+		{0, 2},
+	} {
+		_, job, results := ts.bulk(t, adminUser, map[string]interface{}{
+			"server_ids": ids,
+			"command":    "stop_power_force",
+			"parallel":   tt.parallel,
+		})
+
+		if len(results) != len(ids) {
+			t.Fatalf("results = %+v", results)
+		}
+
+		if took := job.FinishedAt.Sub(*job.StartedAt); took < time.Duration(tt.rounds)*latency {
+			t.Errorf("parallel %d: %d commands took %v, want at least %v", tt.parallel, len(ids), took,
+				time.Duration(tt.rounds)*latency)
+		}
+	}
+
+	if n := len(ts.notices.sent()); n != 2 {
+		t.Errorf("notifications = %d, want one per bulk command", n)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/gorilla/mux"
+)
+
+// maxCheckpointName максимальная длина имени контрольной точки
+const maxCheckpointName = 100
+
+// GetCheckpoints возвращает дерево контрольных точек сервера
+func (s *Server) GetCheckpoints() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		server := r.Context().Value(CtxString("server")).(model.Server)
+
+		checkpoints, err := s.controlService.GetCheckpoints(r.Context(), server)
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err,
+				"Ошибка получения контрольных точек")
+			return
+		}
+
+		SendOK(w, http.StatusOK, checkpoints)
+	}
+}
+
+// CreateCheckpoint создает контрольную точку сервера
+func (s *Server) CreateCheckpoint() http.HandlerFunc {
+	type request struct {
+		Name string `json:"name"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+		server := r.Context().Value(CtxString("server")).(model.Server)
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || utf8.RuneCountInString(req.Name) > maxCheckpointName {
+			SendErr(w, http.StatusBadRequest, errors.New("invalid checkpoint name"),
+				fmt.Sprintf("Имя контрольной точки должно быть от 1 до %d символов",
+					maxCheckpointName))
+			return
+		}
+
+		checkpoint, err := s.controlService.CreateCheckpoint(r.Context(), server, req.Name)
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err,
+				"Ошибка создания контрольной точки")
+			return
+		}
+
+		s.notifyAction(user, server, fmt.Sprintf("create_checkpoint %s", checkpoint.Name))
+
+		SendOK(w, http.StatusCreated, checkpoint)
+	}
+}
+
+// ApplyCheckpoint возвращает сервер к контрольной точке
+func (s *Server) ApplyCheckpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+		server := r.Context().Value(CtxString("server")).(model.Server)
+		id := mux.Vars(r)["id"]
+
+		if _, err := s.controlService.ApplyCheckpoint(r.Context(), server, id); err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err,
+				"Ошибка применения контрольной точки")
+			return
+		}
+
+		s.notifyAction(user, server, fmt.Sprintf("apply_checkpoint %s", id))
+
+		SendOK(w, http.StatusOK, "Контрольная точка применена")
+	}
+}
+
+// DeleteCheckpoint удаляет контрольную точку сервера
+func (s *Server) DeleteCheckpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+		server := r.Context().Value(CtxString("server")).(model.Server)
+		id := mux.Vars(r)["id"]
+
+		if _, err := s.controlService.DeleteCheckpoint(r.Context(), server, id); err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err,
+				"Ошибка удаления контрольной точки")
+			return
+		}
+
+		s.notifyAction(user, server, fmt.Sprintf("delete_checkpoint %s", id))
+
+		SendOK(w, http.StatusOK, "Контрольная точка удалена")
+	}
+}
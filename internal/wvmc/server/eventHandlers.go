@@ -0,0 +1,76 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// GetEvents возвращает события изменения ВМ, начиная с последнего.
+// Фильтры: hv, server, vmid, type (через запятую), from и to (RFC3339), before_id и limit.
+// Пользователи видят только события выданных им серверов.
+func (s *Server) GetEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+		q := r.URL.Query()
+
+		f := model.EventFilter{
+			HV:     q.Get("hv"),
+			Server: q.Get("server"),
+			VMID:   q.Get("vmid"),
+			Types:  control.ParseList(q.Get("type")),
+		}
+
+		var err error
+
+		for key, value := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+			if q.Get(key) == "" {
+				continue
+			}
+
+			if *value, err = time.Parse(time.RFC3339, q.Get(key)); err != nil {
+				SendErr(w, http.StatusBadRequest, err, "Время должно быть в формате RFC3339")
+				return
+			}
+		}
+
+		if q.Get("before_id") != "" {
+			if f.BeforeID, err = strconv.ParseInt(q.Get("before_id"), 10, 64); err != nil {
+				SendErr(w, http.StatusBadRequest, err, "Неверное значение before_id")
+				return
+			}
+		}
+
+		if q.Get("limit") != "" {
+			if f.Limit, err = strconv.Atoi(q.Get("limit")); err != nil {
+				SendErr(w, http.StatusBadRequest, err, "Неверное значение limit")
+				return
+			}
+		}
+
+		if user.Role != model.UserRoleAdmin {
+			servers, err := s.store.Server(r.Context()).FindByUser(user.ID)
+			if err != nil && err != sql.ErrNoRows {
+				SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+				return
+			}
+
+			f.VMIDs = make([]string, 0, len(servers))
+			for _, v := range servers {
+				f.VMIDs = append(f.VMIDs, v.VMID)
+			}
+		}
+
+		events, err := s.store.Event(r.Context()).Find(f)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, events)
+	}
+}
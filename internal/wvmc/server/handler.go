@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/control"
 	"net/http"
 )
 
@@ -60,3 +61,49 @@ func SendErr(w http.ResponseWriter, code int, meta error, err interface{}) {
 
 	logit.Info("RESPONSE: ", code, fullResponse)
 }
+
+// commandErrors сопоставляет типы ошибок powershell с http кодом и понятным сообщением
+var commandErrors = []struct {
+	kind    error
+	code    int
+	message string
+}{
+	{control.ErrTimeout, http.StatusGatewayTimeout, "Превышено время ожидания ответа от сервера"},
+	{control.ErrVMNotFound, http.StatusNotFound, "Виртуальная машина не найдена на гипервизоре"},
+	{control.ErrServiceNotFound, http.StatusNotFound, "Служба не найдена"},
+	{control.ErrProcessNotFound, http.StatusNotFound, "Процесс не найден"},
+	{control.ErrCheckpointNotFound, http.StatusNotFound, "Контрольная точка не найдена"},
+	{control.ErrSwitchNotFound, http.StatusNotFound, "Коммутатор не найден на гипервизоре"},
+	{control.ErrAdapterNotFound, http.StatusNotFound, "Сетевой адаптер не найден"},
+	{control.ErrVMMustBeOff, http.StatusConflict,
+		"Выключите виртуальную машину, чтобы изменить эти параметры"},
+	{control.ErrInvalidResources, http.StatusBadRequest, "Неверные параметры процессора или памяти"},
+	{control.ErrVMExists, http.StatusConflict, "Виртуальная машина с таким именем уже существует"},
+	{control.ErrDiskExists, http.StatusConflict, "Диск виртуальной машины с таким именем уже существует"},
+	{control.ErrInvalidTemplate, http.StatusBadRequest, "Неверные параметры шаблона"},
+	{control.ErrInvalidServerName, http.StatusBadRequest,
+		"Имя сервера может содержать только латинские буквы, цифры, точки, дефисы и подчеркивания"},
+	{control.ErrUnknownHost, http.StatusBadRequest, "Гипервизор не найден в списке гипервизоров"},
+	{control.ErrInvalidHypervisor, http.StatusBadRequest, "Неверные параметры гипервизора"},
+	{control.ErrSameHost, http.StatusBadRequest, "Сервер уже находится на этом гипервизоре"},
+	{control.ErrMigrationInProgress, http.StatusConflict, "Сервер уже переносится"},
+	{control.ErrInvalidState, http.StatusConflict,
+		"Команда недоступна в текущем состоянии виртуальной машины"},
+	{control.ErrAccessDenied, http.StatusForbidden,
+		"Доступ запрещен: проверьте учетные данные сервера"},
+	{control.ErrUnreachable, http.StatusBadGateway, "Сервер недоступен"},
+	{control.ErrHostUnavailable, http.StatusServiceUnavailable, "Гипервизор временно недоступен"},
+}
+
+// SendCommandErr отправляет ошибку выполнения команды powershell.
+// Известные типы ошибок отправляются со своим кодом и сообщением, остальные - с кодом code.
+func SendCommandErr(w http.ResponseWriter, code int, meta error, err interface{}) {
+	for _, e := range commandErrors {
+		if errors.Is(meta, e.kind) {
+			SendErr(w, e.code, meta, e.message)
+			return
+		}
+	}
+
+	SendErr(w, code, meta, err)
+}
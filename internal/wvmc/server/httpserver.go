@@ -4,40 +4,69 @@ import (
 	"fmt"
 	"github.com/anaxita/wvmc/internal/wvmc/model"
 	"github.com/anaxita/wvmc/internal/wvmc/notice"
+	"github.com/anaxita/wvmc/internal/wvmc/schedule"
 	"io/ioutil"
 	"net/http"
 	"os"
 
 	"github.com/anaxita/logit"
 	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/jobs"
 	"github.com/anaxita/wvmc/internal/wvmc/store"
 	"github.com/gorilla/mux"
 )
 
+// notifier отправляет уведомления о действиях пользователей и добавляет их IP в белый список
+type notifier interface {
+	Notify(text string) error
+	AddIPToWL(userName, ip4, comment string)
+}
+
 // Server - структура http сервера
 type Server struct {
 	store          *store.Store
 	router         *mux.Router
 	controlService *control.ServerService
-	notify         *notice.KMSBOT
+	notify         notifier
+	stream         *streamHub
+	jobs           *jobs.JobService
 }
 
 // New - создает новый сервер
-func New(storage *store.Store, controlService *control.ServerService, notify *notice.KMSBOT) *Server {
-	return &Server{
+func New(storage *store.Store, controlService *control.ServerService, notify *notice.KMSBOT,
+	jobService *jobs.JobService, scheduler *schedule.Scheduler) *Server {
+	s := &Server{
 		store:          storage,
 		router:         mux.NewRouter(),
 		controlService: controlService,
 		notify:         notify,
+		stream:         newStreamHub(),
+		jobs:           jobService,
 	}
+
+	controlService.Subscribe(s.stream.events)
+	controlService.SubscribeRefresh(s.stream.refreshed)
+	scheduler.Start(s.runSchedule)
+
+	return s
 }
 
 func (s *Server) configureRouter() {
 	r := s.router
-	r.Use(s.Cors)
+	r.Use(s.Instrument, s.Cors)
+
+	// middleware роутера выполняются только для найденных маршрутов, остальные запросы учитываются отдельно
+	r.NotFoundHandler = s.Instrument(http.NotFoundHandler())
+	r.MethodNotAllowedHandler = s.Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
 	r.Handle("/refresh", s.RefreshToken()).Methods("POST", "OPTIONS")
 	r.Handle("/signin", s.SignIn()).Methods("POST", "OPTIONS")
 
+	metrics := r.NewRoute().Subrouter()
+	metrics.Use(s.MetricsAccess)
+	metrics.Handle("/metrics", s.GetPrometheusMetrics()).Methods("GET")
+
 	users := r.NewRoute().Subrouter()
 	users.Use(s.Auth, s.RoleMiddleware(model.UserRoleAdmin))
 
@@ -51,11 +80,40 @@ func (s *Server) configureRouter() {
 	serversShow := r.NewRoute().Subrouter()
 	serversShow.Use(s.Auth)
 	serversShow.Handle("/servers", s.GetServers()).Methods("OPTIONS", "GET")
+	serversShow.Handle("/events", s.GetEvents()).Methods("OPTIONS", "GET")
+	serversShow.Handle("/servers/stream", s.StreamServers()).Methods("OPTIONS", "GET")
+	serversShow.Handle("/jobs", s.GetJobs()).Methods("OPTIONS", "GET")
+	serversShow.Handle("/jobs/{id}", s.GetJob()).Methods("OPTIONS", "GET")
+	serversShow.Handle("/jobs/{id}/cancel", s.CancelJob()).Methods("OPTIONS", "POST")
+	serversShow.Handle("/servers/control/bulk", s.ControlServersBulk()).Methods("OPTIONS", "POST")
+	serversShow.Handle("/schedules", s.GetSchedules()).Methods("OPTIONS", "GET")
+	serversShow.Handle("/schedules", s.CreateSchedule()).Methods("OPTIONS", "POST")
+	serversShow.Handle("/schedules/{id}", s.GetSchedule()).Methods("OPTIONS", "GET")
+	serversShow.Handle("/schedules/{id}", s.EditSchedule()).Methods("OPTIONS", "PUT")
+	serversShow.Handle("/schedules/{id}", s.DeleteSchedule()).Methods("OPTIONS", "DELETE")
+	serversShow.Handle("/schedules/{id}/runs", s.GetScheduleRuns()).Methods("OPTIONS", "GET")
 
 	serversControl := r.NewRoute().Subrouter()
 	serversControl.Use(s.Auth, s.CheckControlPermissions)
 	serversControl.Handle("/servers/control", s.ControlServer()).Methods("POST", "OPTIONS")
 
+	serverAccess := r.NewRoute().Subrouter()
+	serverAccess.Use(s.Auth, s.CheckServerPermissions)
+	serverAccess.Handle("/servers/{hv}/{name}/checkpoints", s.GetCheckpoints()).Methods("OPTIONS", "GET")
+	serverAccess.Handle("/servers/{hv}/{name}/checkpoints", s.CreateCheckpoint()).Methods("OPTIONS", "POST")
+	serverAccess.Handle("/servers/{hv}/{name}/checkpoints/{id}/apply", s.ApplyCheckpoint()).Methods("OPTIONS", "POST")
+	serverAccess.Handle("/servers/{hv}/{name}/checkpoints/{id}", s.DeleteCheckpoint()).Methods("OPTIONS", "DELETE")
+	serverAccess.Handle("/servers/{hv}/{name}/adapters", s.GetServerAdapters()).Methods("OPTIONS", "GET")
+	serverAccess.Handle("/servers/{hv}/{name}/resources", s.GetServerResources()).Methods("OPTIONS", "GET")
+	serverAccess.Handle("/servers/{hv}/{name}/metrics", s.GetServerMetrics()).Methods("OPTIONS", "GET")
+	serverAccess.Handle("/servers/{hv}/{name}/tags", s.GetServerTags()).Methods("OPTIONS", "GET")
+
+	serverAdmin := r.NewRoute().Subrouter()
+	serverAdmin.Use(s.Auth, s.RoleMiddleware(model.UserRoleAdmin), s.CheckServerPermissions)
+	serverAdmin.Handle("/servers/{hv}/{name}/resources", s.SetServerResources()).Methods("OPTIONS", "PATCH")
+	serverAdmin.Handle("/servers/{hv}/{name}/migrate", s.MigrateServer()).Methods("OPTIONS", "POST")
+	serverAdmin.Handle("/servers/{hv}/{name}/tags", s.SetServerTags()).Methods("OPTIONS", "PUT")
+
 	servers := r.NewRoute().Subrouter()
 	servers.Use(s.Auth, s.RoleMiddleware(model.UserRoleAdmin))
 
@@ -66,6 +124,20 @@ func (s *Server) configureRouter() {
 	servers.Handle("/servers/{hv}/{name}/manager", s.GetServerManager()).Methods("OPTIONS", "GET")
 	servers.Handle("/servers/{hv}/{name}/manager", s.ControlServerManager()).Methods("OPTIONS", "POST")
 	servers.Handle("/servers/update", s.UpdateAllServersInfo()).Methods("POST", "OPTIONS")
+	servers.Handle("/servers/{hv}/{name}/switch", s.SetServerSwitch()).Methods("OPTIONS", "PUT")
+	servers.Handle("/hypervisors", s.GetHypervisors()).Methods("OPTIONS", "GET")
+	servers.Handle("/hypervisors", s.CreateHypervisor()).Methods("OPTIONS", "POST")
+	servers.Handle("/hypervisors/{hv}", s.GetHypervisor()).Methods("OPTIONS", "GET")
+	servers.Handle("/hypervisors/{hv}", s.EditHypervisor()).Methods("OPTIONS", "PATCH")
+	servers.Handle("/hypervisors/{hv}", s.DeleteHypervisor()).Methods("OPTIONS", "DELETE")
+	servers.Handle("/hypervisors/{hv}/switches", s.GetHostSwitches()).Methods("OPTIONS", "GET")
+	servers.Handle("/migrations", s.GetMigrations()).Methods("OPTIONS", "GET")
+	servers.Handle("/servers", s.CreateServer()).Methods("OPTIONS", "POST")
+	servers.Handle("/templates", s.GetTemplates()).Methods("OPTIONS", "GET")
+	servers.Handle("/templates", s.CreateTemplate()).Methods("OPTIONS", "POST")
+	servers.Handle("/templates/{id}", s.EditTemplate()).Methods("OPTIONS", "PUT")
+	servers.Handle("/templates/{id}", s.DeleteTemplate()).Methods("OPTIONS", "DELETE")
+	servers.Handle("/migrations/{id}", s.GetMigration()).Methods("OPTIONS", "GET")
 }
 
 // Start - запускает сервер
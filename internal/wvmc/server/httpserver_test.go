@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/cache"
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/jobs"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/anaxita/wvmc/internal/wvmc/store"
+	"github.com/gorilla/mux"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wvmc-server")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = logit.New(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	// ключ подписи токенов
+	os.Setenv("TOKEN", "test-token")
+
+	code := m.Run()
+
+	logit.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeNotifier запоминает уведомления вместо отправки в KMSBOT
+type fakeNotifier struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (n *fakeNotifier) Notify(text string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.texts = append(n.texts, text)
+
+	return nil
+}
+
+func (n *fakeNotifier) AddIPToWL(userName, ip4, comment string) {}
+
+// sent возвращает отправленные уведомления
+func (n *fakeNotifier) sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string(nil), n.texts...)
+}
+
+// testServer http сервер над симулятором гипервизоров и пустой БД
+type testServer struct {
+	*Server
+	sim     *control.Simulator
+	cache   *cache.CacheService
+	notices *fakeNotifier
+}
+
+// newTestServer создает сервер с гипервизорами hvs, ВМ симулятора добавлены в БД
+func newTestServer(t *testing.T, hvs ...string) *testServer {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "wvmc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = store.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	st := store.New(db)
+	if err = st.Hyperv(context.Background()).AddMissing(hvs); err != nil {
+		t.Fatal(err)
+	}
+
+	scripts, err := control.LoadScripts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { scripts.Close() })
+
+	sim := control.NewSimulator(hvs...)
+	c := cache.NewCacheService(cache.DefaultTTL)
+
+	svc := control.NewServerService(sim, c, scripts, control.DefaultTimeouts(),
+		func(ctx context.Context) ([]model.Hypervisor, error) {
+			return st.Hyperv(ctx).Enabled()
+		})
+
+	notices := &fakeNotifier{}
+
+	// планировщик не запускается, расписания выполняются в тестах вызовом runSchedule
+	s := &Server{
+		store:          st,
+		router:         mux.NewRouter(),
+		controlService: svc,
+		notify:         notices,
+		stream:         newStreamHub(),
+		jobs:           jobs.NewJobService(st, jobs.DefaultWorkers),
+	}
+
+	svc.Subscribe(s.stream.events)
+	svc.SubscribeRefresh(s.stream.refreshed)
+	s.configureRouter()
+
+	ts := &testServer{Server: s, sim: sim, cache: c, notices: notices}
+
+	if code, body := ts.do(t, adminUser, "POST", "/servers/update", nil); code != http.StatusOK {
+		t.Fatalf("update servers: %d %s", code, body)
+	}
+
+	return ts
+}
+
+// adminUser администратор, которого нет в БД
+var adminUser = model.User{ID: "0", Email: "admin@example.com", Role: model.UserRoleAdmin}
+
+// createUser добавляет в БД пользователя с доступом к серверам names
+func (ts *testServer) createUser(t *testing.T, email string, role int, names ...string) model.User {
+	t.Helper()
+
+	u := model.User{Name: email, Email: email, Role: role, EncPassword: "x"}
+
+	id, err := ts.store.User(context.Background()).Create(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.ID = strconv.Itoa(id)
+
+	servers := make([]model.Server, 0, len(names))
+	for _, name := range names {
+		servers = append(servers, ts.server(t, name))
+	}
+
+	if len(servers) > 0 {
+		if err = ts.store.User(context.Background()).AddServer(u.ID, servers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return u
+}
+
+// server возвращает сервер name из БД
+func (ts *testServer) server(t *testing.T, name string) model.Server {
+	t.Helper()
+
+	servers, err := ts.store.Server(context.Background()).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, srv := range servers {
+		if srv.Name == name {
+			return srv
+		}
+	}
+
+	t.Fatalf("server %s is not in the database", name)
+
+	return model.Server{}
+}
+
+// do выполняет запрос от имени user и возвращает код ответа и поле message.
+// Пустой user.Email - запрос без токена.
+func (ts *testServer) do(t *testing.T, user model.User, method, path string,
+	body interface{}) (int, json.RawMessage) {
+	t.Helper()
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(method, path, &reqBody)
+	if user.Email != "" {
+		r.Header.Set("Authorization", "Bearer "+createToken("access", user))
+	}
+
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, r)
+
+	var resp struct {
+		Message json.RawMessage `json:"message"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		return w.Code, w.Body.Bytes()
+	}
+
+	return w.Code, resp.Message
+}
+
+// decode разбирает поле message ответа в v
+func decode(t *testing.T, message json.RawMessage, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(message, v); err != nil {
+		t.Fatalf("decode %s: %v", message, err)
+	}
+}
+
+// waitJob ждет завершения задания id и возвращает его
+func (ts *testServer) waitJob(t *testing.T, id int64) model.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		j, err := ts.store.Job(context.Background()).Find(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if j.Finished() {
+			return j
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d is not finished: %+v", id, j)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
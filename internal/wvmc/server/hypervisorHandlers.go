@@ -0,0 +1,283 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/gorilla/mux"
+)
+
+// hypervisorResponse ресурсы гипервизора вместе с его настройками, пароль не отправляется
+type hypervisorResponse struct {
+	model.HostInventory
+	IP      string `json:"ip4"`
+	Enabled bool   `json:"enabled"`
+	User    string `json:"user"`
+}
+
+// GetHypervisors возвращает все гипервизоры с их ресурсами из кеша,
+// с параметром refresh=true ресурсы запрашиваются с гипервизоров заново.
+// Ресурсы выключенных гипервизоров не запрашиваются.
+func (s *Server) GetHypervisors() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hvs, err := s.store.Hyperv(r.Context()).All()
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		result, err := s.hypervisorsInventory(r, hvs)
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка получения данных гипервизоров")
+			return
+		}
+
+		SendOK(w, http.StatusOK, result)
+	}
+}
+
+// GetHypervisor возвращает гипервизор с его ресурсами,
+// с параметром refresh=true ресурсы запрашиваются с гипервизора заново
+func (s *Server) GetHypervisor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hv, ok := s.findHypervisor(w, r)
+		if !ok {
+			return
+		}
+
+		result, err := s.hypervisorsInventory(r, []model.Hypervisor{hv})
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка получения данных гипервизора")
+			return
+		}
+
+		SendOK(w, http.StatusOK, result[0])
+	}
+}
+
+// CreateHypervisor добавляет гипервизор, по умолчанию включенный.
+// ВМ гипервизора появляются в списке серверов после следующего обновления кеша.
+func (s *Server) CreateHypervisor() http.HandlerFunc {
+	type request struct {
+		Name     string `json:"name"`
+		IP       string `json:"ip4"`
+		Enabled  *bool  `json:"enabled"`
+		User     string `json:"user"`
+		Password string `json:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		hv, err := control.ValidateHypervisor(model.Hypervisor{
+			Name:     req.Name,
+			IP:       req.IP,
+			Enabled:  req.Enabled == nil || *req.Enabled,
+			User:     req.User,
+			Password: req.Password,
+		})
+		if err != nil {
+			SendCommandErr(w, http.StatusBadRequest, err, "Неверные параметры гипервизора")
+			return
+		}
+
+		store := s.store.Hyperv(r.Context())
+
+		_, err = store.Find(hv.Name)
+		if err == nil {
+			SendErr(w, http.StatusConflict, errors.New("hypervisor already exists"),
+				"Гипервизор с таким именем уже существует")
+			return
+		}
+
+		if err != sql.ErrNoRows {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		if err = store.Create(hv); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		s.controlService.ReloadHosts()
+
+		if hv.Enabled {
+			s.controlService.RememberHost(hv.Name)
+		}
+
+		s.auditAction(r, user, model.Server{HV: hv.Name}, "create_hypervisor",
+			fmt.Sprintf("enabled %t", hv.Enabled))
+
+		SendOK(w, http.StatusCreated, hv)
+	}
+}
+
+// EditHypervisor изменяет переданные поля гипервизора, ВМ выключенного гипервизора удаляются из кеша.
+// Пустой user удаляет учетную запись гипервизора вместе с паролем.
+func (s *Server) EditHypervisor() http.HandlerFunc {
+	type request struct {
+		IP       *string `json:"ip4"`
+		Enabled  *bool   `json:"enabled"`
+		User     *string `json:"user"`
+		Password *string `json:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		hv, ok := s.findHypervisor(w, r)
+		if !ok {
+			return
+		}
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		if req.IP != nil {
+			hv.IP = *req.IP
+		}
+
+		if req.Enabled != nil {
+			hv.Enabled = *req.Enabled
+		}
+
+		if req.User != nil {
+			hv.User = *req.User
+			if hv.User == "" {
+				hv.Password = ""
+			}
+		}
+
+		if req.Password != nil {
+			hv.Password = *req.Password
+		}
+
+		hv, err := control.ValidateHypervisor(hv)
+		if err != nil {
+			SendCommandErr(w, http.StatusBadRequest, err, "Неверные параметры гипервизора")
+			return
+		}
+
+		if err = s.store.Hyperv(r.Context()).Edit(hv); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		s.controlService.ReloadHosts()
+
+		// ВМ включенного гипервизора появятся после следующего обновления кеша
+		if hv.Enabled {
+			s.controlService.RememberHost(hv.Name)
+		} else {
+			s.controlService.ForgetHost(hv.Name)
+		}
+
+		s.auditAction(r, user, model.Server{HV: hv.Name}, "edit_hypervisor",
+			fmt.Sprintf("enabled %t", hv.Enabled))
+
+		SendOK(w, http.StatusOK, hv)
+	}
+}
+
+// DeleteHypervisor удаляет гипервизор из списка и его ВМ из кеша.
+// Серверы гипервизора и доступы к ним в БД сохраняются.
+func (s *Server) DeleteHypervisor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		hv, ok := s.findHypervisor(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.store.Hyperv(r.Context()).Delete(hv.Name); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		s.controlService.ReloadHosts()
+
+		s.controlService.ForgetHost(hv.Name)
+		s.auditAction(r, user, model.Server{HV: hv.Name}, "delete_hypervisor", "")
+
+		SendOK(w, http.StatusOK, "Гипервизор удален")
+	}
+}
+
+// hypervisorsInventory добавляет к гипервизорам hvs их ресурсы
+func (s *Server) hypervisorsInventory(r *http.Request,
+	hvs []model.Hypervisor) ([]hypervisorResponse, error) {
+	enabled := make([]string, 0, len(hvs))
+	for _, hv := range hvs {
+		if hv.Enabled {
+			enabled = append(enabled, hv.Name)
+		}
+	}
+
+	get := s.controlService.GetHostsInventory
+	if r.URL.Query().Get("refresh") == "true" {
+		get = s.controlService.RefreshHostsInventory
+	}
+
+	hosts, err := get(r.Context(), enabled)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]hypervisorResponse, 0, len(hvs))
+
+	for _, hv := range hvs {
+		host := model.HostInventory{
+			Name:    hv.Name,
+			Status:  model.HostStatusDisabled,
+			Storage: make([]model.HostStorage, 0),
+		}
+
+		for _, h := range hosts {
+			if h.Name == hv.Name {
+				host = h
+				break
+			}
+		}
+
+		result = append(result, hypervisorResponse{
+			HostInventory: host,
+			IP:            hv.IP,
+			Enabled:       hv.Enabled,
+			User:          hv.User,
+		})
+	}
+
+	return result, nil
+}
+
+// findHypervisor ищет гипервизор из пути запроса. При ошибке отправляет ответ и возвращает false.
+func (s *Server) findHypervisor(w http.ResponseWriter, r *http.Request) (model.Hypervisor, bool) {
+	hv, err := s.store.Hyperv(r.Context()).Find(mux.Vars(r)["hv"])
+	if err != nil {
+		if err == sql.ErrNoRows {
+			SendErr(w, http.StatusNotFound, errors.New("hypervisor is not found"), "Гипервизор не найден")
+			return hv, false
+		}
+
+		SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+		return hv, false
+	}
+
+	return hv, true
+}
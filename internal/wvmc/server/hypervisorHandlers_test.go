@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func TestHypervisorInventoryHandlers(t *testing.T) {
+	ts := newTestServer(t, "hv1", "hv2")
+
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1")
+
+	tests := []struct {
+		name string
+		user model.User
+		path string
+		want int
+	}{
+		{"user lists", user, "/hypervisors", http.StatusForbidden},
+		{"user reads", user, "/hypervisors/hv1", http.StatusForbidden},
+		{"no token", model.User{}, "/hypervisors", http.StatusUnauthorized},
+		{"unknown host", adminUser, "/hypervisors/hv9", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, "GET", tt.path, nil); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	code, body := ts.do(t, adminUser, "GET", "/hypervisors/hv1", nil)
+	if code != http.StatusOK {
+		t.Fatalf("hypervisor: %d %s", code, body)
+	}
+
+	var host hypervisorResponse
+	if decode(t, body, &host); host.Status != model.HostStatusOK || !host.Enabled || host.VMs.Total != 3 ||
+		host.VMs.Running != 2 || host.VMs.Off != 1 || host.LogicalProcessors == 0 || len(host.Storage) != 1 {
+		t.Errorf("hypervisor = %+v", host)
+	}
+
+	// с refresh=true ресурсы запрашиваются с гипервизора заново
+	ts.control(t, adminUser, ts.server(t, "hv1-VM1"), "stop_power", "")
+
+	_, body = ts.do(t, adminUser, "GET", "/hypervisors/hv1?refresh=true", nil)
+	if decode(t, body, &host); host.VMs.Running != 1 || host.VMs.Off != 2 {
+		t.Errorf("refreshed hypervisor = %+v", host.VMs)
+	}
+
+	// выключенный гипервизор не опрашивается
+	if err := ts.store.Hyperv(context.Background()).Edit(model.Hypervisor{Name: "hv2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// недоступный гипервизор возвращается с последними известными данными
+	ts.sim.SetHostDown("hv1", true)
+
+	code, body = ts.do(t, adminUser, "GET", "/hypervisors?refresh=true", nil)
+	if code != http.StatusOK {
+		t.Fatalf("hypervisors: %d %s", code, body)
+	}
+
+	var hosts []hypervisorResponse
+	decode(t, body, &hosts)
+
+	byName := make(map[string]hypervisorResponse, len(hosts))
+	for _, h := range hosts {
+		byName[h.Name] = h
+	}
+
+	if h := byName["hv1"]; len(hosts) != 2 || h.Status != model.HostStatusUnreachable || h.Error == "" ||
+		h.VMs.Total != 3 {
+		t.Errorf("unreachable hypervisor = %+v", h)
+	}
+
+	if h := byName["hv2"]; h.Status != model.HostStatusDisabled || h.Enabled || h.VMs.Total != 0 {
+		t.Errorf("disabled hypervisor = %+v", h)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/anaxita/wvmc/internal/wvmc/jobs"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/gorilla/mux"
+)
+
+// GetJobs возвращает задания пользователя, администратору - задания всех пользователей,
+// начиная с последнего. Количество ограничивается параметром limit.
+func (s *Server) GetJobs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil {
+				SendErr(w, http.StatusBadRequest, err, "Неверное значение limit")
+				return
+			}
+		}
+
+		userID := user.ID
+		if user.Role == model.UserRoleAdmin {
+			userID = ""
+		}
+
+		result, err := s.store.Job(r.Context()).FindByUser(userID, limit)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, result)
+	}
+}
+
+// GetJob возвращает состояние и вывод задания
+func (s *Server) GetJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := s.findJob(w, r)
+		if !ok {
+			return
+		}
+
+		SendOK(w, http.StatusOK, job)
+	}
+}
+
+// CancelJob отменяет задание из очереди или прерывает выполняемое задание
+func (s *Server) CancelJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := s.findJob(w, r)
+		if !ok {
+			return
+		}
+
+		job, err := s.jobs.Cancel(r.Context(), job.ID)
+		if err != nil {
+			if errors.Is(err, jobs.ErrJobFinished) {
+				SendErr(w, http.StatusConflict, err, "Задание уже завершено")
+				return
+			}
+
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка отмены задания")
+			return
+		}
+
+		SendOK(w, http.StatusOK, job)
+	}
+}
+
+// submitJob запускает команду command сервера server как задание пользователя user
+// и отправляет его в ответ со статусом 202
+func (s *Server) submitJob(w http.ResponseWriter, r *http.Request, user model.User, server model.Server,
+	kind, command string, fn jobs.Func) {
+	job, ok := s.queueJob(w, r, model.Job{
+		UserID:    user.ID,
+		UserEmail: user.Email,
+		Kind:      kind,
+		Command:   command,
+		HV:        server.HV,
+		Server:    server.Name,
+	}, fn)
+	if !ok {
+		return
+	}
+
+	SendOK(w, http.StatusAccepted, job)
+}
+
+// queueJob добавляет задание job в очередь. При ошибке отправляет ответ и возвращает false.
+func (s *Server) queueJob(w http.ResponseWriter, r *http.Request, job model.Job,
+	fn jobs.Func) (model.Job, bool) {
+	job, err := s.jobs.Submit(r.Context(), job, fn)
+	if err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) {
+			SendErr(w, http.StatusServiceUnavailable, err, "Слишком много команд в очереди, повторите позже")
+			return job, false
+		}
+
+		SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+		return job, false
+	}
+
+	return job, true
+}
+
+// findJob ищет задание из пути запроса среди заданий пользователя, администратор видит все задания.
+// При ошибке отправляет ответ и возвращает false.
+func (s *Server) findJob(w http.ResponseWriter, r *http.Request) (model.Job, bool) {
+	user := r.Context().Value(CtxString("user")).(model.User)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		SendErr(w, http.StatusBadRequest, err, "Неверный ID задания")
+		return model.Job{}, false
+	}
+
+	job, err := s.store.Job(r.Context()).Find(id)
+	if err == nil && user.Role != model.UserRoleAdmin && job.UserID != user.ID {
+		err = sql.ErrNoRows
+	}
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			SendErr(w, http.StatusNotFound, errors.New("job is not found"), "Задание не найдено")
+			return job, false
+		}
+
+		SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+		return job, false
+	}
+
+	return job, true
+}
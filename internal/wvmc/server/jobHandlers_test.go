@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func TestJobsOfOtherUsersAreHidden(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+
+	owner := ts.createUser(t, "owner@example.com", model.UserRoleUser)
+	other := ts.createUser(t, "other@example.com", model.UserRoleUser)
+
+	// задание в очереди, обработчики его не видят
+	id, err := ts.store.Job(context.Background()).Create(model.Job{UserID: owner.ID, UserEmail: owner.Email,
+		Kind: "control", Command: "stop_power", HV: "hv1", Server: "hv1-VM1", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/jobs/" + strconv.FormatInt(id, 10)
+
+	tests := []struct {
+		name   string
+		user   model.User
+		method string
+		path   string
+		want   int
+	}{
+		{"other user", other, "GET", path, http.StatusNotFound},
+		{"other user cancels", other, "POST", path + "/cancel", http.StatusNotFound},
+		{"owner", owner, "GET", path, http.StatusOK},
+		{"admin", adminUser, "GET", path, http.StatusOK},
+		{"unknown job", owner, "GET", "/jobs/999", http.StatusNotFound},
+		{"invalid id", owner, "GET", "/jobs/x", http.StatusBadRequest},
+		{"no token", model.User{}, "GET", path, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, tt.method, tt.path, nil); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	var list []model.Job
+
+	_, body := ts.do(t, other, "GET", "/jobs", nil)
+	if decode(t, body, &list); len(list) != 0 {
+		t.Errorf("jobs of other user = %+v", list)
+	}
+
+	_, body = ts.do(t, owner, "GET", "/jobs", nil)
+	if decode(t, body, &list); len(list) != 1 || list[0].ID != id {
+		t.Errorf("jobs of owner = %+v", list)
+	}
+
+	// владелец отменяет задание, повторная отмена - конфликт
+	code, body := ts.do(t, owner, "POST", path+"/cancel", nil)
+	if code != http.StatusOK {
+		t.Fatalf("cancel: %d %s", code, body)
+	}
+
+	var job model.Job
+	if decode(t, body, &job); job.State != model.JobCanceled {
+		t.Errorf("canceled job = %+v", job)
+	}
+
+	if code, _ = ts.do(t, owner, "POST", path+"/cancel", nil); code != http.StatusConflict {
+		t.Errorf("second cancel: got %d, want 409", code)
+	}
+}
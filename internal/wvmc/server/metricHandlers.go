@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// maxMetricPoints наибольшее количество точек в одном ответе
+const maxMetricPoints = 1000
+
+// metricSteps шаги, из которых выбирается шаг по умолчанию
+var metricSteps = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// GetServerMetrics возвращает загрузку процессора, выделенную память и долю времени работы сервера
+// с from до to (RFC3339, по умолчанию за последние сутки), усредненные за шаг step (например 5m).
+// По умолчанию выбирается шаг, при котором точек не больше 300.
+func (s *Server) GetServerMetrics() http.HandlerFunc {
+	type response struct {
+		HV     string              `json:"hv"`
+		Name   string              `json:"name"`
+		From   time.Time           `json:"from"`
+		To     time.Time           `json:"to"`
+		Step   int64               `json:"step"`
+		Points []model.MetricPoint `json:"points"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		server := r.Context().Value(CtxString("server")).(model.Server)
+		q := r.URL.Query()
+
+		to := time.Now().UTC()
+		from := to.Add(-24 * time.Hour)
+
+		var err error
+
+		for key, value := range map[string]*time.Time{"from": &from, "to": &to} {
+			if q.Get(key) == "" {
+				continue
+			}
+
+			if *value, err = time.Parse(time.RFC3339, q.Get(key)); err != nil {
+				SendErr(w, http.StatusBadRequest, err, "Время должно быть в формате RFC3339")
+				return
+			}
+		}
+
+		if q.Get("to") != "" && q.Get("from") == "" {
+			from = to.Add(-24 * time.Hour)
+		}
+
+		if !from.Before(to) {
+			SendErr(w, http.StatusBadRequest, errors.New("from must be before to"),
+				"Начало периода должно быть раньше конца")
+			return
+		}
+
+		step := defaultMetricStep(to.Sub(from))
+
+		if q.Get("step") != "" {
+			if step, err = time.ParseDuration(q.Get("step")); err != nil || step < time.Minute {
+				SendErr(w, http.StatusBadRequest, errors.New("step must be a duration of at least 1m"),
+					"Шаг должен быть не меньше минуты, например 5m или 1h")
+				return
+			}
+		}
+
+		if to.Sub(from)/step > maxMetricPoints {
+			SendErr(w, http.StatusBadRequest, errors.New("too many points, increase step"),
+				"Слишком много точек, увеличьте шаг")
+			return
+		}
+
+		points, err := s.store.Metric(r.Context()).Find(server.HV, server.Name, from, to, step)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, response{
+			HV:     server.HV,
+			Name:   server.Name,
+			From:   from,
+			To:     to,
+			Step:   int64(step.Seconds()),
+			Points: points,
+		})
+	}
+}
+
+// defaultMetricStep возвращает наименьший шаг, при котором за период d точек не больше 300
+func defaultMetricStep(d time.Duration) time.Duration {
+	for _, step := range metricSteps {
+		if d/step <= 300 {
+			return step
+		}
+	}
+
+	return metricSteps[len(metricSteps)-1]
+}
@@ -176,6 +176,7 @@ func (s *Server) CheckControlPermissions(next http.Handler) http.Handler {
 	type controlRequest struct {
 		ServerID int64  `json:"server_id"`
 		Command  string `json:"command"`
+		Adapter  string `json:"adapter"`
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -229,6 +230,7 @@ func (s *Server) CheckControlPermissions(next http.Handler) http.Handler {
 					ctxCommand := CtxString("command")
 					newCtx := context.WithValue(r.Context(), ctxServer, srv)
 					newCtx = context.WithValue(newCtx, ctxCommand, req.Command)
+					newCtx = context.WithValue(newCtx, CtxString("adapter"), req.Adapter)
 
 					next.ServeHTTP(w, r.WithContext(newCtx))
 
@@ -245,7 +247,64 @@ func (s *Server) CheckControlPermissions(next http.Handler) http.Handler {
 		ctxCommand := CtxString("command")
 		newCtx := context.WithValue(r.Context(), ctxServer, server)
 		newCtx = context.WithValue(newCtx, ctxCommand, req.Command)
+		newCtx = context.WithValue(newCtx, CtxString("adapter"), req.Adapter)
 
 		next.ServeHTTP(w, r.WithContext(newCtx))
 	})
 }
+
+// CheckServerPermissions проверяет права пользователя на сервер из пути запроса /servers/{hv}/{name}
+// и передает сервер в контекст запроса
+func (s *Server) CheckServerPermissions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		ctxUser := r.Context().Value(CtxString("user")).(model.User)
+
+		server, err := s.store.Server(r.Context()).FindByHvAndName(vars["hv"], vars["name"])
+		if err != nil {
+			if err == sql.ErrNoRows {
+				SendErr(w, http.StatusNotFound, err, "Сервер не найден")
+				return
+			}
+
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		logit.Info("Проверяем права на сервер у пользователя", ctxUser.Email)
+
+		if ctxUser.Role != model.UserRoleAdmin {
+			if _, err = s.findUserServer(r.Context(), ctxUser, server.ID); err != nil {
+				if err == sql.ErrNoRows {
+					SendErr(w, http.StatusForbidden, err, "Доступ запрещен")
+					return
+				}
+
+				SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+				return
+			}
+		}
+
+		ctxServer := CtxString("server")
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxServer, server)))
+	})
+}
+
+// findUserServer ищет сервер serverID среди серверов пользователя user,
+// если доступа к серверу нет, возвращает sql.ErrNoRows
+func (s *Server) findUserServer(ctx context.Context, user model.User,
+	serverID int64) (model.Server, error) {
+	servers, err := s.store.Server(ctx).FindByUser(user.ID)
+	if err != nil {
+		return model.Server{}, err
+	}
+
+	for _, srv := range servers {
+		if srv.ID == serverID {
+			return srv, nil
+		}
+	}
+
+	return model.Server{}, sql.ErrNoRows
+}
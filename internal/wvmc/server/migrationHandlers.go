@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/gorilla/mux"
+)
+
+// MigrateServer запускает перенос сервера на другой гипервизор и сразу возвращает его описание.
+// После переноса гипервизор сервера меняется в БД, доступы пользователей сохраняются.
+func (s *Server) MigrateServer() http.HandlerFunc {
+	type request struct {
+		Destination string `json:"destination"`
+		StoragePath string `json:"storage_path"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+		server := r.Context().Value(CtxString("server")).(model.Server)
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		req.Destination = strings.TrimSpace(req.Destination)
+		if req.Destination == "" {
+			SendErr(w, http.StatusBadRequest, errors.New("destination cannot be empty"),
+				"Укажите гипервизор, на который нужно перенести сервер")
+			return
+		}
+
+		if req.StoragePath == "" {
+			req.StoragePath = os.Getenv("MIGRATION_STORAGE_PATH")
+		}
+
+		migration, err := s.controlService.MigrateServer(r.Context(), server, req.Destination, req.StoragePath,
+			func(m control.Migration) {
+				s.finishMigration(user, server, m)
+			})
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка запуска переноса")
+			return
+		}
+
+		action := fmt.Sprintf("migrate to %s", migration.Destination)
+
+		s.auditAction(r, user, server, "migrate", fmt.Sprintf("%s -> %s", server.HV,
+			migration.Destination))
+		s.notifyAction(user, server, action)
+
+		SendOK(w, http.StatusAccepted, migration)
+	}
+}
+
+// finishMigration переносит сервер на новый гипервизор в БД после успешного переноса
+// и отправляет уведомление о результате
+func (s *Server) finishMigration(user model.User, server model.Server, m control.Migration) {
+	action := fmt.Sprintf("migrate to %s: %s", m.Destination, m.State)
+
+	if m.State == control.MigrationCompleted {
+		if err := s.store.Server(context.Background()).Move(server.ID, m.Destination); err != nil {
+			logit.Log("Не удалось изменить гипервизор сервера в БД", server.Name, err)
+			action = fmt.Sprintf("%s, database is not updated: %s", action, err)
+		}
+	} else {
+		action = fmt.Sprintf("%s: %s", action, m.Error)
+	}
+
+	s.notifyAction(user, server, action)
+}
+
+// GetMigrations возвращает список переносов серверов
+func (s *Server) GetMigrations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		SendOK(w, http.StatusOK, s.controlService.GetMigrations())
+	}
+}
+
+// GetMigration возвращает перенос сервера по его ID
+func (s *Server) GetMigration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			SendErr(w, http.StatusBadRequest, err, "Неверный ID переноса")
+			return
+		}
+
+		migration, ok := s.controlService.GetMigration(id)
+		if !ok {
+			SendErr(w, http.StatusNotFound, errors.New("migration is not found"),
+				"Перенос не найден")
+			return
+		}
+
+		SendOK(w, http.StatusOK, migration)
+	}
+}
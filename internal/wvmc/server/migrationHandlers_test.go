@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// serversNamed возвращает все записи серверов name в БД
+func (ts *testServer) serversNamed(t *testing.T, name string) []model.Server {
+	t.Helper()
+
+	servers, err := ts.store.Server(context.Background()).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make([]model.Server, 0)
+	for _, srv := range servers {
+		if srv.Name == name {
+			result = append(result, srv)
+		}
+	}
+
+	return result
+}
+
+func TestMigrateServerHandler(t *testing.T) {
+	ts := newTestServer(t, "hv1", "hv2")
+
+	vm := ts.server(t, "hv1-VM1")
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1")
+
+	tests := []struct {
+		name string
+		user model.User
+		body interface{}
+		want int
+	}{
+		{"user", user, map[string]string{"destination": "hv2"}, http.StatusForbidden},
+		{"no destination", adminUser, map[string]string{"destination": " "}, http.StatusBadRequest},
+		{"same host", adminUser, map[string]string{"destination": "HV1"}, http.StatusBadRequest},
+		{"unknown host", adminUser, map[string]string{"destination": "hv3"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, "POST", "/servers/hv1/hv1-VM1/migrate", tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	code, body := ts.do(t, adminUser, "POST", "/servers/hv1/hv1-VM1/migrate",
+		map[string]string{"destination": "hv2"})
+	if code != http.StatusAccepted {
+		t.Fatalf("migrate: %d %s", code, body)
+	}
+
+	var m control.Migration
+	decode(t, body, &m)
+
+	// гипервизор в БД меняется после завершения переноса
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.server(t, "hv1-VM1").HV != "hv2" {
+		if time.Now().After(deadline) {
+			t.Fatal("server is not moved in the database")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code, body = ts.do(t, adminUser, "GET", "/migrations/"+strconv.FormatInt(m.ID, 10), nil); code != http.StatusOK {
+		t.Fatalf("get migration: %d %s", code, body)
+	}
+
+	decode(t, body, &m)
+
+	if m.State != control.MigrationCompleted {
+		t.Errorf("migration = %+v, want completed", m)
+	}
+
+	if moved := ts.server(t, "hv1-VM1"); moved.ID != vm.ID {
+		t.Errorf("moved server ID = %d, want %d", moved.ID, vm.ID)
+	}
+
+	for _, v := range ts.cache.Servers() {
+		if v.Name == "hv1-VM1" && v.HV != "hv2" {
+			t.Errorf("cached server is on %s, want hv2", v.HV)
+		}
+	}
+
+	// пользователь сохраняет доступ к серверу на новом гипервизоре
+	if code, body = ts.do(t, user, "GET", "/servers/hv2/hv1-VM1/adapters", nil); code != http.StatusOK {
+		t.Errorf("user access after migration: %d %s", code, body)
+	}
+
+	if code, _ = ts.do(t, user, "GET", "/servers/hv1/hv1-VM1/adapters", nil); code != http.StatusNotFound {
+		t.Errorf("server on the old host: got %d, want 404", code)
+	}
+}
+
+func TestFinishMigration(t *testing.T) {
+	ts := newTestServer(t, "hv1", "hv2")
+
+	vm := ts.server(t, "hv1-VM1")
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1")
+
+	failed := control.Migration{ServerID: vm.ID, Name: vm.Name, Source: "hv1", Destination: "hv2",
+		State: control.MigrationFailed, Error: "failed"}
+
+	ts.finishMigration(adminUser, vm, failed)
+
+	if srv := ts.server(t, "hv1-VM1"); srv.HV != "hv1" {
+		t.Fatalf("server of failed migration is on %s", srv.HV)
+	}
+
+	// ВМ уже на hv2, и обновление списка серверов создало для нее вторую запись
+	done := make(chan control.Migration, 1)
+	if _, err := ts.controlService.MigrateServer(context.Background(), vm, "hv2", "",
+		func(m control.Migration) { done <- m }); err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-done
+
+	if code, body := ts.do(t, adminUser, "POST", "/servers/update", nil); code != http.StatusOK {
+		t.Fatalf("update servers: %d %s", code, body)
+	}
+
+	if n := len(ts.serversNamed(t, "hv1-VM1")); n != 2 {
+		t.Fatalf("records of hv1-VM1 before finish = %d, want 2", n)
+	}
+
+	ts.finishMigration(adminUser, vm, m)
+
+	records := ts.serversNamed(t, "hv1-VM1")
+	if len(records) != 1 || records[0].ID != vm.ID || records[0].HV != "hv2" {
+		t.Fatalf("records of hv1-VM1 = %+v, want server %d on hv2", records, vm.ID)
+	}
+
+	if code, body := ts.do(t, user, "GET", "/servers/hv2/hv1-VM1/adapters", nil); code != http.StatusOK {
+		t.Errorf("user access after migration: %d %s", code, body)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/gorilla/mux"
+)
+
+// GetHostSwitches возвращает список виртуальных коммутаторов гипервизора
+func (s *Server) GetHostSwitches() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hv := mux.Vars(r)["hv"]
+
+		switches, err := s.controlService.GetHostSwitches(r.Context(), hv)
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка получения коммутаторов")
+			return
+		}
+
+		SendOK(w, http.StatusOK, switches)
+	}
+}
+
+// GetServerAdapters возвращает сетевые адаптеры сервера и коммутатор, к которому подключается его сеть
+func (s *Server) GetServerAdapters() http.HandlerFunc {
+	type response struct {
+		SwitchName string      `json:"switch_name"`
+		Adapters   interface{} `json:"adapters"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		server := r.Context().Value(CtxString("server")).(model.Server)
+
+		adapters, err := s.controlService.GetServerAdapters(r.Context(), server)
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка получения сетевых адаптеров")
+			return
+		}
+
+		switchName := server.Switch
+		if switchName == "" {
+			switchName = control.DefaultSwitch()
+		}
+
+		SendOK(w, http.StatusOK, response{switchName, adapters})
+	}
+}
+
+// SetServerSwitch задает коммутатор, к которому подключается сеть сервера
+func (s *Server) SetServerSwitch() http.HandlerFunc {
+	type request struct {
+		SwitchName string `json:"switch_name"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		req.SwitchName = strings.TrimSpace(req.SwitchName)
+
+		store := s.store.Server(r.Context())
+
+		server, err := store.FindByHvAndName(vars["hv"], vars["name"])
+		if err != nil {
+			if err == sql.ErrNoRows {
+				SendErr(w, http.StatusNotFound, err, "Сервер не найден")
+				return
+			}
+
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		// пустое имя возвращает коммутатор по умолчанию, остальные должны быть на гипервизоре
+		if req.SwitchName != "" {
+			switches, err := s.controlService.GetHostSwitches(r.Context(), server.HV)
+			if err != nil {
+				SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка получения коммутаторов")
+				return
+			}
+
+			found := false
+			for _, v := range switches {
+				if v.Name == req.SwitchName {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				SendErr(w, http.StatusBadRequest, errors.New("switch is not found on hypervisor"),
+					"Коммутатор не найден на гипервизоре")
+				return
+			}
+		}
+
+		if err = store.SetSwitch(server.ID, req.SwitchName); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, "Коммутатор сервера изменен")
+	}
+}
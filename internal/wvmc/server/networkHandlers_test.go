@@ -0,0 +1,171 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// adaptersResponse ответ /servers/{hv}/{name}/adapters
+type adaptersResponse struct {
+	SwitchName string                   `json:"switch_name"`
+	Adapters   []control.NetworkAdapter `json:"adapters"`
+}
+
+// adapters возвращает коммутатор сервера name и коммутаторы его адаптеров по имени адаптера
+func (ts *testServer) adapters(t *testing.T, user model.User, name string) (string, map[string]string) {
+	t.Helper()
+
+	code, body := ts.do(t, user, "GET", "/servers/hv1/"+name+"/adapters", nil)
+	if code != http.StatusOK {
+		t.Fatalf("adapters of %s: %d %s", name, code, body)
+	}
+
+	var resp adaptersResponse
+	decode(t, body, &resp)
+
+	switches := make(map[string]string, len(resp.Adapters))
+	for _, a := range resp.Adapters {
+		switches[a.Name] = a.SwitchName
+	}
+
+	return resp.SwitchName, switches
+}
+
+// control выполняет команду сервера от имени user и ждет завершения задания
+func (ts *testServer) control(t *testing.T, user model.User, server model.Server, command,
+	adapter string) model.Job {
+	t.Helper()
+
+	code, body := ts.do(t, user, "POST", "/servers/control", map[string]interface{}{
+		"server_id": server.ID,
+		"command":   command,
+		"adapter":   adapter,
+	})
+	if code != http.StatusAccepted {
+		t.Fatalf("%s %s: %d %s", command, adapter, code, body)
+	}
+
+	var job model.Job
+	decode(t, body, &job)
+
+	return ts.waitJob(t, job.ID)
+}
+
+func TestGetHostSwitches(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1")
+
+	if code, _ := ts.do(t, user, "GET", "/hypervisors/hv1/switches", nil); code != http.StatusForbidden {
+		t.Errorf("switches for user: got %d, want 403", code)
+	}
+
+	code, body := ts.do(t, adminUser, "GET", "/hypervisors/hv1/switches", nil)
+	if code != http.StatusOK {
+		t.Fatalf("switches: %d %s", code, body)
+	}
+
+	var switches []control.VMSwitch
+	if decode(t, body, &switches); len(switches) != 2 || switches[0].Name != control.DefaultSwitch() ||
+		switches[1].Name != "LAN - Virtual Switch" {
+		t.Errorf("switches = %+v", switches)
+	}
+
+	// гипервизора нет в списке гипервизоров
+	if code, _ = ts.do(t, adminUser, "GET", "/hypervisors/hv9/switches", nil); code != http.StatusBadRequest {
+		t.Errorf("switches of unknown host: got %d, want 400", code)
+	}
+}
+
+func TestSetServerSwitch(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+
+	const lan = "LAN - Virtual Switch"
+
+	vm2 := ts.server(t, "hv1-VM2")
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM2")
+
+	tests := []struct {
+		name string
+		user model.User
+		path string
+		body interface{}
+		want int
+	}{
+		{"user", user, "/servers/hv1/hv1-VM2/switch", map[string]string{"switch_name": lan}, http.StatusForbidden},
+		{"unknown switch", adminUser, "/servers/hv1/hv1-VM2/switch",
+			map[string]string{"switch_name": "WAN - Virtual Switch"}, http.StatusBadRequest},
+		{"unknown server", adminUser, "/servers/hv1/hv1-VM9/switch", map[string]string{"switch_name": lan},
+			http.StatusNotFound},
+		{"invalid json", adminUser, "/servers/hv1/hv1-VM2/switch", "x", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, "PUT", tt.path, tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	if got := ts.server(t, "hv1-VM2").Switch; got != "" {
+		t.Fatalf("switch after rejected requests = %q", got)
+	}
+
+	// без заданного коммутатора сеть подключается к коммутатору по умолчанию
+	if name, _ := ts.adapters(t, user, "hv1-VM2"); name != control.DefaultSwitch() {
+		t.Errorf("switch of server = %q, want default", name)
+	}
+
+	ts.control(t, user, vm2, "stop_network", "")
+
+	if job := ts.control(t, user, vm2, "start_network", "LAN"); job.State != model.JobCompleted {
+		t.Fatalf("start network: %+v", job)
+	}
+
+	if _, got := ts.adapters(t, user, "hv1-VM2"); got["LAN"] != control.DefaultSwitch() ||
+		got["Network Adapter"] != "" {
+		t.Errorf("adapters after start with default switch = %v", got)
+	}
+
+	// коммутатор сервера подключается к выбранному адаптеру
+	if code, body := ts.do(t, adminUser, "PUT", "/servers/hv1/hv1-VM2/switch",
+		map[string]string{"switch_name": " " + lan + " "}); code != http.StatusOK {
+		t.Fatalf("set switch: %d %s", code, body)
+	}
+
+	if name, _ := ts.adapters(t, user, "hv1-VM2"); name != lan {
+		t.Errorf("switch of server = %q, want %q", name, lan)
+	}
+
+	if job := ts.control(t, user, vm2, "start_network", "Network Adapter"); job.State != model.JobCompleted {
+		t.Fatalf("start network: %+v", job)
+	}
+
+	if _, got := ts.adapters(t, user, "hv1-VM2"); got["LAN"] != control.DefaultSwitch() ||
+		got["Network Adapter"] != lan {
+		t.Errorf("adapters after start with server switch = %v", got)
+	}
+
+	// пустое имя возвращает коммутатор по умолчанию
+	if code, body := ts.do(t, adminUser, "PUT", "/servers/hv1/hv1-VM2/switch",
+		map[string]string{"switch_name": ""}); code != http.StatusOK {
+		t.Fatalf("reset switch: %d %s", code, body)
+	}
+
+	if name, _ := ts.adapters(t, user, "hv1-VM2"); name != control.DefaultSwitch() {
+		t.Errorf("switch of server after reset = %q, want default", name)
+	}
+
+	if job := ts.control(t, user, vm2, "start_network", ""); job.State != model.JobCompleted {
+		t.Fatalf("start network: %+v", job)
+	}
+
+	if _, got := ts.adapters(t, user, "hv1-VM2"); got["LAN"] != control.DefaultSwitch() ||
+		got["Network Adapter"] != control.DefaultSwitch() {
+		t.Errorf("adapters after start of all adapters = %v", got)
+	}
+}
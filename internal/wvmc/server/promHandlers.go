@@ -0,0 +1,157 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/prom"
+	"github.com/gorilla/mux"
+)
+
+// Метрики HTTP запросов по шаблону пути, например /servers/{hv}/{name}
+var (
+	httpRequests = prom.NewCounterVec("wvmc_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration = prom.NewHistogramVec("wvmc_http_request_duration_seconds",
+		"Duration of HTTP requests by route and method.", prom.DefaultBuckets, "route", "method")
+)
+
+// statusWriter запоминает код ответа
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush нужен потоку /servers/stream
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// unmatchedRoute метка запросов без маршрута. Путь запроса в метку не попадает, иначе каждый
+// несуществующий путь создавал бы новый ряд значений.
+const unmatchedRoute = "unmatched"
+
+// Instrument учитывает количество и длительность запросов в метриках /metrics
+func (s *Server) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := unmatchedRoute
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(sw, r)
+
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+
+		httpRequests.Inc(route, r.Method, strconv.Itoa(sw.code))
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// MetricsAccess пропускает к /metrics запросы с токеном METRICS_TOKEN в заголовке Authorization: Bearer
+// и запросы с адресов из METRICS_ALLOW_IPS (IP или подсети через запятую).
+// Если не задано ни то, ни другое, метрики недоступны.
+func (s *Server) MetricsAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("METRICS_TOKEN")
+		allowIPs := os.Getenv("METRICS_ALLOW_IPS")
+
+		if token == "" && allowIPs == "" {
+			SendErr(w, http.StatusForbidden, errors.New("metrics are disabled"), "Метрики отключены")
+			return
+		}
+
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if allowedIP(r.RemoteAddr, allowIPs) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logit.Info("Запрет доступа к метрикам", r.RemoteAddr)
+		SendErr(w, http.StatusForbidden, errors.New("access to metrics is denied"), "Доступ запрещен")
+	})
+}
+
+// allowedIP проверяет, что адрес remoteAddr входит в список IP и подсетей allowIPs через запятую
+func allowedIP(remoteAddr, allowIPs string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, item := range strings.Split(allowIPs, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if _, network, err := net.ParseCIDR(item); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+
+			continue
+		}
+
+		if allowed := net.ParseIP(item); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetPrometheusMetrics отдает метрики wvmc в текстовом формате Prometheus
+func (s *Server) GetPrometheusMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", prom.ContentType)
+
+		if err := prom.Default.Write(w); err != nil {
+			logit.Log("Не удалось отправить метрики", err)
+		}
+	}
+}
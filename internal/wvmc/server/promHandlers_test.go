@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentRouteLabel(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+
+	if code, body := ts.do(t, adminUser, "GET", "/hypervisors", nil); code != http.StatusOK {
+		t.Fatalf("GET /hypervisors: %d %s", code, body)
+	}
+
+	if code, _ := ts.do(t, adminUser, "GET", "/no-such-route/secret-123", nil); code != http.StatusNotFound {
+		t.Fatalf("unknown route: got %d, want 404", code)
+	}
+
+	if code, _ := ts.do(t, adminUser, "DELETE", "/signin", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong method: got %d, want 405", code)
+	}
+
+	t.Setenv("METRICS_TOKEN", "metrics-token")
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer metrics-token")
+
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: %d %s", w.Code, w.Body)
+	}
+
+	out := w.Body.String()
+
+	for _, want := range []string{
+		`wvmc_http_requests_total{route="/hypervisors",method="GET",code="200"}`,
+		`wvmc_http_requests_total{route="unmatched",method="GET",code="404"}`,
+		`wvmc_http_requests_total{route="unmatched",method="DELETE",code="405"}`,
+		`wvmc_http_request_duration_seconds_count{route="/servers/update",method="POST"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics have no %s", want)
+		}
+	}
+
+	// путь запроса без маршрута не попадает в метки
+	if strings.Contains(out, "secret-123") {
+		t.Errorf("metrics contain the path of an unmatched request")
+	}
+}
+
+func TestMetricsAccess(t *testing.T) {
+	s := &Server{}
+	next := s.MetricsAccess(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		token      string
+		allowIPs   string
+		remoteAddr string
+		auth       string
+		want       int
+	}{
+		{"disabled", "", "", "127.0.0.1:1234", "", http.StatusForbidden},
+		{"disabled ignores any token", "", "", "127.0.0.1:1234", "Bearer ", http.StatusForbidden},
+		{"valid token", "secret", "", "10.0.0.1:1234", "Bearer secret", http.StatusOK},
+		{"wrong token", "secret", "", "10.0.0.1:1234", "Bearer other", http.StatusForbidden},
+		{"no token", "secret", "", "10.0.0.1:1234", "", http.StatusForbidden},
+		{"token without bearer", "secret", "", "10.0.0.1:1234", "secret", http.StatusOK},
+		{"allowed ip", "", "10.0.0.1, 192.168.1.0/24", "10.0.0.1:1234", "", http.StatusOK},
+		{"allowed subnet", "", "10.0.0.1, 192.168.1.0/24", "192.168.1.77:1234", "", http.StatusOK},
+		{"denied ip", "", "10.0.0.1, 192.168.1.0/24", "10.0.0.2:1234", "", http.StatusForbidden},
+		{"wrong token from allowed ip", "secret", "10.0.0.1", "10.0.0.1:1234", "Bearer other", http.StatusOK},
+		{"wrong token from denied ip", "secret", "10.0.0.1", "10.0.0.2:1234", "Bearer other",
+			http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("METRICS_TOKEN", tt.token)
+			t.Setenv("METRICS_ALLOW_IPS", tt.allowIPs)
+
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+
+			w := httptest.NewRecorder()
+			next.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("code = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedIP(t *testing.T) {
+	const allow = " 10.0.0.1 ,192.168.0.0/16,, ::1, fd00::/8, not-an-ip"
+
+	tests := map[string]bool{
+		"10.0.0.1:80":       true,
+		"10.0.0.1":          true,
+		"10.0.0.10:80":      false,
+		"192.168.200.1:80":  true,
+		"192.169.0.1:80":    false,
+		"[::1]:80":          true,
+		"[fd00::5]:80":      true,
+		"[fe80::1]:80":      false,
+		"not-an-ip:80":      false,
+		"":                  false,
+		"::ffff:10.0.0.1":   true,
+		"[::ffff:10.0.0.2]": false,
+	}
+
+	for addr, want := range tests {
+		if got := allowedIP(addr, allow); got != want {
+			t.Errorf("allowedIP(%q) = %v, want %v", addr, got, want)
+		}
+	}
+
+	if allowedIP("10.0.0.1:80", "") {
+		t.Errorf("allowedIP with an empty list = true")
+	}
+}
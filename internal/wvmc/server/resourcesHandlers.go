@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// GetServerResources возвращает процессор и память сервера
+func (s *Server) GetServerResources() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		server := r.Context().Value(CtxString("server")).(model.Server)
+
+		resources, err := s.controlService.GetServerResources(r.Context(), server)
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err,
+				"Ошибка получения процессора и памяти")
+			return
+		}
+
+		SendOK(w, http.StatusOK, resources)
+	}
+}
+
+// SetServerResources изменяет процессор и память сервера
+func (s *Server) SetServerResources() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+		server := r.Context().Value(CtxString("server")).(model.Server)
+
+		var req control.ResourcesUpdate
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		before, after, err := s.controlService.SetServerResources(r.Context(), server, req)
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err,
+				"Ошибка изменения процессора и памяти")
+			return
+		}
+
+		if diff := before.Diff(after); len(diff) > 0 {
+			details := strings.Join(diff, ", ")
+
+			s.auditAction(r, user, server, "set_resources", details)
+			s.notifyAction(user, server, "set_resources "+details)
+		}
+
+		SendOK(w, http.StatusOK, after)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anaxita/wvmc/internal/wvmc/control"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func TestServerResourcesHandlers(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1", "hv1-VM3")
+
+	tests := []struct {
+		name   string
+		user   model.User
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"user reads own server", user, "GET", "/servers/hv1/hv1-VM1/resources", nil, http.StatusOK},
+		{"user reads other server", user, "GET", "/servers/hv1/hv1-VM2/resources", nil, http.StatusForbidden},
+		{"unknown server", adminUser, "GET", "/servers/hv1/hv1-VM9/resources", nil, http.StatusNotFound},
+		{"no token", model.User{}, "GET", "/servers/hv1/hv1-VM1/resources", nil, http.StatusUnauthorized},
+		{"user changes own server", user, "PATCH", "/servers/hv1/hv1-VM3/resources",
+			map[string]int{"cpu_cores": 4}, http.StatusForbidden},
+		{"invalid json", adminUser, "PATCH", "/servers/hv1/hv1-VM3/resources", "x", http.StatusBadRequest},
+		{"invalid memory", adminUser, "PATCH", "/servers/hv1/hv1-VM3/resources",
+			map[string]int{"memory_startup": 33}, http.StatusBadRequest},
+		{"no processors", adminUser, "PATCH", "/servers/hv1/hv1-VM3/resources",
+			map[string]int{"cpu_cores": 0}, http.StatusBadRequest},
+		{"processors of running server", adminUser, "PATCH", "/servers/hv1/hv1-VM1/resources",
+			map[string]int{"cpu_cores": 4}, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, tt.method, tt.path, tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	if n := len(ts.notices.sent()); n != 0 {
+		t.Fatalf("rejected requests sent %d notifications", n)
+	}
+
+	code, body := ts.do(t, adminUser, "PATCH", "/servers/hv1/hv1-VM3/resources", map[string]int{"cpu_cores": 4})
+	if code != http.StatusOK {
+		t.Fatalf("set resources: %d %s", code, body)
+	}
+
+	var after control.Resources
+	if decode(t, body, &after); after.CPUCores != 4 {
+		t.Errorf("resources after change = %+v", after)
+	}
+
+	_, body = ts.do(t, user, "GET", "/servers/hv1/hv1-VM3/resources", nil)
+
+	var got control.Resources
+	if decode(t, body, &got); got != after {
+		t.Errorf("resources = %+v, want %+v", got, after)
+	}
+
+	notices := ts.notices.sent()
+	if len(notices) != 1 || !strings.Contains(notices[0], "cpu_cores") {
+		t.Errorf("notifications = %q", notices)
+	}
+
+	// без изменений уведомление не отправляется
+	if code, body = ts.do(t, adminUser, "PATCH", "/servers/hv1/hv1-VM3/resources",
+		map[string]int{"cpu_cores": 4}); code != http.StatusOK {
+		t.Fatalf("set same resources: %d %s", code, body)
+	}
+
+	if n := len(ts.notices.sent()); n != 1 {
+		t.Errorf("notifications after no-op change = %d, want 1", n)
+	}
+}
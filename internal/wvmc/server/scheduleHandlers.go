@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/anaxita/wvmc/internal/wvmc/schedule"
+	"github.com/gorilla/mux"
+)
+
+// Ошибки проверки прав на сервер расписания
+var (
+	errServerNotFound = errors.New("server is not found")
+	errNoServerAccess = errors.New("user has no access to the server")
+)
+
+// scheduleRequest расписание из запроса на создание или изменение
+type scheduleRequest struct {
+	ServerID int64  `json:"server_id"`
+	Command  string `json:"command"`
+	Adapter  string `json:"adapter"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone"`
+	Missed   string `json:"missed"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+// GetSchedules возвращает расписания пользователя, администратору - расписания всех пользователей
+func (s *Server) GetSchedules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		userID := user.ID
+		if user.Role == model.UserRoleAdmin {
+			userID = ""
+		}
+
+		result, err := s.store.Schedule(r.Context()).FindByUser(userID)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, result)
+	}
+}
+
+// GetSchedule возвращает расписание с временем следующего и последнего выполнения
+func (s *Server) GetSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sc, ok := s.findSchedule(w, r)
+		if !ok {
+			return
+		}
+
+		SendOK(w, http.StatusOK, sc)
+	}
+}
+
+// CreateSchedule создает расписание команды /servers/control на сервере, которым может управлять
+// пользователь. Команда выполняется от имени пользователя и с его уведомлениями.
+func (s *Server) CreateSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		sc, ok := s.scheduleFromRequest(w, r, user)
+		if !ok {
+			return
+		}
+
+		sc.UserID = user.ID
+
+		id, err := s.store.Schedule(r.Context()).Create(sc)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		sc, err = s.store.Schedule(r.Context()).Find(id)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusCreated, sc)
+	}
+}
+
+// EditSchedule заменяет команду, сервер, выражение cron и настройки расписания,
+// следующее выполнение считается от текущего времени
+func (s *Server) EditSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		current, ok := s.findSchedule(w, r)
+		if !ok {
+			return
+		}
+
+		sc, ok := s.scheduleFromRequest(w, r, user)
+		if !ok {
+			return
+		}
+
+		sc.ID = current.ID
+
+		if err := s.store.Schedule(r.Context()).Update(sc); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		sc, err := s.store.Schedule(r.Context()).Find(sc.ID)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, sc)
+	}
+}
+
+// DeleteSchedule удаляет расписание вместе с историей выполнений
+func (s *Server) DeleteSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sc, ok := s.findSchedule(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.store.Schedule(r.Context()).Delete(sc.ID); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, "Расписание удалено")
+	}
+}
+
+// GetScheduleRuns возвращает выполнения расписания, начиная с последнего. Количество ограничивается
+// параметром limit.
+func (s *Server) GetScheduleRuns() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sc, ok := s.findSchedule(w, r)
+		if !ok {
+			return
+		}
+
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil {
+				SendErr(w, http.StatusBadRequest, err, "Неверное значение limit")
+				return
+			}
+		}
+
+		runs, err := s.store.Schedule(r.Context()).Runs(sc.ID, limit)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, runs)
+	}
+}
+
+// scheduleFromRequest читает расписание из запроса и проверяет команду, выражение cron, часовой пояс
+// и права пользователя user на сервер. При ошибке отправляет ответ и возвращает false.
+func (s *Server) scheduleFromRequest(w http.ResponseWriter, r *http.Request,
+	user model.User) (model.Schedule, bool) {
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendErr(w, http.StatusBadRequest, err, "невалидный json")
+		return model.Schedule{}, false
+	}
+
+	sc := model.Schedule{
+		ServerID: req.ServerID,
+		Command:  req.Command,
+		Adapter:  strings.TrimSpace(req.Adapter),
+		Cron:     strings.Join(strings.Fields(req.Cron), " "),
+		Timezone: strings.TrimSpace(req.Timezone),
+		Missed:   req.Missed,
+		Enabled:  req.Enabled == nil || *req.Enabled,
+	}
+
+	if sc.ServerID == 0 || sc.Command == "" || sc.Cron == "" {
+		SendErr(w, http.StatusBadRequest, errors.New("server_id, command and cron cannot be empty"),
+			"server_id, command и cron не могут быть пустыми")
+		return sc, false
+	}
+
+	if sc.Timezone == "" {
+		sc.Timezone = schedule.DefaultTimezone
+	}
+
+	if sc.Missed == "" {
+		sc.Missed = model.ScheduleMissedSkip
+	}
+
+	if sc.Missed != model.ScheduleMissedSkip && sc.Missed != model.ScheduleMissedRunOnce {
+		SendErr(w, http.StatusBadRequest, errors.New("missed must be skip or run_once"),
+			"missed должен быть skip или run_once")
+		return sc, false
+	}
+
+	if s.controlCommand(model.Server{}, sc.Command, sc.Adapter) == nil {
+		SendErr(w, http.StatusBadRequest, errors.New("incorrect command"), "Неизвестная команда")
+		return sc, false
+	}
+
+	next, err := schedule.NextRun(sc.Cron, sc.Timezone, time.Now())
+	if err != nil {
+		SendErr(w, http.StatusBadRequest, err, "Неверное выражение cron или часовой пояс")
+		return sc, false
+	}
+
+	sc.NextRunAt = &next
+
+	if _, err = s.controlledServer(r.Context(), user, sc.ServerID); err != nil {
+		switch {
+		case errors.Is(err, errServerNotFound):
+			SendErr(w, http.StatusNotFound, err, "Сервер не найден")
+		case errors.Is(err, errNoServerAccess):
+			SendErr(w, http.StatusForbidden, err, "Доступ запрещен")
+		default:
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+		}
+
+		return sc, false
+	}
+
+	return sc, true
+}
+
+// controlledServer возвращает сервер serverID, если пользователь user может им управлять
+func (s *Server) controlledServer(ctx context.Context, user model.User, serverID int64) (model.Server, error) {
+	server, err := s.store.Server(ctx).Find("id", serverID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return server, errServerNotFound
+		}
+
+		return server, err
+	}
+
+	if user.Role != model.UserRoleAdmin {
+		if _, err = s.findUserServer(ctx, user, serverID); err != nil {
+			if err == sql.ErrNoRows {
+				return server, errNoServerAccess
+			}
+
+			return server, err
+		}
+	}
+
+	return server, nil
+}
+
+// runSchedule запускает команду расписания sc как задание тем же путем, что и /servers/control:
+// от имени автора расписания, с проверкой его прав на сервер и уведомлением
+func (s *Server) runSchedule(ctx context.Context, sc model.Schedule) (int64, error) {
+	user, err := s.store.User(ctx).Find("id", sc.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("schedule owner is not found")
+		}
+
+		return 0, err
+	}
+
+	server, err := s.controlledServer(ctx, user, sc.ServerID)
+	if err != nil {
+		return 0, err
+	}
+
+	command, run := s.controlJob(user, server, sc.Command, sc.Adapter)
+	if run == nil {
+		return 0, errors.New("incorrect command")
+	}
+
+	job, err := s.jobs.Submit(ctx, model.Job{
+		UserID:    user.ID,
+		UserEmail: user.Email,
+		Kind:      "schedule",
+		Command:   command,
+		HV:        server.HV,
+		Server:    server.Name,
+	}, run)
+
+	return job.ID, err
+}
+
+// findSchedule ищет расписание из пути запроса среди расписаний пользователя, администратор видит
+// все расписания. При ошибке отправляет ответ и возвращает false.
+func (s *Server) findSchedule(w http.ResponseWriter, r *http.Request) (model.Schedule, bool) {
+	user := r.Context().Value(CtxString("user")).(model.User)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		SendErr(w, http.StatusBadRequest, err, "Неверный ID расписания")
+		return model.Schedule{}, false
+	}
+
+	sc, err := s.store.Schedule(r.Context()).Find(id)
+	if err == nil && user.Role != model.UserRoleAdmin && sc.UserID != user.ID {
+		err = sql.ErrNoRows
+	}
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			SendErr(w, http.StatusNotFound, errors.New("schedule is not found"), "Расписание не найдено")
+			return sc, false
+		}
+
+		SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+		return sc, false
+	}
+
+	return sc, true
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func TestScheduleOwnership(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+
+	vm1, vm2 := ts.server(t, "hv1-VM1"), ts.server(t, "hv1-VM2")
+	owner := ts.createUser(t, "owner@example.com", model.UserRoleUser, "hv1-VM1")
+	other := ts.createUser(t, "other@example.com", model.UserRoleUser, "hv1-VM1")
+
+	create := []struct {
+		name string
+		body map[string]interface{}
+		want int
+	}{
+		{"server without access", map[string]interface{}{"server_id": vm2.ID, "command": "stop_power",
+			"cron": "0 22 * * *"}, http.StatusForbidden},
+		{"unknown server", map[string]interface{}{"server_id": 9999, "command": "stop_power", "cron": "0 22 * * *"},
+			http.StatusNotFound},
+		{"invalid cron", map[string]interface{}{"server_id": vm1.ID, "command": "stop_power", "cron": "0 25 * * *"},
+			http.StatusBadRequest},
+		{"unknown timezone", map[string]interface{}{"server_id": vm1.ID, "command": "stop_power",
+			"cron": "0 22 * * *", "timezone": "Mars/Olympus"}, http.StatusBadRequest},
+		{"invalid missed", map[string]interface{}{"server_id": vm1.ID, "command": "stop_power", "cron": "0 22 * * *",
+			"missed": "always"}, http.StatusBadRequest},
+		{"unknown command", map[string]interface{}{"server_id": vm1.ID, "command": "format", "cron": "0 22 * * *"},
+			http.StatusBadRequest},
+	}
+
+	for _, tt := range create {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, owner, "POST", "/schedules", tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	code, body := ts.do(t, owner, "POST", "/schedules", map[string]interface{}{"server_id": vm1.ID,
+		"command": "stop_power", "cron": "0  22 * * mon-fri", "timezone": "Europe/Moscow"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: %d %s", code, body)
+	}
+
+	var sc model.Schedule
+	decode(t, body, &sc)
+
+	if sc.UserID != owner.ID || sc.Cron != "0 22 * * mon-fri" || sc.Missed != model.ScheduleMissedSkip ||
+		!sc.Enabled || sc.NextRunAt == nil || sc.Server != "hv1-VM1" {
+		t.Errorf("created schedule = %+v", sc)
+	}
+
+	path := "/schedules/" + strconv.FormatInt(sc.ID, 10)
+	edit := map[string]interface{}{"server_id": vm1.ID, "command": "start_power", "cron": "0 8 * * *"}
+
+	access := []struct {
+		name   string
+		user   model.User
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"other user reads", other, "GET", path, nil, http.StatusNotFound},
+		{"other user edits", other, "PUT", path, edit, http.StatusNotFound},
+		{"other user reads runs", other, "GET", path + "/runs", nil, http.StatusNotFound},
+		{"other user deletes", other, "DELETE", path, nil, http.StatusNotFound},
+		{"admin reads", adminUser, "GET", path, nil, http.StatusOK},
+		{"owner reads", owner, "GET", path, nil, http.StatusOK},
+		{"owner reads runs", owner, "GET", path + "/runs", nil, http.StatusOK},
+		{"owner edits", owner, "PUT", path, edit, http.StatusOK},
+		{"owner moves to server without access", owner, "PUT", path,
+			map[string]interface{}{"server_id": vm2.ID, "command": "start_power", "cron": "0 8 * * *"},
+			http.StatusForbidden},
+		{"invalid id", owner, "GET", "/schedules/x", nil, http.StatusBadRequest},
+		{"no token", model.User{}, "GET", path, nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range access {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, tt.method, tt.path, tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	var list []model.Schedule
+
+	_, body = ts.do(t, other, "GET", "/schedules", nil)
+	if decode(t, body, &list); len(list) != 0 {
+		t.Errorf("schedules of other user = %+v", list)
+	}
+
+	_, body = ts.do(t, adminUser, "GET", "/schedules", nil)
+	if decode(t, body, &list); len(list) != 1 || list[0].Command != "start_power" {
+		t.Errorf("schedules for admin = %+v", list)
+	}
+
+	if code, body = ts.do(t, owner, "DELETE", path, nil); code != http.StatusOK {
+		t.Fatalf("delete: %d %s", code, body)
+	}
+
+	if code, _ = ts.do(t, owner, "GET", path, nil); code != http.StatusNotFound {
+		t.Errorf("deleted schedule: got %d, want 404", code)
+	}
+}
+
+func TestRunScheduleChecksOwner(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+	ctx := context.Background()
+
+	vm := ts.server(t, "hv1-VM1")
+	owner := ts.createUser(t, "owner@example.com", model.UserRoleUser, "hv1-VM1")
+
+	sc := model.Schedule{ID: 1, UserID: owner.ID, ServerID: vm.ID, Command: "stop_power"}
+
+	jobID, err := ts.runSchedule(ctx, sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// команда выполняется от имени автора расписания с уведомлением
+	job := ts.waitJob(t, jobID)
+	if job.State != model.JobCompleted || job.Kind != "schedule" || job.UserID != owner.ID ||
+		job.Server != "hv1-VM1" {
+		t.Errorf("job = %+v", job)
+	}
+
+	if notices := ts.notices.sent(); len(notices) != 1 || !strings.Contains(notices[0], "owner@example.com") {
+		t.Errorf("notifications = %q", notices)
+	}
+
+	// доступ к серверу отозван после создания расписания
+	if err = ts.store.Server(ctx).DeleteByUser(owner.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ts.runSchedule(ctx, sc); !errors.Is(err, errNoServerAccess) {
+		t.Errorf("run without access: got %v, want errNoServerAccess", err)
+	}
+
+	sc.ServerID = 9999
+	if _, err = ts.runSchedule(ctx, sc); !errors.Is(err, errServerNotFound) {
+		t.Errorf("run of deleted server: got %v, want errServerNotFound", err)
+	}
+
+	if err = ts.store.User(ctx).Delete(owner.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	sc.ServerID = vm.ID
+	if _, err = ts.runSchedule(ctx, sc); err == nil || !strings.Contains(err.Error(), "owner is not found") {
+		t.Errorf("run of deleted owner: got %v", err)
+	}
+
+	// администратор управляет любым сервером
+	admin := ts.createUser(t, "admin@example.com", model.UserRoleAdmin)
+	sc = model.Schedule{ID: 2, UserID: admin.ID, ServerID: ts.server(t, "hv1-VM2").ID, Command: "unknown"}
+
+	if _, err = ts.runSchedule(ctx, sc); err == nil || !strings.Contains(err.Error(), "incorrect command") {
+		t.Errorf("run of unknown command: got %v", err)
+	}
+
+	sc.Command = "stop_power"
+	if jobID, err = ts.runSchedule(ctx, sc); err != nil {
+		t.Fatal(err)
+	}
+
+	if job = ts.waitJob(t, jobID); job.State != model.JobCompleted {
+		t.Errorf("admin job = %+v", job)
+	}
+}
@@ -1,13 +1,17 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/cache"
+	"github.com/anaxita/wvmc/internal/wvmc/jobs"
 	"github.com/anaxita/wvmc/internal/wvmc/model"
 	"github.com/gorilla/mux"
+	"io"
 	"net"
 	"net/http"
 	"os"
@@ -16,8 +20,12 @@ import (
 
 // GetServers возвращает список серверов
 func (s *Server) GetServers() http.HandlerFunc {
+	// Stale и Age - устарели ли данные какого-либо гипервизора и возраст самых старых данных в секундах
 	type response struct {
-		Servers []model.Server `json:"servers"`
+		Servers []model.Server   `json:"servers"`
+		Stale   bool             `json:"stale"`
+		Age     int64            `json:"age"`
+		Hosts   []cache.HostInfo `json:"hosts,omitempty"`
 	}
 
 	var adminRole = 1
@@ -34,9 +42,9 @@ func (s *Server) GetServers() http.HandlerFunc {
 		}
 
 		if user.Role == adminRole {
-			vms, err := s.controlService.GetServersDataForAdmins()
+			vms, err := s.controlService.GetServersDataForAdmins(r.Context())
 			if err != nil {
-				SendErr(w, http.StatusOK, err, "Ошибка получения статусов")
+				SendCommandErr(w, http.StatusOK, err, "Ошибка получения статусов")
 				return
 			}
 
@@ -54,12 +62,23 @@ func (s *Server) GetServers() http.HandlerFunc {
 						vms[k].Description = srv.Description
 						vms[k].OutAddr = srv.OutAddr
 						vms[k].IP = srv.IP
+						vms[k].Switch = srv.Switch
 
 						break
 					}
 				}
 			}
-			SendOK(w, http.StatusOK, response{vms})
+
+			hosts, err := s.controlService.ServersCacheInfo(r.Context())
+			if err != nil {
+				SendErr(w, http.StatusOK, err, "Ошибка получения списка серверов")
+				return
+			}
+
+			resp := response{Servers: vms, Hosts: hosts}
+			resp.Stale, resp.Age = staleness(hosts, nil)
+
+			SendOK(w, http.StatusOK, resp)
 			return
 		}
 
@@ -67,7 +86,7 @@ func (s *Server) GetServers() http.HandlerFunc {
 			servers, err := s.store.Server(r.Context()).FindByUser(user.ID)
 			if err != nil {
 				if err == sql.ErrNoRows {
-					SendOK(w, http.StatusOK, response{make([]model.Server, 0)})
+					SendOK(w, http.StatusOK, response{Servers: make([]model.Server, 0)})
 					return
 				}
 
@@ -75,15 +94,15 @@ func (s *Server) GetServers() http.HandlerFunc {
 				return
 			}
 
-			vms, err := s.controlService.GetServersDataForUsers(servers)
+			vms, err := s.controlService.GetServersDataForUsers(r.Context(), servers)
 			if err != nil {
-				SendErr(w, http.StatusInternalServerError, err, "Ошибка получения статусов")
+				SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка получения статусов")
 				return
 			}
 
 			for k, v := range vms {
 				for _, srv := range servers {
-					if srv.VMID == v.VMID && srv.HV == v.HV {
+					if srv.VMID == v.VMID && strings.EqualFold(srv.HV, v.HV) {
 						vms[k].ID = srv.ID
 						vms[k].Company = srv.Company
 						vms[k].Description = srv.Description
@@ -95,10 +114,49 @@ func (s *Server) GetServers() http.HandlerFunc {
 				}
 			}
 
-			SendOK(w, http.StatusOK, response{vms})
+			hosts, err := s.controlService.ServersCacheInfo(r.Context())
+			if err != nil {
+				SendErr(w, http.StatusInternalServerError, err, "Ошибка получения статусов")
+				return
+			}
+
+			resp := response{Servers: vms}
+			resp.Stale, resp.Age = staleness(hosts, servers)
+
+			SendOK(w, http.StatusOK, resp)
+		}
+
+	}
+}
+
+// staleness возвращает, устарели ли данные какого-либо из гипервизоров hosts, и возраст самых старых
+// данных в секундах. Если servers не nil, учитываются только гипервизоры этих серверов.
+func staleness(hosts []cache.HostInfo, servers []model.Server) (bool, int64) {
+	var stale bool
+	var age int64
+
+	for _, h := range hosts {
+		if servers != nil && !hasServerOnHost(servers, h.HV) {
+			continue
+		}
+
+		stale = stale || h.Stale
+		if h.Age > age {
+			age = h.Age
 		}
+	}
+
+	return stale, age
+}
 
+func hasServerOnHost(servers []model.Server, hv string) bool {
+	for _, v := range servers {
+		if strings.EqualFold(v.HV, hv) {
+			return true
+		}
 	}
+
+	return false
 }
 
 // GetServer получает информацию об 1 сервере по его хв и имени
@@ -121,9 +179,9 @@ func (s *Server) GetServer() http.HandlerFunc {
 			return
 		}
 
-		vmInfo, err := s.controlService.GetServerData(server, hv, name)
+		vmInfo, err := s.controlService.GetServerData(r.Context(), server, hv, name)
 		if err != nil {
-			SendErr(w, http.StatusOK, err, "can't to get vm info")
+			SendCommandErr(w, http.StatusOK, err, "can't to get vm info")
 			return
 		}
 
@@ -132,68 +190,155 @@ func (s *Server) GetServer() http.HandlerFunc {
 	}
 }
 
-// ControlServer выполняет команды на сервере
-func (s *Server) ControlServer() http.HandlerFunc {
-
-	const notice = `
+// actionNotice шаблон уведомления о действии пользователя с сервером
+const actionNotice = `
 User: %s %s %s
 Server: %s
 HV: %s
 Action: %s
 `
 
+// notifyAction отправляет уведомление о действии action пользователя user с сервером server
+func (s *Server) notifyAction(user model.User, server model.Server, action string) {
+	err := s.notify.Notify(fmt.Sprintf(actionNotice, user.Email, user.Name, user.Company,
+		server.Name, server.HV, action))
+	if err != nil {
+		logit.Log("Не удалось отправить уведомление", err)
+	}
+}
+
+// auditAction записывает действие action пользователя user с сервером server в журнал изменений
+func (s *Server) auditAction(r *http.Request, user model.User, server model.Server, action,
+	details string) {
+	_, err := s.store.Audit(r.Context()).Create(model.AuditRecord{
+		UserID:    user.ID,
+		UserEmail: user.Email,
+		HV:        server.HV,
+		Server:    server.Name,
+		Action:    action,
+		Details:   details,
+	})
+	if err != nil {
+		logit.Log("Не удалось записать действие в журнал", action, err)
+	}
+}
+
+// ControlServer запускает команду на сервере как задание и сразу возвращает его
+func (s *Server) ControlServer() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value(CtxString("user")).(model.User)
 
-		var err error
-
 		server := r.Context().Value(CtxString("server")).(model.Server)
 		command := r.Context().Value(CtxString("command")).(string)
+		adapter := r.Context().Value(CtxString("adapter")).(string)
 
-		switch command {
-		case "start_power":
-			_, err = s.controlService.StartServer(server)
-		case "stop_power":
-			_, err = s.controlService.StopServer(server)
-
-		case "stop_power_force":
-			_, err = s.controlService.StopServerForce(server)
-
-		case "start_network":
-			_, err = s.controlService.StartServerNetwork(server)
-
-		case "stop_network":
-			_, err = s.controlService.StopServerNetwork(server)
-		default:
+		command, run := s.controlJob(user, server, command, adapter)
+		if run == nil {
 			SendErr(w, http.StatusBadRequest, errors.New("incorrect command"),
 				"Неизвестная команда")
 			return
 		}
 
-		if err != nil {
-			SendErr(w, http.StatusInternalServerError, err, "Ошибка выполнения команды")
-			return
+		s.submitJob(w, r, user, server, "control", command, run)
+	}
+}
+
+// controlJob возвращает название команды command для задания и ее выполнение на сервере server
+// с уведомлением о действии пользователя user. Если команда неизвестна, выполнение равно nil.
+func (s *Server) controlJob(user model.User, server model.Server, command, adapter string) (string, jobs.Func) {
+	run := s.controlCommand(server, command, adapter)
+	if run == nil {
+		return command, nil
+	}
+
+	if adapter != "" {
+		command = fmt.Sprintf("%s %s", command, adapter)
+	}
+
+	return command, func(ctx context.Context) ([]byte, error) {
+		out, err := run(ctx)
+		if err == nil {
+			s.notifyAction(user, server, command)
 		}
 
-		err = s.notify.Notify(fmt.Sprintf(notice, user.Email, user.Name, user.Company, server.Name,
-			server.HV, command))
-		if err != nil {
-			logit.Log("Не удалось отправить уведомление", err)
+		return out, err
+	}
+}
+
+// controlCommand возвращает выполнение команды управления питанием или сетью command на сервере server,
+// если команда неизвестна - nil
+func (s *Server) controlCommand(server model.Server, command, adapter string) jobs.Func {
+	switch command {
+	case "start_power":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.StartServer(ctx, server)
+		}
+	case "stop_power":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.StopServer(ctx, server)
 		}
 
-		SendOK(w, http.StatusOK, "Команда выполнена успешно")
+	case "stop_power_force":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.StopServerForce(ctx, server)
+		}
+	case "restart_power":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.RestartServer(ctx, server)
+		}
+	case "reset_power":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.ResetServer(ctx, server)
+		}
+	case "save_power":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.SaveServer(ctx, server)
+		}
+	case "pause_power":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.PauseServer(ctx, server)
+		}
+	case "resume_power":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.ResumeServer(ctx, server)
+		}
+
+	case "start_network":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.StartServerNetwork(ctx, server, adapter)
+		}
+
+	case "stop_network":
+		return func(ctx context.Context) ([]byte, error) {
+			return s.controlService.StopServerNetwork(ctx, server, adapter)
+		}
 	}
+
+	return nil
 }
 
-// UpdateAllServersInfo обновляет данные в БД по серверам
+// UpdateAllServersInfo запрашивает ВМ с гипервизоров hvs, если они не переданы - со всех гипервизоров,
+// обновляет кеш и добавляет новые серверы в БД
 func (s *Server) UpdateAllServersInfo() http.HandlerFunc {
+	type request struct {
+		HVs []string `json:"hvs"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := os.Getenv("SERVER_USER_NAME")
 		password := os.Getenv("SERVER_USER_PASSWORD")
 
-		servers, err := s.controlService.GetServersDataForAdmins()
+		var req request
+		if r.Body != nil {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				SendErr(w, http.StatusBadRequest, err, "невалидный json")
+				return
+			}
+		}
+
+		servers, err := s.controlService.RefreshServers(r.Context(), req.HVs)
 		if err != nil {
-			SendErr(w, http.StatusInternalServerError, err, "Ошибка powershell")
+			SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка powershell")
 			return
 		}
 
@@ -207,6 +352,8 @@ func (s *Server) UpdateAllServersInfo() http.HandlerFunc {
 				return
 			}
 		}
+
+		SendOK(w, http.StatusOK, "Список серверов обновлен")
 	}
 }
 
@@ -228,9 +375,10 @@ func (s *Server) GetServerServices() http.HandlerFunc {
 			return
 		}
 
-		services, err := s.controlService.GetServerServices(srv.IP, srv.User, srv.Password)
+		services, err := s.controlService.GetServerServices(r.Context(), srv.IP, srv.User,
+			srv.Password)
 		if err != nil {
-			SendErr(w, http.StatusOK, err, "Ошибка подключения к серверу")
+			SendCommandErr(w, http.StatusOK, err, "Ошибка подключения к серверу")
 			return
 		}
 
@@ -257,9 +405,10 @@ func (s *Server) GetServerManager() http.HandlerFunc {
 			return
 		}
 
-		processes, err := s.controlService.GetProcesses(srv.IP, srv.User, srv.Password)
+		processes, err := s.controlService.GetProcesses(r.Context(), srv.IP, srv.User,
+			srv.Password)
 		if err != nil {
-			SendErr(w, http.StatusOK, err, "Ошибка подключения к серверу")
+			SendCommandErr(w, http.StatusOK, err, "Ошибка подключения к серверу")
 			return
 		}
 
@@ -268,7 +417,7 @@ func (s *Server) GetServerManager() http.HandlerFunc {
 	}
 }
 
-// ControlServerManager control processes and user rdp sessions
+// ControlServerManager control processes and user rdp sessions, the command runs as a job
 func (s *Server) ControlServerManager() http.HandlerFunc {
 
 	type req struct {
@@ -278,6 +427,7 @@ func (s *Server) ControlServerManager() http.HandlerFunc {
 		Command    string `json:"command"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
 		vars := mux.Vars(r)
 		var err error
 		var task req
@@ -297,24 +447,25 @@ func (s *Server) ControlServerManager() http.HandlerFunc {
 			return
 		}
 
+		var run jobs.Func
+
 		switch task.Command {
 		case "stop":
-			_, err = s.controlService.StoptWinProcess(server.IP, server.User, server.Password,
-				task.EntityID)
+			run = func(ctx context.Context) ([]byte, error) {
+				return s.controlService.StoptWinProcess(ctx, server.IP, server.User, server.Password,
+					task.EntityID)
+			}
 		case "disconnect":
-			_, err = s.controlService.DisconnectRDPUser(server.IP, server.User, server.Password,
-				task.EntityID)
+			run = func(ctx context.Context) ([]byte, error) {
+				return s.controlService.DisconnectRDPUser(ctx, server.IP, server.User, server.Password,
+					task.EntityID)
+			}
 		default:
 			SendErr(w, http.StatusBadRequest, errors.New("undefind command"), "Неизвестная команда")
 			return
 		}
 
-		if err != nil {
-			SendErr(w, http.StatusInternalServerError, err, "Ошибка выполнения команды")
-			return
-		}
-
-		SendOK(w, http.StatusOK, "Команда выполнена успешно")
+		s.submitJob(w, r, user, server, "manager", fmt.Sprintf("%s %d", task.Command, task.EntityID), run)
 	}
 }
 
@@ -335,9 +486,10 @@ func (s *Server) GetServerDisks() http.HandlerFunc {
 			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
 			return
 		}
-		disksInfo, err := s.controlService.GetDiskFreeSpace(srv.IP, srv.User, srv.Password)
+		disksInfo, err := s.controlService.GetDiskFreeSpace(r.Context(), srv.IP, srv.User,
+			srv.Password)
 		if err != nil {
-			SendErr(w, http.StatusOK, err, "Ошибка подключения к серверу")
+			SendCommandErr(w, http.StatusOK, err, "Ошибка подключения к серверу")
 			return
 		}
 
@@ -345,7 +497,7 @@ func (s *Server) GetServerDisks() http.HandlerFunc {
 	}
 }
 
-// ControlServerServices управляет службами сервера
+// ControlServerServices запускает управление службой сервера как задание и сразу возвращает его
 func (s *Server) ControlServerServices() http.HandlerFunc {
 
 	type req struct {
@@ -355,6 +507,7 @@ func (s *Server) ControlServerServices() http.HandlerFunc {
 		Command     string `json:"command"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
 		vars := mux.Vars(r)
 		var err error
 		var task req
@@ -374,26 +527,29 @@ func (s *Server) ControlServerServices() http.HandlerFunc {
 			return
 		}
 
+		var run jobs.Func
+
 		switch task.Command {
 		case "start":
-			_, err = s.controlService.StartWinService(server.IP, server.User, server.Password,
-				task.ServiceName)
+			run = func(ctx context.Context) ([]byte, error) {
+				return s.controlService.StartWinService(ctx, server.IP, server.User, server.Password,
+					task.ServiceName)
+			}
 		case "stop":
-			_, err = s.controlService.StopWinService(server.IP, server.User, server.Password,
-				task.ServiceName)
+			run = func(ctx context.Context) ([]byte, error) {
+				return s.controlService.StopWinService(ctx, server.IP, server.User, server.Password,
+					task.ServiceName)
+			}
 		case "restart":
-			_, err = s.controlService.RestartWinService(server.IP, server.User, server.Password,
-				task.ServiceName)
+			run = func(ctx context.Context) ([]byte, error) {
+				return s.controlService.RestartWinService(ctx, server.IP, server.User, server.Password,
+					task.ServiceName)
+			}
 		default:
 			SendErr(w, http.StatusBadRequest, errors.New("undefind command"), "Неизвестная команда")
 			return
 		}
 
-		if err != nil {
-			SendErr(w, http.StatusInternalServerError, err, "Ошибока выполнения команды")
-			return
-		}
-
-		SendOK(w, http.StatusOK, "Команда выполнена успешно")
+		s.submitJob(w, r, user, server, "service", fmt.Sprintf("%s %s", task.Command, task.ServiceName), run)
 	}
 }
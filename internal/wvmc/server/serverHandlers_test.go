@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/cache"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// serversResponse ответ GET /servers
+type serversResponse struct {
+	Servers []model.Server   `json:"servers"`
+	Stale   bool             `json:"stale"`
+	Age     int64            `json:"age"`
+	Hosts   []cache.HostInfo `json:"hosts"`
+}
+
+// servers возвращает список серверов пользователя user
+func (ts *testServer) servers(t *testing.T, user model.User) serversResponse {
+	t.Helper()
+
+	code, body := ts.do(t, user, "GET", "/servers", nil)
+	if code != http.StatusOK {
+		t.Fatalf("servers: %d %s", code, body)
+	}
+
+	var resp serversResponse
+	decode(t, body, &resp)
+
+	return resp
+}
+
+func TestGetServersRestoredCache(t *testing.T) {
+	ts := newTestServer(t, "hv1", "hv2")
+	ctx := context.Background()
+
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv2-VM1")
+	other := ts.createUser(t, "other@example.com", model.UserRoleUser, "hv1-VM1")
+
+	// при последнем сохранении час назад hv2-VM3 была включена
+	saved := ts.cache.HostServers([]string{"hv2"})
+	for k := range saved {
+		if saved[k].Name == "hv2-VM3" {
+			saved[k].State = string(model.ServerStateRunning)
+		}
+	}
+
+	if err := ts.store.Cache(ctx).Save("hv2", saved, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// перезапуск: данных hv2 нет в памяти, они восстанавливаются из БД
+	ts.cache.RemoveHost("hv2")
+	ts.cache.AddHost("hv2")
+
+	snapshots, err := ts.store.Cache(ctx).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, snapshot := range snapshots {
+		ts.cache.Restore(snapshot.HV, snapshot.Servers, snapshot.UpdatedAt)
+	}
+
+	// обновление с гипервизора в фоне заканчивается позже ответов
+	ts.sim.SetLatency(200 * time.Millisecond)
+
+	resp := ts.servers(t, adminUser)
+	if !resp.Stale || resp.Age < 3600 || len(resp.Hosts) != 2 {
+		t.Errorf("admin response = stale %t, age %d, hosts %+v", resp.Stale, resp.Age, resp.Hosts)
+	}
+
+	for _, h := range resp.Hosts {
+		if h.Stale != (h.HV == "hv2") {
+			t.Errorf("host %s stale = %t", h.HV, h.Stale)
+		}
+	}
+
+	for _, v := range resp.Servers {
+		if v.Name == "hv2-VM3" && v.State != string(model.ServerStateRunning) {
+			t.Errorf("restored hv2-VM3 state = %s, want saved Running", v.State)
+		}
+	}
+
+	// пользователь видит только свои серверы и не видит состояние гипервизоров
+	resp = ts.servers(t, user)
+	if !resp.Stale || len(resp.Hosts) != 0 || len(resp.Servers) != 1 || resp.Servers[0].Name != "hv2-VM1" {
+		t.Errorf("user response = %+v", resp)
+	}
+
+	// устаревшие данные чужого гипервизора не учитываются
+	if resp = ts.servers(t, other); resp.Stale || len(resp.Servers) != 1 {
+		t.Errorf("response of user without hv2 servers = %+v", resp)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.cache.Info([]string{"hv2"})[0].Stale {
+		if time.Now().After(deadline) {
+			t.Fatal("restored data of hv2 is not refreshed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp = ts.servers(t, adminUser)
+	if resp.Stale {
+		t.Errorf("admin response after refresh is stale: %+v", resp.Hosts)
+	}
+
+	for _, v := range resp.Servers {
+		if v.Name == "hv2-VM3" && v.State != string(model.ServerStateStopped) {
+			t.Errorf("refreshed hv2-VM3 state = %s, want Off", v.State)
+		}
+	}
+}
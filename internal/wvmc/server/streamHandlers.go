@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+const (
+	// streamBuffer количество сообщений, которые ждут отправки клиенту.
+	// Клиент, который не успевает их читать, отключается.
+	streamBuffer = 256
+
+	// streamPing интервал отправки комментария, который не дает прокси закрыть соединение
+	streamPing = 30 * time.Second
+)
+
+// Типы сообщений потока серверов
+const (
+	streamEvent = "event"
+	streamCPU   = "cpu"
+)
+
+// streamMessage сообщение потока об изменении ВМ server
+type streamMessage struct {
+	kind   string
+	server model.Server
+	data   interface{}
+}
+
+// streamCPUData загрузка процессора и выделенная память ВМ после обновления кеша
+type streamCPUData struct {
+	HV             string  `json:"hv"`
+	VMID           string  `json:"vmid"`
+	Name           string  `json:"name"`
+	CpuLoad        int     `json:"cpu_load"`
+	MemoryAssigned float64 `json:"memory_assigned"`
+}
+
+// streamHub рассылает изменения ВМ всем подключенным клиентам потока
+type streamHub struct {
+	mu      sync.Mutex
+	clients map[chan streamMessage]struct{}
+	// cpu последние отправленные показатели ВМ по гипервизорам, затем по VMID
+	cpu map[string]map[string]streamCPUData
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{
+		clients: make(map[chan streamMessage]struct{}),
+		cpu:     make(map[string]map[string]streamCPUData),
+	}
+}
+
+// add подключает нового клиента
+func (h *streamHub) add() chan streamMessage {
+	ch := make(chan streamMessage, streamBuffer)
+
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch
+}
+
+// remove отключает клиента, если он еще не отключен
+func (h *streamHub) remove(ch chan streamMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// broadcast отправляет сообщения всем клиентам без ожидания, отстающие клиенты отключаются
+func (h *streamHub) broadcast(messages []streamMessage) {
+	if len(messages) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.clients {
+		for _, m := range messages {
+			select {
+			case ch <- m:
+				continue
+			default:
+			}
+
+			logit.Info("Клиент потока серверов не успевает получать сообщения, отключаем")
+			delete(h.clients, ch)
+			close(ch)
+
+			break
+		}
+	}
+}
+
+// events рассылает события изменения ВМ в кеше: после обновления с гипервизора и после команд
+func (h *streamHub) events(events []model.Event) {
+	messages := make([]streamMessage, 0, len(events))
+
+	for _, e := range events {
+		messages = append(messages, streamMessage{
+			kind:   streamEvent,
+			server: model.Server{VMID: e.VMID, Name: e.Server, HV: e.HV},
+			data:   e,
+		})
+	}
+
+	h.broadcast(messages)
+}
+
+// refreshed рассылает загрузку процессора и память ВМ гипервизора, если они изменились.
+// Показатели гипервизора собираются заново при каждом обновлении, удаленные и перенесенные ВМ в них не остаются.
+func (h *streamHub) refreshed(hv string, servers []model.Server, at time.Time) {
+	messages := make([]streamMessage, 0)
+	current := make(map[string]streamCPUData, len(servers))
+
+	h.mu.Lock()
+
+	previous := h.cpu[strings.ToLower(hv)]
+
+	for _, v := range servers {
+		data := streamCPUData{
+			HV:             v.HV,
+			VMID:           v.VMID,
+			Name:           v.Name,
+			CpuLoad:        v.CpuLoad,
+			MemoryAssigned: v.MemoryAssigned,
+		}
+
+		current[v.VMID] = data
+
+		if previous[v.VMID] == data {
+			continue
+		}
+
+		messages = append(messages, streamMessage{kind: streamCPU, server: v, data: data})
+	}
+
+	h.cpu[strings.ToLower(hv)] = current
+
+	h.mu.Unlock()
+
+	h.broadcast(messages)
+}
+
+// StreamServers отправляет изменения состояния, сети и загрузки процессора ВМ как Server-Sent Events,
+// пока клиент не отключится. Пользователи получают только изменения выданных им серверов.
+func (s *Server) StreamServers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			SendErr(w, http.StatusInternalServerError, errors.New("streaming is not supported"),
+				"Поток не поддерживается")
+			return
+		}
+
+		visible, err := s.streamFilter(r.Context(), user)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		ch := s.stream.add()
+		defer s.stream.remove(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		fmt.Fprint(w, "retry: 5000\n\n")
+		flusher.Flush()
+
+		logit.Info("Клиент подключился к потоку серверов", user.Email)
+
+		ping := time.NewTicker(streamPing)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				logit.Info("Клиент отключился от потока серверов", user.Email)
+				return
+			case <-ping.C:
+				// выданные и отозванные серверы учитываются без переподключения
+				if visible, err = s.streamFilter(r.Context(), user); err != nil {
+					logit.Log("Не удалось получить серверы пользователя", user.Email, err)
+					return
+				}
+
+				fmt.Fprint(w, ": ping\n\n")
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				if !visible(m.server) {
+					continue
+				}
+
+				data, err := json.Marshal(m.data)
+				if err != nil {
+					logit.Log("Ошибка отправки сообщения потока", err)
+					continue
+				}
+
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.kind, data)
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// streamFilter возвращает функцию, которая проверяет, видна ли ВМ пользователю user
+func (s *Server) streamFilter(ctx context.Context, user model.User) (func(model.Server) bool, error) {
+	if user.Role == model.UserRoleAdmin {
+		return func(model.Server) bool { return true }, nil
+	}
+
+	servers, err := s.store.Server(ctx).FindByUser(user.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return func(v model.Server) bool {
+		for _, srv := range servers {
+			if v.VMID != "" && srv.VMID == v.VMID {
+				return true
+			}
+
+			if strings.EqualFold(srv.HV, v.HV) && srv.Name == v.Name {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
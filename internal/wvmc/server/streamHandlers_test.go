@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// received забирает из канала клиента все сообщения, которые уже отправлены
+func received(ch chan streamMessage) (messages []streamMessage, closed bool) {
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return messages, true
+			}
+
+			messages = append(messages, m)
+		default:
+			return messages, false
+		}
+	}
+}
+
+func TestStreamRefreshedSendsOnlyChanges(t *testing.T) {
+	h := newStreamHub()
+	ch := h.add()
+
+	vm1 := model.Server{VMID: "1", Name: "vm1", HV: "hv1", CpuLoad: 10, MemoryAssigned: 2}
+	vm2 := model.Server{VMID: "2", Name: "vm2", HV: "hv1", CpuLoad: 0, MemoryAssigned: 0}
+
+	h.refreshed("hv1", []model.Server{vm1, vm2}, time.Now())
+
+	if messages, _ := received(ch); len(messages) != 2 {
+		t.Fatalf("first refresh sent %d messages, want 2", len(messages))
+	}
+
+	// без изменений ничего не отправляется
+	h.refreshed("hv1", []model.Server{vm1, vm2}, time.Now())
+
+	if messages, _ := received(ch); len(messages) != 0 {
+		t.Fatalf("refresh without changes sent %+v", messages)
+	}
+
+	vm1.CpuLoad = 20
+	h.refreshed("hv1", []model.Server{vm1, vm2}, time.Now())
+
+	messages, _ := received(ch)
+	if len(messages) != 1 || messages[0].kind != streamCPU || messages[0].data.(streamCPUData).CpuLoad != 20 {
+		t.Fatalf("messages after cpu change = %+v", messages)
+	}
+
+	// ВМ пропала с гипервизора, ее показатели больше не хранятся
+	h.refreshed("hv1", []model.Server{vm1}, time.Now())
+
+	if messages, _ := received(ch); len(messages) != 0 {
+		t.Fatalf("refresh without vm2 sent %+v", messages)
+	}
+
+	if _, ok := h.cpu["hv1"]["2"]; ok || len(h.cpu["hv1"]) != 1 {
+		t.Fatalf("cpu of hv1 = %+v, want only vm1", h.cpu["hv1"])
+	}
+
+	// ВМ перенесена на другой гипервизор
+	vm1.HV = "hv2"
+	h.refreshed("HV1", nil, time.Now())
+	h.refreshed("hv2", []model.Server{vm1}, time.Now())
+
+	if messages, _ := received(ch); len(messages) != 1 || messages[0].server.HV != "hv2" {
+		t.Fatalf("messages after move = %+v", messages)
+	}
+
+	if len(h.cpu["hv1"]) != 0 || len(h.cpu["hv2"]) != 1 {
+		t.Errorf("cpu = %+v, want vm1 only on hv2", h.cpu)
+	}
+}
+
+func TestStreamDisconnectsSlowClient(t *testing.T) {
+	h := newStreamHub()
+	slow := h.add()
+
+	messages := make([]streamMessage, streamBuffer)
+	for i := range messages {
+		messages[i] = streamMessage{kind: streamEvent}
+	}
+
+	// буфер медленного клиента заполнен
+	h.broadcast(messages)
+
+	fast := h.add()
+	h.broadcast([]streamMessage{{kind: streamEvent}})
+
+	got, closed := received(slow)
+	if !closed || len(got) != streamBuffer {
+		t.Errorf("slow client got %d messages, closed %v; want %d and closed", len(got), closed, streamBuffer)
+	}
+
+	if got, closed := received(fast); closed || len(got) != 1 {
+		t.Errorf("fast client got %d messages, closed %v; want 1 and open", len(got), closed)
+	}
+
+	if _, ok := h.clients[slow]; ok || len(h.clients) != 1 {
+		t.Errorf("clients = %d, want only the fast one", len(h.clients))
+	}
+
+	// отключение уже отключенного клиента ничего не делает
+	h.remove(slow)
+	h.remove(fast)
+
+	if len(h.clients) != 0 {
+		t.Errorf("clients after remove = %d", len(h.clients))
+	}
+}
+
+func TestStreamFilter(t *testing.T) {
+	ts := newTestServer(t, "hv1", "hv2")
+
+	vm1 := ts.server(t, "hv1-VM1")
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1")
+	stranger := ts.createUser(t, "stranger@example.com", model.UserRoleUser)
+
+	tests := []struct {
+		name   string
+		user   model.User
+		server model.Server
+		want   bool
+	}{
+		{"own server by vmid", user, model.Server{VMID: vm1.VMID}, true},
+		{"own server by name", user, model.Server{HV: "HV1", Name: "hv1-VM1"}, true},
+		{"server on another host", user, model.Server{HV: "hv2", Name: "hv1-VM1"}, false},
+		{"other server", user, model.Server{VMID: ts.server(t, "hv1-VM2").VMID, HV: "hv1", Name: "hv1-VM2"},
+			false},
+		{"user without servers", stranger, model.Server{VMID: vm1.VMID, HV: "hv1", Name: "hv1-VM1"}, false},
+		{"admin", adminUser, model.Server{VMID: "unknown", HV: "hv9", Name: "vm"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visible, err := ts.streamFilter(context.Background(), tt.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := visible(tt.server); got != tt.want {
+				t.Errorf("visible(%+v) = %v, want %v", tt.server, got, tt.want)
+			}
+		})
+	}
+
+	if code, _ := ts.do(t, model.User{}, "GET", "/servers/stream", nil); code != http.StatusUnauthorized {
+		t.Errorf("stream without token: got %d, want 401", code)
+	}
+}
+
+func TestStreamServersForUser(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1")
+
+	httpServer := httptest.NewServer(ts.router)
+	defer httpServer.Close()
+
+	req, err := http.NewRequest("GET", httpServer.URL+"/servers/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+createToken("access", user))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("stream: %d %s", resp.StatusCode, ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+
+	// клиент подключен к рассылке до отправки retry
+	if !lines.Scan() || lines.Text() != "retry: 5000" {
+		t.Fatalf("first line = %q", lines.Text())
+	}
+
+	ts.stream.events([]model.Event{
+		{Type: model.EventStateChanged, VMID: ts.server(t, "hv1-VM2").VMID, Server: "hv1-VM2", HV: "hv1"},
+		{Type: model.EventStateChanged, VMID: ts.server(t, "hv1-VM1").VMID, Server: "hv1-VM1", HV: "hv1"},
+	})
+
+	got := make([]string, 0, 2)
+	for len(got) < 2 && lines.Scan() {
+		if line := lines.Text(); line != "" {
+			got = append(got, line)
+		}
+	}
+
+	if len(got) != 2 || got[0] != "event: "+streamEvent || !strings.Contains(got[1], `"server":"hv1-VM1"`) {
+		t.Errorf("stream = %q, want only the event of hv1-VM1", got)
+	}
+}
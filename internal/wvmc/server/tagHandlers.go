@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+const (
+	// maxServerTags наибольшее количество меток у сервера
+	maxServerTags = 20
+
+	// maxTagLength наибольшая длина метки
+	maxTagLength = 64
+)
+
+// GetServerTags возвращает метки сервера, по которым серверы выбираются для массового управления
+func (s *Server) GetServerTags() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		server := r.Context().Value(CtxString("server")).(model.Server)
+
+		tags, err := s.store.Server(r.Context()).Tags(server.ID)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, tags)
+	}
+}
+
+// SetServerTags заменяет метки сервера, пустой список удаляет все метки
+func (s *Server) SetServerTags() http.HandlerFunc {
+	type request struct {
+		Tags []string `json:"tags"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+		server := r.Context().Value(CtxString("server")).(model.Server)
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		tags := make([]string, 0, len(req.Tags))
+		seen := make(map[string]bool, len(req.Tags))
+
+		for _, tag := range req.Tags {
+			tag = strings.TrimSpace(tag)
+			if tag == "" || seen[strings.ToLower(tag)] {
+				continue
+			}
+
+			if len([]rune(tag)) > maxTagLength {
+				SendErr(w, http.StatusBadRequest, errors.New("tag is too long"),
+					"Метка должна быть не длиннее 64 символов")
+				return
+			}
+
+			seen[strings.ToLower(tag)] = true
+			tags = append(tags, tag)
+		}
+
+		if len(tags) > maxServerTags {
+			SendErr(w, http.StatusBadRequest, errors.New("too many tags"), "У сервера может быть не больше 20 меток")
+			return
+		}
+
+		store := s.store.Server(r.Context())
+
+		if err := store.SetTags(server.ID, tags); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		tags, err := store.Tags(server.ID)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		s.auditAction(r, user, server, "set_tags", strings.Join(tags, ", "))
+
+		SendOK(w, http.StatusOK, tags)
+	}
+}
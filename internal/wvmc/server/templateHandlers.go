@@ -0,0 +1,243 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+	"github.com/gorilla/mux"
+)
+
+// GetTemplates возвращает список шаблонов ВМ
+func (s *Server) GetTemplates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		templates, err := s.store.Template(r.Context()).All()
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, templates)
+	}
+}
+
+// CreateTemplate создает шаблон ВМ
+func (s *Server) CreateTemplate() http.HandlerFunc {
+	type response struct {
+		ID int64 `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req model.Template
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		template, ok := s.validateTemplate(w, r, req, 0)
+		if !ok {
+			return
+		}
+
+		id, err := s.store.Template(r.Context()).Create(template)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusCreated, response{id})
+	}
+}
+
+// EditTemplate заменяет все поля шаблона ВМ
+func (s *Server) EditTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := templateID(w, r)
+		if !ok {
+			return
+		}
+
+		store := s.store.Template(r.Context())
+
+		if _, err := store.Find(id); err != nil {
+			if err == sql.ErrNoRows {
+				SendErr(w, http.StatusNotFound, err, "Шаблон не найден")
+				return
+			}
+
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		var req model.Template
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		template, ok := s.validateTemplate(w, r, req, id)
+		if !ok {
+			return
+		}
+
+		template.ID = id
+
+		if err := store.Edit(template); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, template)
+	}
+}
+
+// DeleteTemplate удаляет шаблон ВМ, созданные из него ВМ не изменяются
+func (s *Server) DeleteTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := templateID(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.store.Template(r.Context()).Delete(id); err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		SendOK(w, http.StatusOK, "Шаблон удален")
+	}
+}
+
+// CreateServer создает ВМ из шаблона и добавляет ее в список серверов.
+// Созданную ВМ можно сразу выдать пользователям через /users/servers.
+func (s *Server) CreateServer() http.HandlerFunc {
+	type request struct {
+		TemplateID  int64  `json:"template_id"`
+		Name        string `json:"name"`
+		Company     string `json:"company"`
+		Description string `json:"description"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(CtxString("user")).(model.User)
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErr(w, http.StatusBadRequest, err, "невалидный json")
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+
+		template, err := s.store.Template(r.Context()).Find(req.TemplateID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				SendErr(w, http.StatusNotFound, err, "Шаблон не найден")
+				return
+			}
+
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		store := s.store.Server(r.Context())
+
+		_, err = store.FindByHvAndName(template.HV, req.Name)
+		if err == nil {
+			SendErr(w, http.StatusConflict, errors.New("server already exists"),
+				"Сервер с таким именем уже есть на гипервизоре")
+			return
+		}
+
+		if err != sql.ErrNoRows {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		server, err := s.controlService.CreateServer(r.Context(), template, req.Name)
+		if err != nil {
+			SendCommandErr(w, http.StatusInternalServerError, err, "Ошибка создания сервера")
+			return
+		}
+
+		server.Company = strings.TrimSpace(req.Company)
+		server.Description = strings.TrimSpace(req.Description)
+		server.User = os.Getenv("SERVER_USER_NAME")
+		server.Password = os.Getenv("SERVER_USER_PASSWORD")
+
+		if _, err = store.Create(server); err != nil {
+			logit.Log("Невозможно добавить сервер", server.Name, err)
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		created, err := store.FindByHvAndName(server.HV, server.Name)
+		if err != nil {
+			SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+			return
+		}
+
+		if template.Switch != "" {
+			if err = store.SetSwitch(created.ID, template.Switch); err != nil {
+				logit.Log("Не удалось задать коммутатор сервера", server.Name, err)
+			}
+
+			server.Switch = template.Switch
+		}
+
+		server.ID = created.ID
+		server.Password = ""
+
+		details := fmt.Sprintf("template %s", template.Name)
+
+		s.auditAction(r, user, server, "create_server", details)
+		s.notifyAction(user, server, "create_server "+details)
+
+		SendOK(w, http.StatusCreated, server)
+	}
+}
+
+// validateTemplate проверяет шаблон req и уникальность его имени среди шаблонов, кроме id.
+// При ошибке отправляет ответ и возвращает false.
+func (s *Server) validateTemplate(w http.ResponseWriter, r *http.Request, req model.Template,
+	id int64) (model.Template, bool) {
+	template, err := s.controlService.ValidateTemplate(r.Context(), req)
+	if err != nil {
+		SendCommandErr(w, http.StatusBadRequest, err, "Неверные параметры шаблона")
+		return template, false
+	}
+
+	templates, err := s.store.Template(r.Context()).All()
+	if err != nil {
+		SendErr(w, http.StatusInternalServerError, err, "Ошибка БД")
+		return template, false
+	}
+
+	for _, t := range templates {
+		if t.ID != id && strings.EqualFold(t.Name, template.Name) {
+			SendErr(w, http.StatusConflict, errors.New("template already exists"),
+				"Шаблон с таким именем уже существует")
+			return template, false
+		}
+	}
+
+	return template, true
+}
+
+// templateID возвращает ID шаблона из пути запроса. При ошибке отправляет ответ и возвращает false.
+func templateID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		SendErr(w, http.StatusBadRequest, err, "Неверный ID шаблона")
+		return 0, false
+	}
+
+	return id, true
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// testTemplate шаблон с базовым диском симулятора
+func testTemplate(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"hv":          "HV1",
+		"vhdx_path":   `C:\Templates\base.vhdx`,
+		"cpu_cores":   2,
+		"memory":      2048,
+		"switch_name": "LAN - Virtual Switch",
+	}
+}
+
+func TestTemplateHandlers(t *testing.T) {
+	ts := newTestServer(t, "hv1")
+
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1")
+
+	code, body := ts.do(t, adminUser, "POST", "/templates", testTemplate("web"))
+	if code != http.StatusCreated {
+		t.Fatalf("create template: %d %s", code, body)
+	}
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	decode(t, body, &created)
+
+	path := "/templates/" + strconv.FormatInt(created.ID, 10)
+
+	invalid := testTemplate("db")
+	invalid["vhdx_path"] = `C:\Templates\base.iso`
+
+	unknownHost := testTemplate("db")
+	unknownHost["hv"] = "hv9"
+
+	tests := []struct {
+		name   string
+		user   model.User
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"user lists", user, "GET", "/templates", nil, http.StatusForbidden},
+		{"user creates", user, "POST", "/templates", testTemplate("db"), http.StatusForbidden},
+		{"user edits", user, "PUT", path, testTemplate("db"), http.StatusForbidden},
+		{"user deletes", user, "DELETE", path, nil, http.StatusForbidden},
+		{"no token", model.User{}, "GET", "/templates", nil, http.StatusUnauthorized},
+		{"invalid disk", adminUser, "POST", "/templates", invalid, http.StatusBadRequest},
+		{"unknown host", adminUser, "POST", "/templates", unknownHost, http.StatusBadRequest},
+		{"same name", adminUser, "POST", "/templates", testTemplate("WEB"), http.StatusConflict},
+		{"unknown template", adminUser, "PUT", "/templates/999", testTemplate("db"), http.StatusNotFound},
+		{"invalid id", adminUser, "PUT", "/templates/x", testTemplate("db"), http.StatusBadRequest},
+		{"admin edits", adminUser, "PUT", path, testTemplate("web"), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, tt.method, tt.path, tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	var templates []model.Template
+
+	_, body = ts.do(t, adminUser, "GET", "/templates", nil)
+	if decode(t, body, &templates); len(templates) != 1 || templates[0].HV != "hv1" ||
+		templates[0].DiskType != model.TemplateDiskDifferencing || templates[0].Generation != 2 {
+		t.Errorf("templates = %+v", templates)
+	}
+
+	if code, body = ts.do(t, adminUser, "DELETE", path, nil); code != http.StatusOK {
+		t.Fatalf("delete template: %d %s", code, body)
+	}
+
+	if code, _ = ts.do(t, adminUser, "PUT", path, testTemplate("web")); code != http.StatusNotFound {
+		t.Errorf("edit of deleted template: got %d, want 404", code)
+	}
+}
+
+func TestCreateServerHandler(t *testing.T) {
+	ts := newTestServer(t, "hv1", "hv2")
+
+	user := ts.createUser(t, "user@example.com", model.UserRoleUser, "hv1-VM1")
+
+	code, body := ts.do(t, adminUser, "POST", "/templates", testTemplate("web"))
+	if code != http.StatusCreated {
+		t.Fatalf("create template: %d %s", code, body)
+	}
+
+	var template struct {
+		ID int64 `json:"id"`
+	}
+	decode(t, body, &template)
+
+	// hv1-VM2 есть на гипервизоре, но в БД записана на другом гипервизоре
+	if err := ts.store.Server(context.Background()).Move(ts.server(t, "hv1-VM2").ID, "hv2"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(name string) map[string]interface{} {
+		return map[string]interface{}{"template_id": template.ID, "name": name, "company": "ACME"}
+	}
+
+	tests := []struct {
+		name string
+		user model.User
+		body interface{}
+		want int
+	}{
+		{"user", user, request("web1"), http.StatusForbidden},
+		{"unknown template", adminUser, map[string]interface{}{"template_id": 999, "name": "web1"},
+			http.StatusNotFound},
+		{"invalid name", adminUser, request("web 1; Remove-VM"), http.StatusBadRequest},
+		{"server in the list", adminUser, request("hv1-VM1"), http.StatusConflict},
+		{"vm on the host", adminUser, request("hv1-VM2"), http.StatusConflict},
+		{"invalid json", adminUser, "x", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := ts.do(t, tt.user, "POST", "/servers", tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, body, tt.want)
+			}
+		})
+	}
+
+	if n := len(ts.notices.sent()); n != 0 {
+		t.Fatalf("rejected requests sent %d notifications", n)
+	}
+
+	code, body = ts.do(t, adminUser, "POST", "/servers", request(" web1 "))
+	if code != http.StatusCreated {
+		t.Fatalf("create server: %d %s", code, body)
+	}
+
+	var server model.Server
+	if decode(t, body, &server); server.ID == 0 || server.Name != "web1" || server.HV != "hv1" ||
+		server.Company != "ACME" || server.Switch != "LAN - Virtual Switch" || server.Password != "" {
+		t.Errorf("created server = %+v", server)
+	}
+
+	if stored := ts.server(t, "web1"); stored.ID != server.ID || stored.Switch != "LAN - Virtual Switch" {
+		t.Errorf("stored server = %+v", stored)
+	}
+
+	notices := ts.notices.sent()
+	if len(notices) != 1 || !strings.Contains(notices[0], "create_server template web") {
+		t.Errorf("notifications = %q", notices)
+	}
+}
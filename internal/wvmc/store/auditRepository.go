@@ -0,0 +1,30 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// AuditRepository - содержит методы работы с журналом изменений серверов.
+type AuditRepository struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// Create добавляет запись в журнал изменений, возвращает ее ID либо ошибку.
+func (r *AuditRepository) Create(a model.AuditRecord) (int64, error) {
+	logit.Info("Аудит:", a.UserEmail, a.HV, a.Server, a.Action, a.Details)
+
+	query := "INSERT INTO audit (user_id, user_email, hv, server, action, details) VALUES (?, ?, ?, ?, ?, ?)"
+
+	result, err := r.db.ExecContext(r.ctx, query, a.UserID, a.UserEmail, a.HV, a.Server, a.Action,
+		a.Details)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// CacheRepository - содержит методы сохранения кеша ВМ между перезапусками.
+type CacheRepository struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// Save заменяет сохраненные ВМ гипервизора hv на servers, полученные в момент at.
+func (r *CacheRepository) Save(hv string, servers []model.Server, at time.Time) error {
+	data, err := json.Marshal(servers)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT OR REPLACE INTO cache_hosts (hv, updated_at, servers) VALUES (?, ?, ?)"
+	_, err = r.db.ExecContext(r.ctx, query, hv, at.UTC(), string(data))
+
+	return err
+}
+
+// All возвращает сохраненные ВМ всех гипервизоров, либо ошибку.
+func (r *CacheRepository) All() ([]model.HostSnapshot, error) {
+	snapshots := make([]model.HostSnapshot, 0)
+
+	rows, err := r.db.QueryContext(r.ctx, "SELECT hv, updated_at, servers FROM cache_hosts")
+	if err != nil {
+		return snapshots, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			snapshot model.HostSnapshot
+			data     string
+		)
+
+		if err = rows.Scan(&snapshot.HV, &snapshot.UpdatedAt, &data); err != nil {
+			return snapshots, err
+		}
+
+		if err = json.Unmarshal([]byte(data), &snapshot.Servers); err != nil {
+			return snapshots, err
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Ограничения количества событий в одном ответе
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+// EventRepository - содержит методы работы с событиями изменения ВМ.
+type EventRepository struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// Create сохраняет события events, возвращает ошибку в случае неудачи.
+func (r *EventRepository) Create(events []model.Event) error {
+	tx, err := r.db.BeginTx(r.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO events (created_at, type, source, hv, vmid, server, old_value, new_value)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	for _, e := range events {
+		logit.Info("Событие:", e.Type, e.HV, e.Server, e.OldValue, "->", e.NewValue)
+
+		_, err = tx.ExecContext(r.ctx, query, e.CreatedAt.UTC(), e.Type, e.Source, e.HV, e.VMID, e.Server,
+			e.OldValue, e.NewValue)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Find возвращает события, подходящие под фильтр f, начиная с последнего, либо ошибку.
+func (r *EventRepository) Find(f model.EventFilter) ([]model.Event, error) {
+	events := make([]model.Event, 0)
+
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if f.HV != "" {
+		where = append(where, "hv = ? COLLATE NOCASE")
+		args = append(args, f.HV)
+	}
+
+	if f.Server != "" {
+		where = append(where, "server = ?")
+		args = append(args, f.Server)
+	}
+
+	if f.VMID != "" {
+		where = append(where, "vmid = ?")
+		args = append(args, f.VMID)
+	}
+
+	if f.VMIDs != nil {
+		where = append(where, "vmid IN ("+placeholders(len(f.VMIDs))+")")
+		for _, id := range f.VMIDs {
+			args = append(args, id)
+		}
+	}
+
+	if len(f.Types) > 0 {
+		where = append(where, "type IN ("+placeholders(len(f.Types))+")")
+		for _, t := range f.Types {
+			args = append(args, t)
+		}
+	}
+
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+
+	if !f.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.To.UTC())
+	}
+
+	if f.BeforeID > 0 {
+		where = append(where, "id < ?")
+		args = append(args, f.BeforeID)
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = defaultEventsLimit
+	}
+
+	if f.Limit > maxEventsLimit {
+		f.Limit = maxEventsLimit
+	}
+
+	query := "SELECT id, created_at, type, source, hv, vmid, server, old_value, new_value FROM events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := r.db.QueryContext(r.ctx, query, args...)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e model.Event
+
+		err = rows.Scan(&e.ID, &e.CreatedAt, &e.Type, &e.Source, &e.HV, &e.VMID, &e.Server, &e.OldValue,
+			&e.NewValue)
+		if err != nil {
+			return events, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// placeholders возвращает n параметров запроса через запятую
+func placeholders(n int) string {
+	if n == 0 {
+		return "NULL"
+	}
+
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
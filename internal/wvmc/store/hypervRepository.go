@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// HypervRepository - содержит методы работы с гипервизорами.
+type HypervRepository struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// hypervColumns колонки таблицы hypervs в порядке полей scan
+const hypervColumns = "name, ip4, enabled, user, password"
+
+// Find ищет гипервизор по имени без учета регистра, возвращает модель либо ошибку.
+func (r *HypervRepository) Find(name string) (model.Hypervisor, error) {
+	row := r.db.QueryRowContext(r.ctx, "SELECT "+hypervColumns+" FROM hypervs WHERE name = ? COLLATE NOCASE",
+		name)
+
+	return scanHyperv(row)
+}
+
+// All возвращает все гипервизоры, отсортированные по имени, либо ошибку.
+func (r *HypervRepository) All() ([]model.Hypervisor, error) {
+	return r.query("SELECT " + hypervColumns + " FROM hypervs ORDER BY name")
+}
+
+// Enabled возвращает включенные гипервизоры, отсортированные по имени, либо ошибку.
+func (r *HypervRepository) Enabled() ([]model.Hypervisor, error) {
+	return r.query("SELECT " + hypervColumns + " FROM hypervs WHERE enabled = 1 ORDER BY name")
+}
+
+// Create добавляет гипервизор hv, возвращает ошибку в случае неудачи.
+func (r *HypervRepository) Create(hv model.Hypervisor) error {
+	logit.Info("Добавляем гипервизор:", hv.Name)
+
+	_, err := r.db.ExecContext(r.ctx, "INSERT INTO hypervs ("+hypervColumns+") VALUES (?, ?, ?, ?, ?)",
+		hv.Name, hv.IP, hv.Enabled, hv.User, hv.Password)
+
+	return err
+}
+
+// Edit обновляет все поля гипервизора hv.Name, возвращает ошибку в случае неудачи.
+func (r *HypervRepository) Edit(hv model.Hypervisor) error {
+	logit.Info("Обновляем гипервизор:", hv.Name)
+
+	query := "UPDATE hypervs SET ip4 = ?, enabled = ?, user = ?, password = ? WHERE name = ?"
+
+	_, err := r.db.ExecContext(r.ctx, query, hv.IP, hv.Enabled, hv.User, hv.Password, hv.Name)
+
+	return err
+}
+
+// Delete удаляет гипервизор name, серверы гипервизора в БД не изменяются.
+// Возвращает ошибку в случае неудачи.
+func (r *HypervRepository) Delete(name string) error {
+	logit.Info("Удаляем гипервизор:", name)
+
+	_, err := r.db.ExecContext(r.ctx, "DELETE FROM hypervs WHERE name = ?", name)
+
+	return err
+}
+
+// AddMissing добавляет гипервизоры names, которых еще нет в таблице, возвращает ошибку в случае неудачи.
+func (r *HypervRepository) AddMissing(names []string) error {
+	for _, name := range names {
+		result, err := r.db.ExecContext(r.ctx, "INSERT OR IGNORE INTO hypervs (name) VALUES (?)", name)
+		if err != nil {
+			return err
+		}
+
+		if n, _ := result.RowsAffected(); n > 0 {
+			logit.Info("Добавлен гипервизор", name)
+		}
+	}
+
+	return nil
+}
+
+func (r *HypervRepository) query(query string) ([]model.Hypervisor, error) {
+	hvs := make([]model.Hypervisor, 0)
+
+	rows, err := r.db.QueryContext(r.ctx, query)
+	if err != nil {
+		return hvs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hv, err := scanHyperv(rows)
+		if err != nil {
+			return hvs, err
+		}
+
+		hvs = append(hvs, hv)
+	}
+
+	return hvs, rows.Err()
+}
+
+// scanHyperv читает гипервизор из строки результата запроса
+func scanHyperv(row interface{ Scan(...interface{}) error }) (model.Hypervisor, error) {
+	var hv model.Hypervisor
+
+	err := row.Scan(&hv.Name, &hv.IP, &hv.Enabled, &hv.User, &hv.Password)
+
+	return hv, err
+}
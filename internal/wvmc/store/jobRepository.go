@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Ограничения количества заданий в одном ответе
+const (
+	defaultJobsLimit = 50
+	maxJobsLimit     = 500
+)
+
+// jobColumns колонки заданий в порядке scanJob
+const jobColumns = `id, user_id, user_email, kind, command, hv, server, state, output, error, created_at,
+	started_at, finished_at`
+
+// JobRepository - содержит методы работы с фоновыми заданиями.
+type JobRepository struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// Create добавляет задание в очередь, возвращает его ID либо ошибку.
+func (r *JobRepository) Create(j model.Job) (int64, error) {
+	query := `INSERT INTO jobs (user_id, user_email, kind, command, hv, server, state, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := r.db.ExecContext(r.ctx, query, j.UserID, j.UserEmail, j.Kind, j.Command, j.HV, j.Server,
+		model.JobQueued, j.CreatedAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// Start отмечает задание id как выполняемое, если оно еще в очереди.
+// Возвращает false, если задание уже отменено.
+func (r *JobRepository) Start(id int64, at time.Time) (bool, error) {
+	query := "UPDATE jobs SET state = ?, started_at = ? WHERE id = ? AND state = ?"
+
+	result, err := r.db.ExecContext(r.ctx, query, model.JobRunning, at.UTC(), id, model.JobQueued)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return n == 1, err
+}
+
+// Finish завершает задание id с состоянием state, выводом output и ошибкой errText,
+// если оно еще не завершено. Возвращает false, если задание уже завершено.
+func (r *JobRepository) Finish(id int64, state, output, errText string, at time.Time) (bool, error) {
+	query := `UPDATE jobs SET state = ?, output = ?, error = ?, finished_at = ?
+	WHERE id = ? AND state IN (?, ?)`
+
+	result, err := r.db.ExecContext(r.ctx, query, state, output, errText, at.UTC(), id, model.JobQueued,
+		model.JobRunning)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return n == 1, err
+}
+
+// FailUnfinished завершает с ошибкой errText все задания, которые не завершились,
+// возвращает их количество либо ошибку.
+func (r *JobRepository) FailUnfinished(errText string, at time.Time) (int64, error) {
+	query := "UPDATE jobs SET state = ?, error = ?, finished_at = ? WHERE state IN (?, ?)"
+
+	result, err := r.db.ExecContext(r.ctx, query, model.JobFailed, errText, at.UTC(), model.JobQueued,
+		model.JobRunning)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteFinishedBefore удаляет задания, завершенные раньше before.
+func (r *JobRepository) DeleteFinishedBefore(before time.Time) error {
+	_, err := r.db.ExecContext(r.ctx, "DELETE FROM jobs WHERE finished_at < ?", before.UTC())
+
+	return err
+}
+
+// Find возвращает задание по его ID, если его нет - sql.ErrNoRows.
+func (r *JobRepository) Find(id int64) (model.Job, error) {
+	row := r.db.QueryRowContext(r.ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = ?", id)
+
+	return scanJob(row)
+}
+
+// FindByUser возвращает задания пользователя userID, если он пуст - всех пользователей,
+// начиная с последнего.
+func (r *JobRepository) FindByUser(userID string, limit int) ([]model.Job, error) {
+	jobs := make([]model.Job, 0)
+
+	if limit <= 0 {
+		limit = defaultJobsLimit
+	}
+
+	if limit > maxJobsLimit {
+		limit = maxJobsLimit
+	}
+
+	query := "SELECT " + jobColumns + " FROM jobs"
+	args := make([]interface{}, 0)
+
+	if userID != "" {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := r.db.QueryContext(r.ctx, query, args...)
+	if err != nil {
+		return jobs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return jobs, err
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// scanJob читает задание из строки результата запроса
+func scanJob(row interface{ Scan(...interface{}) error }) (model.Job, error) {
+	var (
+		j          model.Job
+		startedAt  sql.NullTime
+		finishedAt sql.NullTime
+	)
+
+	err := row.Scan(&j.ID, &j.UserID, &j.UserEmail, &j.Kind, &j.Command, &j.HV, &j.Server, &j.State,
+		&j.Output, &j.Error, &j.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return j, err
+	}
+
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+
+	return j, nil
+}
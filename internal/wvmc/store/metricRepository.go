@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// metricLevels хранение показателей ВМ: замеры (resolution 0) хранятся сутки, затем усредняются
+// по 5 минут, 5-минутные значения хранятся 30 дней и усредняются по часу, часовые хранятся год.
+var metricLevels = []struct {
+	resolution int64
+	next       int64
+	keep       time.Duration
+}{
+	{0, 300, 24 * time.Hour},
+	{300, 3600, 30 * 24 * time.Hour},
+	{3600, 0, 365 * 24 * time.Hour},
+}
+
+// MetricRepository - содержит методы работы с показателями ВМ.
+type MetricRepository struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// Create сохраняет загрузку процессора, выделенную память и состояние ВМ servers на момент at.
+func (r *MetricRepository) Create(servers []model.Server, at time.Time) error {
+	tx, err := r.db.BeginTx(r.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO metrics (hv, vmid, server, resolution, time, cpu, memory, running, samples)
+	VALUES (?, ?, ?, 0, ?, ?, ?, ?, 1)`
+
+	for _, v := range servers {
+		running := 0
+		if v.State == string(model.ServerStateRunning) {
+			running = 1
+		}
+
+		_, err = tx.ExecContext(r.ctx, query, v.HV, v.VMID, v.Name, at.Unix(), v.CpuLoad, v.MemoryAssigned,
+			running)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Find возвращает показатели ВМ name гипервизора hv с from до to, усредненные за каждый шаг step.
+// Шаги без замеров пропускаются.
+func (r *MetricRepository) Find(hv, name string, from, to time.Time,
+	step time.Duration) ([]model.MetricPoint, error) {
+	points := make([]model.MetricPoint, 0)
+	seconds := int64(step.Seconds())
+
+	query := `SELECT (time / ?) * ? AS bucket, SUM(cpu * samples) / SUM(samples),
+	SUM(memory * samples) / SUM(samples), SUM(running * samples) / SUM(samples), SUM(samples)
+	FROM metrics WHERE hv = ? AND server = ? AND time >= ? AND time <= ?
+	GROUP BY bucket ORDER BY bucket`
+
+	rows, err := r.db.QueryContext(r.ctx, query, seconds, seconds, hv, name, from.Unix(), to.Unix())
+	if err != nil {
+		return points, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			p      model.MetricPoint
+			bucket int64
+		)
+
+		if err = rows.Scan(&bucket, &p.CPU, &p.Memory, &p.Running, &p.Samples); err != nil {
+			return points, err
+		}
+
+		p.Time = time.Unix(bucket, 0).UTC()
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
+
+// Compact усредняет показатели, которые старше срока хранения своего уровня, до следующего уровня
+// и удаляет показатели старше года.
+func (r *MetricRepository) Compact(now time.Time) error {
+	tx, err := r.db.BeginTx(r.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range metricLevels {
+		before := now.Add(-l.keep).Unix()
+
+		if l.next > 0 {
+			// граница выравнивается по шагу следующего уровня, чтобы усреднять только полные шаги
+			before = before / l.next * l.next
+
+			_, err = tx.ExecContext(r.ctx, `INSERT INTO metrics
+			(hv, vmid, server, resolution, time, cpu, memory, running, samples)
+			SELECT hv, vmid, server, ?, (time / ?) * ?, SUM(cpu * samples) / SUM(samples),
+			SUM(memory * samples) / SUM(samples), SUM(running * samples) / SUM(samples), SUM(samples)
+			FROM metrics WHERE resolution = ? AND time < ?
+			GROUP BY hv, vmid, server, time / ?`,
+				l.next, l.next, l.next, l.resolution, before, l.next)
+			if err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(r.ctx, "DELETE FROM metrics WHERE resolution = ? AND time < ?",
+			l.resolution, before)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wvmc-store")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = logit.New(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	logit.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestDB создает пустую БД со всеми таблицами
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "wvmc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// metricRow строка таблицы metrics
+type metricRow struct {
+	resolution int64
+	time       time.Time
+	cpu        float64
+	memory     float64
+	running    float64
+	samples    int64
+}
+
+func metricRows(t *testing.T, db *sql.DB) []metricRow {
+	t.Helper()
+
+	rows, err := db.Query(`SELECT resolution, time, cpu, memory, running, samples FROM metrics
+	ORDER BY resolution, time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	result := make([]metricRow, 0)
+
+	for rows.Next() {
+		var (
+			r  metricRow
+			at int64
+		)
+
+		if err = rows.Scan(&r.resolution, &at, &r.cpu, &r.memory, &r.running, &r.samples); err != nil {
+			t.Fatal(err)
+		}
+
+		r.time = time.Unix(at, 0).UTC()
+		result = append(result, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func checkMetricRows(t *testing.T, got, want []metricRow) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("rows = %+v, want %+v", got, want)
+	}
+
+	for i := range got {
+		g, w := got[i], want[i]
+		if g.resolution != w.resolution || !g.time.Equal(w.time) || g.samples != w.samples ||
+			math.Abs(g.cpu-w.cpu) > 1e-9 || math.Abs(g.memory-w.memory) > 1e-9 ||
+			math.Abs(g.running-w.running) > 1e-9 {
+			t.Errorf("row %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestMetricCompact(t *testing.T) {
+	db := newTestDB(t)
+	r := New(db).Metric(context.Background())
+
+	running := string(model.ServerStateRunning)
+	stopped := string(model.ServerStateStopped)
+
+	vm := func(state string, cpu int, memory float64) []model.Server {
+		return []model.Server{{VMID: "1", Name: "vm1", HV: "hv1", State: state, CpuLoad: cpu,
+			MemoryAssigned: memory}}
+	}
+
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+	recent := now.Add(-time.Hour)
+
+	samples := []struct {
+		servers []model.Server
+		at      time.Time
+	}{
+		{vm(running, 10, 2), old},
+		{vm(stopped, 30, 4), old.Add(time.Minute)},
+		{vm(running, 50, 6), old.Add(5 * time.Minute)},
+		{vm(running, 10, 2), recent},
+	}
+
+	for _, s := range samples {
+		if err := r.Create(s.servers, s.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// замеры старше суток усредняются по 5 минут с учетом числа замеров
+	afterDay := []metricRow{
+		{0, recent, 10, 2, 1, 1},
+		{300, old, 20, 3, 0.5, 2},
+		{300, old.Add(5 * time.Minute), 50, 6, 1, 1},
+	}
+
+	if err := r.Compact(now); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMetricRows(t, metricRows(t, db), afterDay)
+
+	// повторное сжатие ничего не меняет
+	if err := r.Compact(now); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMetricRows(t, metricRows(t, db), afterDay)
+
+	// среднее за шаг не зависит от того, на каком уровне хранятся показатели
+	points, err := r.Find("hv1", "vm1", old, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(points) != 2 || !points[0].Time.Equal(old) || points[0].Samples != 3 ||
+		math.Abs(points[0].CPU-30) > 1e-9 || math.Abs(points[0].Running-2.0/3) > 1e-9 {
+		t.Fatalf("points = %+v", points)
+	}
+
+	// 5-минутные значения старше 30 дней усредняются по часу
+	if err = r.Compact(now.Add(30 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMetricRows(t, metricRows(t, db), []metricRow{
+		{3600, old, 30, 4, 2.0 / 3, 3},
+		{3600, recent, 10, 2, 1, 1},
+	})
+
+	// часовые значения хранятся год
+	if err = r.Compact(old.Add(366 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMetricRows(t, metricRows(t, db), []metricRow{{3600, recent, 10, 2, 1, 1}})
+}
+
+func TestMetricCompactPartialStep(t *testing.T) {
+	db := newTestDB(t)
+	r := New(db).Metric(context.Background())
+
+	now := time.Date(2021, 6, 15, 12, 2, 0, 0, time.UTC)
+	server := []model.Server{{VMID: "1", Name: "vm1", HV: "hv1", CpuLoad: 10}}
+
+	// граница суток приходится на середину 5-минутного шага, шаг не усредняется, пока не устареет целиком
+	for _, at := range []time.Time{now.Add(-24*time.Hour - time.Minute), now.Add(-24*time.Hour + time.Minute)} {
+		if err := r.Create(server, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Compact(now); err != nil {
+		t.Fatal(err)
+	}
+
+	if rows := metricRows(t, db); len(rows) != 2 || rows[0].resolution != 0 || rows[1].resolution != 0 {
+		t.Fatalf("rows = %+v, want both samples kept", rows)
+	}
+
+	if err := r.Compact(now.Add(5 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMetricRows(t, metricRows(t, db), []metricRow{
+		{300, now.Add(-24*time.Hour - 2*time.Minute), 10, 0, 0, 2},
+	})
+}
@@ -0,0 +1,222 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// Ограничения количества выполнений расписания в одном ответе
+const (
+	defaultScheduleRunsLimit = 50
+	maxScheduleRunsLimit     = 500
+)
+
+// scheduleQuery выбирает расписания вместе с почтой автора и текущим местом сервера в порядке scanSchedule
+const scheduleQuery = `SELECT s.id, s.user_id, COALESCE(u.email, ''), s.server_id, COALESCE(srv.hv, ''),
+	COALESCE(srv.title, ''), s.command, s.adapter, s.cron, s.timezone, s.missed, s.enabled, s.next_run_at,
+	s.last_run_at, s.created_at, s.updated_at
+	FROM schedules AS s LEFT JOIN servers AS srv ON (srv.id = s.server_id) LEFT JOIN users AS u ON (u.id = s.user_id)`
+
+// ScheduleRepository - содержит методы работы с расписаниями команд.
+type ScheduleRepository struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// Create добавляет расписание, возвращает его ID либо ошибку.
+func (r *ScheduleRepository) Create(s model.Schedule) (int64, error) {
+	query := `INSERT INTO schedules (user_id, server_id, command, adapter, cron, timezone, missed, enabled,
+	next_run_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now().UTC()
+
+	result, err := r.db.ExecContext(r.ctx, query, s.UserID, s.ServerID, s.Command, s.Adapter, s.Cron, s.Timezone,
+		s.Missed, s.Enabled, utcTime(s.NextRunAt), now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// Update изменяет команду, выражение cron, часовой пояс, правило пропущенных выполнений
+// и следующее выполнение расписания.
+func (r *ScheduleRepository) Update(s model.Schedule) error {
+	query := `UPDATE schedules SET server_id = ?, command = ?, adapter = ?, cron = ?, timezone = ?, missed = ?,
+	enabled = ?, next_run_at = ?, updated_at = ? WHERE id = ?`
+
+	_, err := r.db.ExecContext(r.ctx, query, s.ServerID, s.Command, s.Adapter, s.Cron, s.Timezone, s.Missed,
+		s.Enabled, utcTime(s.NextRunAt), time.Now().UTC(), s.ID)
+
+	return err
+}
+
+// Delete удаляет расписание id вместе с историей выполнений.
+func (r *ScheduleRepository) Delete(id int64) error {
+	tx, err := r.db.BeginTx(r.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(r.ctx, "DELETE FROM schedule_runs WHERE schedule_id = ?", id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.ExecContext(r.ctx, "DELETE FROM schedules WHERE id = ?", id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Find возвращает расписание по его ID, если его нет - sql.ErrNoRows.
+func (r *ScheduleRepository) Find(id int64) (model.Schedule, error) {
+	return scanSchedule(r.db.QueryRowContext(r.ctx, scheduleQuery+" WHERE s.id = ?", id))
+}
+
+// FindByUser возвращает расписания пользователя userID, если он пуст - всех пользователей.
+func (r *ScheduleRepository) FindByUser(userID string) ([]model.Schedule, error) {
+	if userID == "" {
+		return r.query(scheduleQuery + " ORDER BY s.id")
+	}
+
+	return r.query(scheduleQuery+" WHERE s.user_id = ? ORDER BY s.id", userID)
+}
+
+// FindDue возвращает включенные расписания, время выполнения которых наступило к now.
+func (r *ScheduleRepository) FindDue(now time.Time) ([]model.Schedule, error) {
+	return r.query(scheduleQuery+" WHERE s.enabled = 1 AND s.next_run_at <= ? ORDER BY s.next_run_at",
+		now.UTC())
+}
+
+// SetNextRun задает следующее выполнение расписания id, если next равен nil - расписание больше
+// не выполняется. Если lastRun не nil, он сохраняется как время последнего выполнения.
+func (r *ScheduleRepository) SetNextRun(id int64, next, lastRun *time.Time) error {
+	query := "UPDATE schedules SET next_run_at = ?, last_run_at = COALESCE(?, last_run_at) WHERE id = ?"
+
+	_, err := r.db.ExecContext(r.ctx, query, utcTime(next), utcTime(lastRun), id)
+
+	return err
+}
+
+// CreateRun сохраняет выполнение расписания.
+func (r *ScheduleRepository) CreateRun(run model.ScheduleRun) error {
+	var jobID sql.NullInt64
+	if run.JobID != 0 {
+		jobID = sql.NullInt64{Int64: run.JobID, Valid: true}
+	}
+
+	_, err := r.db.ExecContext(r.ctx,
+		`INSERT INTO schedule_runs (schedule_id, job_id, scheduled_at, created_at, state, error)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		run.ScheduleID, jobID, run.ScheduledAt.UTC(), time.Now().UTC(), run.State, run.Error)
+
+	return err
+}
+
+// Runs возвращает выполнения расписания scheduleID, начиная с последнего, с состоянием и ошибкой
+// их заданий.
+func (r *ScheduleRepository) Runs(scheduleID int64, limit int) ([]model.ScheduleRun, error) {
+	runs := make([]model.ScheduleRun, 0)
+
+	if limit <= 0 {
+		limit = defaultScheduleRunsLimit
+	}
+
+	if limit > maxScheduleRunsLimit {
+		limit = maxScheduleRunsLimit
+	}
+
+	query := `SELECT r.id, r.schedule_id, COALESCE(r.job_id, 0), r.scheduled_at, r.created_at,
+	COALESCE(j.state, r.state), COALESCE(j.error, r.error)
+	FROM schedule_runs AS r LEFT JOIN jobs AS j ON (j.id = r.job_id)
+	WHERE r.schedule_id = ? ORDER BY r.id DESC LIMIT ?`
+
+	rows, err := r.db.QueryContext(r.ctx, query, scheduleID, limit)
+	if err != nil {
+		return runs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var run model.ScheduleRun
+
+		err = rows.Scan(&run.ID, &run.ScheduleID, &run.JobID, &run.ScheduledAt, &run.CreatedAt, &run.State,
+			&run.Error)
+		if err != nil {
+			return runs, err
+		}
+
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// DeleteRunsBefore удаляет выполнения расписаний, созданные раньше before.
+func (r *ScheduleRepository) DeleteRunsBefore(before time.Time) error {
+	_, err := r.db.ExecContext(r.ctx, "DELETE FROM schedule_runs WHERE created_at < ?", before.UTC())
+
+	return err
+}
+
+// query возвращает расписания по запросу на основе scheduleQuery
+func (r *ScheduleRepository) query(query string, args ...interface{}) ([]model.Schedule, error) {
+	schedules := make([]model.Schedule, 0)
+
+	rows, err := r.db.QueryContext(r.ctx, query, args...)
+	if err != nil {
+		return schedules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return schedules, err
+		}
+
+		schedules = append(schedules, s)
+	}
+
+	return schedules, rows.Err()
+}
+
+// scanSchedule читает расписание из строки результата запроса
+func scanSchedule(row interface{ Scan(...interface{}) error }) (model.Schedule, error) {
+	var (
+		s         model.Schedule
+		nextRunAt sql.NullTime
+		lastRunAt sql.NullTime
+	)
+
+	err := row.Scan(&s.ID, &s.UserID, &s.UserEmail, &s.ServerID, &s.HV, &s.Server, &s.Command, &s.Adapter,
+		&s.Cron, &s.Timezone, &s.Missed, &s.Enabled, &nextRunAt, &lastRunAt, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return s, err
+	}
+
+	if nextRunAt.Valid {
+		s.NextRunAt = &nextRunAt.Time
+	}
+
+	if lastRunAt.Valid {
+		s.LastRunAt = &lastRunAt.Time
+	}
+
+	return s, nil
+}
+
+// utcTime возвращает t в UTC для записи в БД, nil записывается как NULL
+func utcTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+
+	return t.UTC()
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+func TestScheduleSetNextRun(t *testing.T) {
+	r := New(newTestDB(t)).Schedule(context.Background())
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	next := now.Add(time.Hour)
+
+	id, err := r.Create(model.Schedule{UserID: "1", ServerID: 1, Command: "start_power", Cron: "0 * * * *",
+		Timezone: "UTC", Missed: model.ScheduleMissedSkip, Enabled: true, NextRunAt: &now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(wantNext, wantLast *time.Time) {
+		t.Helper()
+
+		sc, err := r.Find(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (sc.NextRunAt == nil) != (wantNext == nil) || (wantNext != nil && !sc.NextRunAt.Equal(*wantNext)) {
+			t.Errorf("next run = %v, want %v", sc.NextRunAt, wantNext)
+		}
+
+		if (sc.LastRunAt == nil) != (wantLast == nil) || (wantLast != nil && !sc.LastRunAt.Equal(*wantLast)) {
+			t.Errorf("last run = %v, want %v", sc.LastRunAt, wantLast)
+		}
+	}
+
+	if err = r.SetNextRun(id, &next, &now); err != nil {
+		t.Fatal(err)
+	}
+
+	check(&next, &now)
+
+	// пропущенное выполнение не меняет время последнего выполнения
+	later := next.Add(time.Hour)
+	if err = r.SetNextRun(id, &later, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	check(&later, &now)
+
+	if due, err := r.FindDue(later.Add(-time.Second)); err != nil || len(due) != 0 {
+		t.Errorf("due before next run = %+v, %v", due, err)
+	}
+
+	if due, err := r.FindDue(later); err != nil || len(due) != 1 {
+		t.Errorf("due at next run = %+v, %v", due, err)
+	}
+
+	// расписание без следующего выполнения больше не выполняется
+	if err = r.SetNextRun(id, nil, &later); err != nil {
+		t.Fatal(err)
+	}
+
+	check(nil, &later)
+
+	if due, err := r.FindDue(later.Add(24 * time.Hour)); err != nil || len(due) != 0 {
+		t.Errorf("due without next run = %+v, %v", due, err)
+	}
+}
@@ -3,7 +3,6 @@ package store
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 
 	"github.com/anaxita/logit"
@@ -23,7 +22,7 @@ func (r *ServerRepository) Find(key, value interface{}) (model.Server, error) {
 	var s model.Server
 
 	query := fmt.Sprintf(
-		"SELECT id, vmid, title, ip4, hv, company, out_addr, description, user_name, user_password FROM servers WHERE %s = ?",
+		"SELECT id, vmid, title, ip4, hv, company, out_addr, description, user_name, user_password, switch_name FROM servers WHERE %s = ?",
 		key)
 
 	if err := r.db.QueryRowContext(r.ctx, query, value).Scan(
@@ -32,13 +31,14 @@ func (r *ServerRepository) Find(key, value interface{}) (model.Server, error) {
 		&s.Name,
 		&s.IP,
 		&s.HV,
-		&s.OutAddr,
 		&s.Company,
+		&s.OutAddr,
 		&s.Description,
 		&s.User,
 		&s.Password,
+		&s.Switch,
 	); err != nil {
-		return s, errors.New(err.Error())
+		return s, err
 	}
 
 	logit.Info("Нашли сервер:", key, value)
@@ -52,11 +52,20 @@ func (r *ServerRepository) FindByHvAndName(hv, name string) (model.Server, error
 
 	var s model.Server
 
-	query := "SELECT ip4, user_name, user_password FROM servers WHERE hv = ? AND title = ?"
+	query := `SELECT id, vmid, title, ip4, hv, company, out_addr, description, user_name, user_password,
+	switch_name FROM servers WHERE hv = ? AND title = ?`
 	if err := r.db.QueryRowContext(r.ctx, query, hv, name).Scan(
+		&s.ID,
+		&s.VMID,
+		&s.Name,
 		&s.IP,
+		&s.HV,
+		&s.Company,
+		&s.OutAddr,
+		&s.Description,
 		&s.User,
 		&s.Password,
+		&s.Switch,
 	); err != nil {
 		return s, err
 	}
@@ -70,13 +79,14 @@ func (r *ServerRepository) FindByHvAndName(hv, name string) (model.Server, error
 func (r *ServerRepository) Create(s model.Server) (int, error) {
 	logit.Info("Создаем сервер:", s.Name)
 
-	query := `INSERT INTO servers (vmid, title, ip4, hv, company, user_name, user_password) 
-    VALUES (?, ?, ?, ?, ?, ?, ?) 
+	query := `INSERT INTO servers (vmid, title, ip4, hv, company, description, user_name, user_password) 
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?) 
     on conflict (title, hv) 
         DO UPDATE SET title = ?, ip4 = ?, hv = ?`
 
 	result, err := r.db.ExecContext(
-		r.ctx, query, s.VMID, s.Name, s.IP, s.HV, s.Company, s.User, s.Password, s.Name, s.IP, s.HV)
+		r.ctx, query, s.VMID, s.Name, s.IP, s.HV, s.Company, s.Description, s.User, s.Password, s.Name, s.IP,
+		s.HV)
 	if err != nil {
 		return 0, err
 	}
@@ -89,6 +99,81 @@ func (r *ServerRepository) Create(s model.Server) (int, error) {
 	return int(id), nil
 }
 
+// SetSwitch задает коммутатор, к которому подключается сеть сервера id.
+// Пустое имя означает коммутатор по умолчанию.
+func (r *ServerRepository) SetSwitch(id int64, switchName string) error {
+	logit.Info("Меняем коммутатор сервера", id, switchName)
+
+	_, err := r.db.ExecContext(r.ctx, "UPDATE servers SET switch_name = ? WHERE id = ?", switchName, id)
+
+	return err
+}
+
+// Move переносит сервер id на гипервизор hv, сохраняя ID, поэтому доступы пользователей сохраняются.
+// Если на hv уже есть запись этого сервера (ее могло создать обновление списка серверов),
+// доступы и метки к ней переносятся на сервер id, а сама запись удаляется.
+func (r *ServerRepository) Move(id int64, hv string) error {
+	logit.Info("Переносим сервер", id, "на", hv)
+
+	tx, err := r.db.BeginTx(r.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				logit.Log(err)
+			}
+		}
+	}()
+
+	// транзакция начинается с записи: если начать с чтения, SQLite не ждет другую запись,
+	// а сразу возвращает "database is locked"
+	duplicate := `(SELECT d.id FROM servers AS d INNER JOIN servers AS s ON (d.title = s.title)
+		WHERE s.id = ? AND d.hv = ? AND d.id <> s.id)`
+
+	_, err = tx.ExecContext(r.ctx,
+		`INSERT INTO users_servers (user_id, server_id) SELECT user_id, ? FROM users_servers AS us
+		WHERE us.server_id = `+duplicate+`
+		AND NOT EXISTS (SELECT 1 FROM users_servers WHERE user_id = us.user_id AND server_id = ?)`,
+		id, id, hv, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(r.ctx, "DELETE FROM users_servers WHERE server_id = "+duplicate, id, hv); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(r.ctx,
+		"INSERT OR IGNORE INTO server_tags (server_id, tag) SELECT ?, tag FROM server_tags WHERE server_id = "+duplicate,
+		id, id, hv)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(r.ctx, "DELETE FROM server_tags WHERE server_id = "+duplicate, id, hv); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(r.ctx, "DELETE FROM servers WHERE id = "+duplicate, id, hv); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(r.ctx, "UPDATE servers SET hv = ? WHERE id = ?", hv, id); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	logit.Info("Сервер перенесен", id, hv)
+
+	return nil
+}
+
 // DeleteByUser удаляет доступ к серверам у определенного пользователя, возвращает ошибку в случае неудачи.
 func (r *ServerRepository) DeleteByUser(userID string) error {
 	logit.Info("Удаляем сервера у пользователя", userID)
@@ -108,7 +193,7 @@ func (r *ServerRepository) All() ([]model.Server, error) {
 	var servers []model.Server
 
 	rows, err := r.db.QueryContext(r.ctx,
-		"SELECT id, vmid, title, ip4, hv, company, user_name, user_password FROM servers")
+		"SELECT id, vmid, title, ip4, hv, company, user_name, user_password, switch_name FROM servers")
 	if err != nil {
 		return servers, err
 	}
@@ -122,7 +207,8 @@ func (r *ServerRepository) All() ([]model.Server, error) {
 
 	for rows.Next() {
 		var s model.Server
-		err := rows.Scan(&s.ID, &s.VMID, &s.Name, &s.IP, &s.HV, &s.Company, &s.User, &s.Password)
+		err := rows.Scan(&s.ID, &s.VMID, &s.Name, &s.IP, &s.HV, &s.Company, &s.User, &s.Password,
+			&s.Switch)
 		if err != nil {
 			return servers, err
 		}
@@ -145,7 +231,7 @@ func (r *ServerRepository) FindByUser(userID string) ([]model.Server, error) {
 	var servers []model.Server
 
 	rows, err := r.db.QueryContext(r.ctx,
-		"SELECT s.id, s.vmid, s.title, s.ip4, s.hv, s.company, s.description, s.out_addr, s.user_name, s.user_password FROM servers as s INNER JOIN users_servers as us ON (s.id = us.server_ID) WHERE us.user_id = ?",
+		"SELECT s.id, s.vmid, s.title, s.ip4, s.hv, s.company, s.description, s.out_addr, s.user_name, s.user_password, s.switch_name FROM servers as s INNER JOIN users_servers as us ON (s.id = us.server_ID) WHERE us.user_id = ?",
 		userID)
 	if err != nil {
 		return servers, err
@@ -161,7 +247,7 @@ func (r *ServerRepository) FindByUser(userID string) ([]model.Server, error) {
 	for rows.Next() {
 		var s model.Server
 		err := rows.Scan(&s.ID, &s.VMID, &s.Name, &s.IP, &s.HV, &s.Company, &s.Description,
-			&s.OutAddr, &s.User, &s.Password)
+			&s.OutAddr, &s.User, &s.Password, &s.Switch)
 
 		if err != nil {
 			return servers, err
@@ -178,3 +264,72 @@ func (r *ServerRepository) FindByUser(userID string) ([]model.Server, error) {
 
 	return servers, err
 }
+
+// Tags возвращает метки сервера id по алфавиту.
+func (r *ServerRepository) Tags(id int64) ([]string, error) {
+	tags := make([]string, 0)
+
+	rows, err := r.db.QueryContext(r.ctx, "SELECT tag FROM server_tags WHERE server_id = ? ORDER BY tag", id)
+	if err != nil {
+		return tags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag string
+		if err = rows.Scan(&tag); err != nil {
+			return tags, err
+		}
+
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// SetTags заменяет метки сервера id на tags.
+func (r *ServerRepository) SetTags(id int64, tags []string) error {
+	logit.Info("Меняем метки сервера", id, tags)
+
+	tx, err := r.db.BeginTx(r.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(r.ctx, "DELETE FROM server_tags WHERE server_id = ?", id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = tx.ExecContext(r.ctx, "INSERT OR IGNORE INTO server_tags (server_id, tag) VALUES (?, ?)", id, tag)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FindIDsByTag возвращает ID серверов с меткой tag без учета регистра.
+func (r *ServerRepository) FindIDsByTag(tag string) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+
+	rows, err := r.db.QueryContext(r.ctx, "SELECT server_id FROM server_tags WHERE tag = ?", tag)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return ids, err
+		}
+
+		ids[id] = true
+	}
+
+	return ids, rows.Err()
+}
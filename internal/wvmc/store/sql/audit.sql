@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `audit` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `user_id` varchar(255) NOT NULL DEFAULT "",
+  `user_email` varchar(255) NOT NULL DEFAULT "",
+  `hv` varchar(255) NOT NULL DEFAULT "",
+  `server` varchar(255) NOT NULL DEFAULT "",
+  `action` varchar(255) NOT NULL,
+  `details` text NOT NULL DEFAULT ""
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `cache_hosts` (
+  `hv` varchar(255) NOT NULL PRIMARY KEY COLLATE NOCASE,
+  `updated_at` datetime NOT NULL,
+  `servers` text NOT NULL
+);
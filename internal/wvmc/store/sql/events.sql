@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `events` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime NOT NULL,
+  `type` varchar(255) NOT NULL,
+  `source` varchar(255) NOT NULL DEFAULT "",
+  `hv` varchar(255) NOT NULL DEFAULT "",
+  `vmid` varchar(255) NOT NULL DEFAULT "",
+  `server` varchar(255) NOT NULL DEFAULT "",
+  `old_value` varchar(255) NOT NULL DEFAULT "",
+  `new_value` varchar(255) NOT NULL DEFAULT ""
+);
+CREATE INDEX IF NOT EXISTS `events_created_at` ON `events` (`created_at`);
+CREATE INDEX IF NOT EXISTS `events_server` ON `events` (`hv`, `server`);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `hypervs` (
+  `name` varchar(255) PRIMARY KEY,
+  `ip4` varchar(255) NOT NULL DEFAULT '0.0.0.0',
+  `enabled` integer NOT NULL DEFAULT 1,
+  `user` varchar(255) NOT NULL DEFAULT "",
+  `password` varchar(255) NOT NULL DEFAULT ""
+);
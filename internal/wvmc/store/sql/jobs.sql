@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS `jobs` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `user_id` varchar(255) NOT NULL DEFAULT "",
+  `user_email` varchar(255) NOT NULL DEFAULT "",
+  `kind` varchar(255) NOT NULL,
+  `command` varchar(255) NOT NULL DEFAULT "",
+  `hv` varchar(255) NOT NULL DEFAULT "",
+  `server` varchar(255) NOT NULL DEFAULT "",
+  `state` varchar(255) NOT NULL,
+  `output` text NOT NULL DEFAULT "",
+  `error` text NOT NULL DEFAULT "",
+  `created_at` datetime NOT NULL,
+  `started_at` datetime,
+  `finished_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `jobs_user` ON `jobs` (`user_id`, `id`);
+CREATE INDEX IF NOT EXISTS `jobs_state` ON `jobs` (`state`);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `metrics` (
+  `hv` varchar(255) NOT NULL,
+  `vmid` varchar(255) NOT NULL DEFAULT "",
+  `server` varchar(255) NOT NULL,
+  `resolution` integer NOT NULL DEFAULT 0,
+  `time` integer NOT NULL,
+  `cpu` real NOT NULL DEFAULT 0,
+  `memory` real NOT NULL DEFAULT 0,
+  `running` real NOT NULL DEFAULT 0,
+  `samples` integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS `metrics_server` ON `metrics` (`hv`, `server`, `time`);
+CREATE INDEX IF NOT EXISTS `metrics_resolution` ON `metrics` (`resolution`, `time`);
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS `schedules` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `user_id` varchar(255) NOT NULL,
+  `server_id` INTEGER NOT NULL,
+  `command` varchar(255) NOT NULL,
+  `adapter` varchar(255) NOT NULL DEFAULT "",
+  `cron` varchar(255) NOT NULL,
+  `timezone` varchar(255) NOT NULL,
+  `missed` varchar(255) NOT NULL DEFAULT "skip",
+  `enabled` INTEGER NOT NULL DEFAULT 1,
+  `next_run_at` datetime,
+  `last_run_at` datetime,
+  `created_at` datetime NOT NULL,
+  `updated_at` datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `schedules_next_run` ON `schedules` (`enabled`, `next_run_at`);
+CREATE TABLE IF NOT EXISTS `schedule_runs` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `schedule_id` INTEGER NOT NULL,
+  `job_id` INTEGER,
+  `scheduled_at` datetime NOT NULL,
+  `created_at` datetime NOT NULL,
+  `state` varchar(255) NOT NULL,
+  `error` text NOT NULL DEFAULT ""
+);
+CREATE INDEX IF NOT EXISTS `schedule_runs_schedule` ON `schedule_runs` (`schedule_id`, `id`);
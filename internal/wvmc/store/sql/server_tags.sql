@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `server_tags` (
+  `server_id` INTEGER NOT NULL,
+  `tag` varchar(255) NOT NULL COLLATE NOCASE,
+  PRIMARY KEY (server_id, tag)
+);
@@ -10,5 +10,6 @@ CREATE TABLE IF NOT EXISTS `servers` (
   `company` varchar(255) NOT NULL DEFAULT "",
   `user_name` varchar(255) NOT NULL DEFAULT "",
   `user_password` varchar(255) NOT NULL DEFAULT "",
+  `switch_name` varchar(255) NOT NULL DEFAULT "",
   UNIQUE (title, hv)
 );
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `templates` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `name` varchar(255) NOT NULL,
+  `hv` varchar(255) NOT NULL,
+  `vhdx_path` varchar(255) NOT NULL,
+  `disk_type` varchar(255) NOT NULL DEFAULT "differencing",
+  `generation` int NOT NULL DEFAULT 2,
+  `cpu_cores` int NOT NULL DEFAULT 2,
+  `memory` int NOT NULL DEFAULT 4096,
+  `switch_name` varchar(255) NOT NULL DEFAULT "",
+  `storage_path` varchar(255) NOT NULL DEFAULT "",
+  UNIQUE (`name`)
+);
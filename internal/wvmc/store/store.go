@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"embed"
 	"fmt"
 	"os"
 	"time"
@@ -13,6 +14,24 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// migrations содержит sql файлы создания таблиц
+//
+//go:embed sql/*.sql
+var migrations embed.FS
+
+// columns колонки, добавленные в таблицы после их создания.
+// В новой БД они создаются sql файлами, в существующую добавляются при миграции.
+var columns = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{"servers", "switch_name", `varchar(255) NOT NULL DEFAULT ""`},
+	{"hypervs", "enabled", `integer NOT NULL DEFAULT 1`},
+	{"hypervs", "user", `varchar(255) NOT NULL DEFAULT ""`},
+	{"hypervs", "password", `varchar(255) NOT NULL DEFAULT ""`},
+}
+
 // Store содержит в себе подключение к базе данных и репозитории
 type Store struct {
 	db *sql.DB
@@ -62,15 +81,87 @@ func (s *Store) Server(c context.Context) *ServerRepository {
 	}
 }
 
+// Audit возвращает указатель на AuditRepository
+func (s *Store) Audit(c context.Context) *AuditRepository {
+	return &AuditRepository{
+		db:  s.db,
+		ctx: c,
+	}
+}
+
+// Template возвращает указатель на TemplateRepository
+func (s *Store) Template(c context.Context) *TemplateRepository {
+	return &TemplateRepository{
+		db:  s.db,
+		ctx: c,
+	}
+}
+
+// Hyperv возвращает указатель на HypervRepository
+func (s *Store) Hyperv(c context.Context) *HypervRepository {
+	return &HypervRepository{
+		db:  s.db,
+		ctx: c,
+	}
+}
+
+// Event возвращает указатель на EventRepository
+func (s *Store) Event(c context.Context) *EventRepository {
+	return &EventRepository{
+		db:  s.db,
+		ctx: c,
+	}
+}
+
+// Metric возвращает указатель на MetricRepository
+func (s *Store) Metric(c context.Context) *MetricRepository {
+	return &MetricRepository{
+		db:  s.db,
+		ctx: c,
+	}
+}
+
+// Cache возвращает указатель на CacheRepository
+func (s *Store) Cache(c context.Context) *CacheRepository {
+	return &CacheRepository{
+		db:  s.db,
+		ctx: c,
+	}
+}
+
+// Job возвращает указатель на JobRepository
+func (s *Store) Job(c context.Context) *JobRepository {
+	return &JobRepository{
+		db:  s.db,
+		ctx: c,
+	}
+}
+
+// Schedule возвращает указатель на ScheduleRepository
+func (s *Store) Schedule(c context.Context) *ScheduleRepository {
+	return &ScheduleRepository{
+		db:  s.db,
+		ctx: c,
+	}
+}
+
 // Migrate создает таблицы в БД, если их еще не существует
 func Migrate(db *sql.DB) error {
 	logit.Info("Выполняем миграции ...")
 
-	createUsersTable, _ := os.ReadFile("./sql/users.sql")
-	createServersTable, _ := os.ReadFile("./sql/servers.sql")
-	createUsersServersTable, _ := os.ReadFile("./sql/users_servers.sql")
-	createRefreshTokkensTable, _ := os.ReadFile("./sql/refresh_tokens.sql")
-	createHypervsTable, _ := os.ReadFile("./sql/hypervs.sql")
+	createUsersTable, _ := migrations.ReadFile("sql/users.sql")
+	createServersTable, _ := migrations.ReadFile("sql/servers.sql")
+	createUsersServersTable, _ := migrations.ReadFile("sql/users_servers.sql")
+	createRefreshTokkensTable, _ := migrations.ReadFile("sql/refresh_tokens.sql")
+	createHypervsTable, _ := migrations.ReadFile("sql/hypervs.sql")
+	createAuditTable, _ := migrations.ReadFile("sql/audit.sql")
+	createTemplatesTable, _ := migrations.ReadFile("sql/templates.sql")
+	createEventsTable, _ := migrations.ReadFile("sql/events.sql")
+	createMetricsTable, _ := migrations.ReadFile("sql/metrics.sql")
+	createCacheHostsTable, _ := migrations.ReadFile("sql/cache_hosts.sql")
+	createJobsTable, _ := migrations.ReadFile("sql/jobs.sql")
+	createServerTagsTable, _ := migrations.ReadFile("sql/server_tags.sql")
+	createSchedulesTable, _ := migrations.ReadFile("sql/schedules.sql")
 
 	_, err := db.Exec(string(createUsersTable))
 	if err != nil {
@@ -108,6 +199,96 @@ func Migrate(db *sql.DB) error {
 		return err
 	}
 
+	_, err = db.Exec(string(createAuditTable))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(string(createTemplatesTable))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(string(createEventsTable))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(string(createMetricsTable))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(string(createCacheHostsTable))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(string(createJobsTable))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(string(createServerTagsTable))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(string(createSchedulesTable))
+	if err != nil {
+		return err
+	}
+
+	for _, c := range columns {
+		if err = addColumn(db, c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
 	logit.Info("Миграции выполнены успешно")
 	return nil
 }
+
+// addColumn добавляет колонку name в таблицу table, если ее еще нет
+func addColumn(db *sql.DB, table, name, definition string) error {
+	exists, err := hasColumn(db, table, name)
+	if err != nil || exists {
+		return err
+	}
+
+	logit.Info("Добавляем колонку", table, name)
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", table, name, definition))
+
+	return err
+}
+
+// hasColumn проверяет, есть ли в таблице table колонка name
+func hasColumn(db *sql.DB, table, name string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(`%s`)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			column     string
+			columnType string
+			notNull    int
+			dflt       sql.NullString
+			pk         int
+		)
+
+		if err = rows.Scan(&cid, &column, &columnType, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+
+		if column == name {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
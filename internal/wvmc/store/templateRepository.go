@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anaxita/logit"
+	"github.com/anaxita/wvmc/internal/wvmc/model"
+)
+
+// TemplateRepository - содержит методы работы с шаблонами ВМ.
+type TemplateRepository struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// templateColumns колонки таблицы templates в порядке полей scan
+const templateColumns = "id, name, hv, vhdx_path, disk_type, generation, cpu_cores, memory, switch_name, storage_path"
+
+// Find ищет шаблон по ID, возвращает модель либо ошибку.
+func (r *TemplateRepository) Find(id int64) (model.Template, error) {
+	logit.Info("Ищем шаблон:", id)
+
+	row := r.db.QueryRowContext(r.ctx, "SELECT "+templateColumns+" FROM templates WHERE id = ?", id)
+
+	return scanTemplate(row)
+}
+
+// All возвращает все шаблоны, отсортированные по имени, либо ошибку.
+func (r *TemplateRepository) All() ([]model.Template, error) {
+	logit.Info("Получаем все шаблоны")
+
+	templates := make([]model.Template, 0)
+
+	rows, err := r.db.QueryContext(r.ctx, "SELECT "+templateColumns+" FROM templates ORDER BY name")
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return templates, err
+		}
+
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// Create создает шаблон и возвращает его ID, либо ошибку.
+func (r *TemplateRepository) Create(t model.Template) (int64, error) {
+	logit.Info("Создаем шаблон:", t.Name)
+
+	query := `INSERT INTO templates (name, hv, vhdx_path, disk_type, generation, cpu_cores, memory, switch_name,
+	storage_path) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := r.db.ExecContext(r.ctx, query, t.Name, t.HV, t.VHDXPath, t.DiskType, t.Generation,
+		t.CPUCores, t.Memory, t.Switch, t.StoragePath)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// Edit обновляет все поля шаблона t.ID, возвращает ошибку в случае неудачи.
+func (r *TemplateRepository) Edit(t model.Template) error {
+	logit.Info("Обновляем шаблон:", t.ID, t.Name)
+
+	query := `UPDATE templates SET name = ?, hv = ?, vhdx_path = ?, disk_type = ?, generation = ?, cpu_cores = ?,
+	memory = ?, switch_name = ?, storage_path = ? WHERE id = ?`
+
+	_, err := r.db.ExecContext(r.ctx, query, t.Name, t.HV, t.VHDXPath, t.DiskType, t.Generation,
+		t.CPUCores, t.Memory, t.Switch, t.StoragePath, t.ID)
+
+	return err
+}
+
+// Delete удаляет шаблон, возвращает ошибку в случае неудачи.
+func (r *TemplateRepository) Delete(id int64) error {
+	logit.Info("Удаляем шаблон", id)
+
+	_, err := r.db.ExecContext(r.ctx, "DELETE FROM templates WHERE id = ?", id)
+
+	return err
+}
+
+// scanTemplate читает шаблон из строки результата запроса
+func scanTemplate(row interface{ Scan(...interface{}) error }) (model.Template, error) {
+	var t model.Template
+
+	err := row.Scan(&t.ID, &t.Name, &t.HV, &t.VHDXPath, &t.DiskType, &t.Generation, &t.CPUCores,
+		&t.Memory, &t.Switch, &t.StoragePath)
+
+	return t, err
+}